@@ -0,0 +1,135 @@
+package breaker
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"christianmoore.me/avatar-backend/metrics"
+)
+
+// ErrOpen is returned by Do while the breaker is rejecting calls
+var ErrOpen = errors.New("circuit breaker open")
+
+// Breaker states reported by State
+const (
+	StateClosed   = "closed"    // Calls pass through
+	StateOpen     = "open"      // Calls are rejected until the cooldown passes
+	StateHalfOpen = "half_open" // One trial call is allowed to probe recovery
+)
+
+// Breaker stops sending traffic to an upstream after consecutive failures and lets a
+// single trial call through once the cooldown has passed
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool // A half-open trial call is in flight
+}
+
+// New creates a breaker that opens after threshold consecutive failures for cooldown
+func New(name string, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     StateClosed,
+	}
+}
+
+// Name returns the upstream the breaker protects
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current breaker state
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// advance moves an open breaker to half-open once the cooldown has passed; caller holds b.mu
+func (b *Breaker) advance() {
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = StateHalfOpen
+		b.trial = false
+	}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be followed by
+// Success, Failure or Release.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+
+	switch b.state {
+	case StateClosed:
+		return true
+	case StateHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return false
+	}
+}
+
+// Success records a successful call, closing the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateClosed {
+		log.Printf("Circuit breaker %s closed", b.name)
+	}
+	b.state = StateClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure records a failed call, opening the breaker at the threshold or when a trial fails
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == StateHalfOpen || (b.state == StateClosed && b.failures >= b.threshold) {
+		log.Printf("Circuit breaker %s opened after %d consecutive failures (cooldown %v)", b.name, b.failures, b.cooldown)
+		metrics.BreakerTrips.Add(b.name, 1)
+		b.state = StateOpen
+		b.openedAt = b.now()
+		b.trial = false
+	}
+}
+
+// Release ends a call whose outcome says nothing about the upstream (e.g. the caller
+// gave up), freeing a half-open trial slot without changing the state
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Do runs fn if the breaker allows it, recording the outcome
+func (b *Breaker) Do(fn func() error) error {
+	if !b.Allow() {
+		return ErrOpen
+	}
+	if err := fn(); err != nil {
+		b.Failure()
+		return err
+	}
+	b.Success()
+	return nil
+}
@@ -0,0 +1,100 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := New("tts", 3, time.Minute)
+	fail := func() error { return errors.New("timeout") }
+
+	for i := 0; i < 3; i++ {
+		if err := b.Do(fail); errors.Is(err, ErrOpen) {
+			t.Fatalf("Call %d rejected before reaching the threshold", i+1)
+		}
+	}
+	if b.State() != StateOpen {
+		t.Fatalf("Expected breaker to be open, got %s", b.State())
+	}
+	if err := b.Do(func() error { return nil }); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen while open, got %v", err)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := New("llm", 2, time.Minute)
+	b.Failure()
+	b.Success()
+	b.Failure()
+	if b.State() != StateClosed {
+		t.Errorf("Expected non-consecutive failures to keep the breaker closed, got %s", b.State())
+	}
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := New("realtime", 1, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	if b.Allow() {
+		t.Fatal("Expected open breaker to reject calls")
+	}
+
+	// After the cooldown a single trial call is allowed
+	now = now.Add(30 * time.Second)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("Expected half-open after cooldown, got %s", b.State())
+	}
+	if !b.Allow() {
+		t.Fatal("Expected a trial call to be allowed")
+	}
+	if b.Allow() {
+		t.Error("Expected only one concurrent trial call")
+	}
+
+	// A failed trial reopens for another cooldown
+	b.Failure()
+	if b.State() != StateOpen {
+		t.Fatalf("Expected failed trial to reopen the breaker, got %s", b.State())
+	}
+
+	// A successful trial closes it
+	now = now.Add(30 * time.Second)
+	if !b.Allow() {
+		t.Fatal("Expected a trial call after the second cooldown")
+	}
+	b.Success()
+	if b.State() != StateClosed {
+		t.Errorf("Expected successful trial to close the breaker, got %s", b.State())
+	}
+}
+
+func TestBreakerReleaseKeepsState(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := New("local", 2, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	// A released call neither resets nor adds to the failure count
+	b.Failure()
+	b.Release()
+	b.Failure()
+	if b.State() != StateOpen {
+		t.Fatalf("Expected release to keep the failure count, got %s", b.State())
+	}
+
+	// A released trial frees the slot for the next probe but doesn't close the breaker
+	now = now.Add(30 * time.Second)
+	if !b.Allow() {
+		t.Fatal("Expected a trial call after the cooldown")
+	}
+	b.Release()
+	if b.State() != StateHalfOpen {
+		t.Fatalf("Expected release to leave the breaker half-open, got %s", b.State())
+	}
+	if !b.Allow() {
+		t.Error("Expected release to free the trial slot")
+	}
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"christianmoore.me/avatar-backend/metrics"
+)
+
+// Synthesized audio is stored as <key>.pcm in the cache directory
+const audioFileExt = ".pcm"
+
+// Audio is a content-addressed cache of synthesized speech (PCM16 24kHz), held in
+// memory and optionally in a directory so it survives restarts. Both are bounded and
+// evict the least recently used audio. A nil *Audio is valid and caches nothing.
+type Audio struct {
+	dir     string // Empty for memory only
+	maxDisk int64
+
+	mu        sync.Mutex
+	memory    *lru[[]byte]
+	diskBytes int64
+}
+
+// AudioKey identifies the speech synthesized for text with a TTS model, voice and speed
+func AudioKey(model, voice string, speed float64, text string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{model, voice, strconv.FormatFloat(speed, 'g', -1, 64), text}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAudio creates an audio cache holding up to maxMemory bytes in memory and, when
+// dir is set, up to maxDisk bytes in dir (created if missing)
+func NewAudio(dir string, maxMemory int, maxDisk int64) (*Audio, error) {
+	c := &Audio{
+		dir:     dir,
+		maxDisk: maxDisk,
+		memory:  newLRU(0, maxMemory, func(pcm []byte) int { return len(pcm) }),
+	}
+	if dir == "" {
+		return c, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("audio cache directory: %w", err)
+	}
+	files, err := c.files()
+	if err != nil {
+		return nil, fmt.Errorf("audio cache directory: %w", err)
+	}
+	for _, f := range files {
+		c.diskBytes += f.size
+	}
+	log.Printf("Audio cache directory %s holds %d clips (%d bytes)", dir, len(files), c.diskBytes)
+	c.prune()
+	return c, nil
+}
+
+// Get returns cached audio for key, from memory or else from disk
+func (c *Audio) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	pcm, ok := c.memory.get(key)
+	c.mu.Unlock()
+	if ok {
+		metrics.TTSCache.Add("memory_hits", 1)
+		return pcm, true
+	}
+
+	if c.dir != "" {
+		path := c.path(key)
+		pcm, err := os.ReadFile(path)
+		if err == nil {
+			now := time.Now()
+			os.Chtimes(path, now, now) // Keep recently used clips when pruning
+			c.mu.Lock()
+			c.memory.put(key, pcm)
+			c.mu.Unlock()
+			metrics.TTSCache.Add("disk_hits", 1)
+			return pcm, true
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Warning: failed to read cached audio: %v", err)
+		}
+	}
+	metrics.TTSCache.Add("misses", 1)
+	return nil, false
+}
+
+// Put caches audio under key. Failing to write it to disk is only logged.
+func (c *Audio) Put(key string, pcm []byte) {
+	if c == nil || len(pcm) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.memory.put(key, pcm)
+	c.mu.Unlock()
+	metrics.TTSCache.Add("stores", 1)
+
+	if c.dir == "" || int64(len(pcm)) > c.maxDisk {
+		return
+	}
+	if err := c.write(key, pcm); err != nil {
+		log.Printf("Warning: failed to write cached audio: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.diskBytes += int64(len(pcm))
+	full := c.diskBytes > c.maxDisk
+	c.mu.Unlock()
+	if full {
+		c.prune()
+	}
+}
+
+// write stores a clip atomically, so a crash never leaves a truncated file behind
+func (c *Audio) write(key string, pcm []byte) error {
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(pcm)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (c *Audio) path(key string) string {
+	return filepath.Join(c.dir, key+audioFileExt)
+}
+
+type audioFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// files lists the cached clips on disk
+func (c *Audio) files() ([]audioFile, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []audioFile
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != audioFileExt {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed concurrently
+		}
+		files = append(files, audioFile{filepath.Join(c.dir, entry.Name()), info.Size(), info.ModTime()})
+	}
+	return files, nil
+}
+
+// prune deletes the least recently used clips until the directory is within maxDisk
+func (c *Audio) prune() {
+	files, err := c.files()
+	if err != nil {
+		log.Printf("Warning: failed to list cached audio: %v", err)
+		return
+	}
+	slices.SortFunc(files, func(a, b audioFile) int { return a.modTime.Compare(b.modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for _, f := range files {
+		if total <= c.maxDisk {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Warning: failed to prune cached audio: %v", err)
+			continue
+		}
+		total -= f.size
+		metrics.TTSCache.Add("disk_evictions", 1)
+	}
+	c.diskBytes = total
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAudioKey(t *testing.T) {
+	base := AudioKey("tts-1", "onyx", 1, "Hello.")
+	for _, other := range []string{
+		AudioKey("tts-2", "onyx", 1, "Hello."),
+		AudioKey("tts-1", "echo", 1, "Hello."),
+		AudioKey("tts-1", "onyx", 1.2, "Hello."),
+		AudioKey("tts-1", "onyx", 1, "Hello"),
+	} {
+		if other == base {
+			t.Error("Expected every setting and the text to change the key")
+		}
+	}
+}
+
+func TestAudioMemoryOnly(t *testing.T) {
+	c, err := NewAudio("", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("a", []byte("12345"))
+	c.Put("b", []byte("12345"))
+	if pcm, ok := c.Get("a"); !ok || string(pcm) != "12345" {
+		t.Errorf("Expected a memory hit, got %q %v", pcm, ok)
+	}
+	c.Put("c", []byte("12345")) // Evicts b, the least recently used
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+
+	var none *Audio
+	none.Put("a", []byte("1"))
+	if _, ok := none.Get("a"); ok {
+		t.Error("Expected a nil cache to store nothing")
+	}
+}
+
+func TestAudioPersistsToDisk(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tts")
+	c, err := NewAudio(dir, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("old", []byte("1234"))
+	c.Put("new", []byte("5678"))
+
+	// A new cache, e.g. after a restart, reads clips from disk
+	c, err = NewAudio(dir, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pcm, ok := c.Get("old"); !ok || string(pcm) != "1234" {
+		t.Fatalf("Expected a disk hit, got %q %v", pcm, ok)
+	}
+
+	// Over the disk limit, the least recently used clips are deleted
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "new"+audioFileExt), past, past)
+	c.Put("newest", []byte("9012"))
+	if _, err := os.Stat(filepath.Join(dir, "new"+audioFileExt)); !os.IsNotExist(err) {
+		t.Errorf("Expected the least recently used clip to be pruned, got %v", err)
+	}
+	for _, key := range []string{"old", "newest"} {
+		if _, err := os.Stat(filepath.Join(dir, key+audioFileExt)); err != nil {
+			t.Errorf("Expected %s to be kept: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cache
+
+import "container/list"
+
+// lru is a map bounded by entry count and total size that evicts the least recently
+// used entries first. It isn't safe for concurrent use; callers hold their own lock.
+type lru[V any] struct {
+	maxEntries int // 0 = unlimited
+	maxBytes   int
+	size       func(V) int
+
+	entries map[string]*list.Element // Values are *lruEntry[V]
+	order   *list.List               // Most recently used first
+	bytes   int
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRU[V any](maxEntries, maxBytes int, size func(V) int) *lru[V] {
+	return &lru[V]{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		size:       size,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns the value for key, marking it as recently used
+func (l *lru[V]) get(key string) (V, bool) {
+	el, ok := l.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruEntry[V]).value, true
+}
+
+// put stores value under key and returns how many entries were evicted to make room.
+// Values larger than maxBytes aren't stored.
+func (l *lru[V]) put(key string, value V) (evicted int) {
+	if l.size(value) > l.maxBytes {
+		return 0
+	}
+	l.remove(key)
+	l.entries[key] = l.order.PushFront(&lruEntry[V]{key: key, value: value})
+	l.bytes += l.size(value)
+
+	for (l.maxEntries > 0 && len(l.entries) > l.maxEntries) || l.bytes > l.maxBytes {
+		l.remove(l.order.Back().Value.(*lruEntry[V]).key)
+		evicted++
+	}
+	return evicted
+}
+
+// remove drops key if present
+func (l *lru[V]) remove(key string) {
+	el, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	delete(l.entries, key)
+	l.order.Remove(el)
+	l.bytes -= l.size(el.Value.(*lruEntry[V]).value)
+}
+
+// clear drops every entry and returns how many there were
+func (l *lru[V]) clear() int {
+	n := len(l.entries)
+	l.entries = make(map[string]*list.Element)
+	l.order.Init()
+	l.bytes = 0
+	return n
+}
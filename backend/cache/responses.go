@@ -0,0 +1,183 @@
+// Package cache stores generated answers so repeated questions are served without
+// calling the LLM, TTS or Realtime API again
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"christianmoore.me/avatar-backend/metrics"
+)
+
+// ChunkKind is the type of event a Chunk replays
+type ChunkKind string
+
+// Chunk kinds, matching the events the answer was streamed with
+const (
+	ChunkText      ChunkKind = "text"
+	ChunkTextDone  ChunkKind = "text_done"
+	ChunkAudio     ChunkKind = "audio"
+	ChunkAudioDone ChunkKind = "audio_done"
+)
+
+// Chunk is one event of a streamed answer
+type Chunk struct {
+	Kind   ChunkKind
+	Offset time.Duration // Since the answer's first chunk
+	Text   string        // Text delta (ChunkText)
+	Audio  int           // Bytes of Answer.Audio carried, following the previous audio chunk (ChunkAudio)
+}
+
+// Answer is a complete generated response to a question, kept as the chunks it was
+// streamed in so it can be replayed with the same order and pacing
+type Answer struct {
+	Chunks    []Chunk
+	Audio     []byte // PCM16 24kHz mono, nil when the answer was text-only
+	Backend   string // Backend that generated the answer
+	CreatedAt time.Time
+}
+
+// Text returns the full answer text
+func (a *Answer) Text() string {
+	var b strings.Builder
+	for _, chunk := range a.Chunks {
+		b.WriteString(chunk.Text)
+	}
+	return b.String()
+}
+
+// size approximates the memory an answer holds
+func (a *Answer) size() int {
+	n := len(a.Audio)
+	for _, chunk := range a.Chunks {
+		n += len(chunk.Text)
+	}
+	return n
+}
+
+// ResponseStats describes the cache contents
+type ResponseStats struct {
+	Entries    int
+	Bytes      int
+	MaxEntries int
+	MaxBytes   int
+	TTL        time.Duration
+}
+
+// Responses is an in-memory LRU cache of answers with a TTL and entry and byte limits.
+// A nil *Responses is valid and caches nothing.
+type Responses struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	answers *lru[*Answer]
+}
+
+// NewResponses creates a cache whose answers expire after ttl, holding at most
+// maxEntries answers and maxBytes of text and audio
+func NewResponses(ttl time.Duration, maxEntries, maxBytes int) *Responses {
+	return &Responses{
+		ttl:     ttl,
+		now:     time.Now,
+		answers: newLRU(maxEntries, maxBytes, (*Answer).size),
+	}
+}
+
+// ResponseKey identifies the answer to question under a prompt version. variant holds
+// any other settings that change the answer, such as the backend and voice. Questions
+// that differ only in case, whitespace or punctuation share a key.
+func ResponseKey(promptVersion, variant, question string) string {
+	sum := sha256.Sum256([]byte(promptVersion + "\x00" + variant + "\x00" + Normalize(question)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Normalize lowercases a question, drops punctuation other than apostrophes inside
+// words and collapses whitespace
+func Normalize(question string) string {
+	runes := []rune(strings.ToLower(question))
+	var b strings.Builder
+	space := false
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+		case (r == '\'' || r == '’') && i > 0 && i < len(runes)-1 &&
+			unicode.IsLetter(runes[i-1]) && unicode.IsLetter(runes[i+1]):
+			r = '\''
+		default:
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Get returns the answer cached under key, if it hasn't expired
+func (c *Responses) Get(key string) (*Answer, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	answer, ok := c.answers.get(key)
+	if ok && c.now().Sub(answer.CreatedAt) >= c.ttl {
+		c.answers.remove(key)
+		return nil, false
+	}
+	return answer, ok
+}
+
+// Put caches an answer under key, replacing any earlier one and evicting the least
+// recently used answers to stay within the limits. Answers larger than the whole
+// cache aren't stored.
+func (c *Responses) Put(key string, a *Answer) {
+	if c == nil {
+		return
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = c.now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if a.size() > c.answers.maxBytes {
+		return
+	}
+	evicted := c.answers.put(key, a)
+	metrics.ResponseCache.Add("stores", 1)
+	metrics.ResponseCache.Add("evictions", int64(evicted))
+}
+
+// Purge removes every answer and returns how many were removed
+func (c *Responses) Purge() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.answers.clear()
+}
+
+// Stats reports the cache size and limits
+func (c *Responses) Stats() ResponseStats {
+	if c == nil {
+		return ResponseStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ResponseStats{
+		Entries:    len(c.answers.entries),
+		Bytes:      c.answers.bytes,
+		MaxEntries: c.answers.maxEntries,
+		MaxBytes:   c.answers.maxBytes,
+		TTL:        c.ttl,
+	}
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		question string
+		want     string
+	}{
+		{"What's his experience with Kubernetes?", "what's his experience with kubernetes"},
+		{"  what’s his   EXPERIENCE with kubernetes!! ", "what's his experience with kubernetes"},
+		{"Go, Rust & C++?", "go rust c"},
+		{"'quoted'", "quoted"},
+		{"???", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.question); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.question, got, tt.want)
+		}
+	}
+
+	if ResponseKey("v1", "local", "Hi!") != ResponseKey("v1", "local", "hi") {
+		t.Error("Expected equivalent questions to share a key")
+	}
+	if ResponseKey("v1", "local", "hi") == ResponseKey("v2", "local", "hi") {
+		t.Error("Expected prompt versions not to share keys")
+	}
+}
+
+// newTestResponses returns a cache whose clock is controlled by the returned pointer
+func newTestResponses(maxEntries, maxBytes int) (*Responses, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewResponses(time.Hour, maxEntries, maxBytes)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func answer(text string, audioBytes int) *Answer {
+	return &Answer{Chunks: []Chunk{{Kind: ChunkText, Text: text}}, Audio: make([]byte, audioBytes)}
+}
+
+func TestResponsesExpire(t *testing.T) {
+	c, now := newTestResponses(10, 1000)
+	c.Put("a", answer("Hello", 10))
+	if a, ok := c.Get("a"); !ok || a.Text() != "Hello" {
+		t.Fatalf("Expected a hit, got %v %v", a, ok)
+	}
+
+	*now = now.Add(time.Hour)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Expected the answer to expire after the TTL")
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected expired answers to be dropped, got %+v", stats)
+	}
+}
+
+func TestResponsesEvictLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestResponses(2, 100)
+	c.Put("a", answer("a", 10))
+	c.Put("b", answer("b", 10))
+	c.Get("a")
+	c.Put("c", answer("c", 10)) // Over the entry limit, evicts b
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected the least recently used answer to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("Expected the recently used answer to be kept")
+	}
+
+	c.Put("d", answer("d", 80)) // Over the byte limit, evicts c
+	if _, ok := c.Get("c"); ok {
+		t.Error("Expected the least recently used answer to be evicted for space")
+	}
+	if stats := c.Stats(); stats.Entries != 2 || stats.Bytes != 92 {
+		t.Errorf("Expected a and d to be kept, got %+v", stats)
+	}
+
+	c.Put("huge", answer("huge", 200))
+	if _, ok := c.Get("huge"); ok {
+		t.Error("Expected answers larger than the cache not to be stored")
+	}
+
+	if n := c.Purge(); n != 2 || c.Stats().Bytes != 0 {
+		t.Errorf("Expected 2 answers purged, got %d (%+v)", n, c.Stats())
+	}
+}
+
+func TestNilResponses(t *testing.T) {
+	var c *Responses
+	c.Put("a", answer("a", 1))
+	if _, ok := c.Get("a"); ok || c.Purge() != 0 || c.Stats().Entries != 0 {
+		t.Error("Expected a nil cache to store nothing")
+	}
+}
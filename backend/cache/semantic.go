@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"math"
+	"sync"
+)
+
+// Semantic indexes the questions of cached answers by their embeddings, so a
+// paraphrased question can find the answer to the most similar earlier one. It holds
+// at most maxEntries questions, dropping the least recently matched.
+// A nil *Semantic is valid and finds nothing.
+type Semantic struct {
+	mu        sync.Mutex
+	questions *lru[*semanticEntry]
+}
+
+type semanticEntry struct {
+	scope    string
+	question string
+	vector   []float32 // Unit length
+}
+
+// Match is the indexed question most similar to a query
+type Match struct {
+	Key        string // Key of the cached answer
+	Question   string
+	Similarity float64 // Cosine similarity, 1 for the same direction
+}
+
+// NewSemantic creates an index of up to maxEntries questions
+func NewSemantic(maxEntries int) *Semantic {
+	return &Semantic{
+		questions: newLRU(maxEntries, math.MaxInt, func(*semanticEntry) int { return 0 }),
+	}
+}
+
+// Add indexes the question whose answer is cached under key. Only questions with the
+// same scope (e.g. prompt version and voice) are compared with each other.
+func (s *Semantic) Add(key, scope, question string, vector []float32) {
+	unit := normalize(vector)
+	if s == nil || unit == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.questions.put(key, &semanticEntry{scope: scope, question: question, vector: unit})
+}
+
+// Nearest returns the indexed question in scope most similar to vector
+func (s *Semantic) Nearest(scope string, vector []float32) (Match, bool) {
+	unit := normalize(vector)
+	if s == nil || unit == nil {
+		return Match{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best Match
+	found := false
+	for key, el := range s.questions.entries {
+		entry := el.Value.(*lruEntry[*semanticEntry]).value
+		if entry.scope != scope || len(entry.vector) != len(unit) {
+			continue
+		}
+		var dot float64
+		for i, v := range unit {
+			dot += float64(v * entry.vector[i])
+		}
+		if !found || dot > best.Similarity {
+			best = Match{Key: key, Question: entry.question, Similarity: dot}
+			found = true
+		}
+	}
+	if found {
+		s.questions.get(best.Key) // Mark as recently matched
+	}
+	return best, found
+}
+
+// Remove drops the question whose answer was cached under key, e.g. once the answer
+// has expired
+func (s *Semantic) Remove(key string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.questions.remove(key)
+}
+
+// Purge removes every question and returns how many were removed
+func (s *Semantic) Purge() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.questions.clear()
+}
+
+// normalize scales a vector to unit length, or returns nil for a zero vector
+func normalize(vector []float32) []float32 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return nil
+	}
+	norm := float32(math.Sqrt(sum))
+	unit := make([]float32, len(vector))
+	for i, v := range vector {
+		unit[i] = v / norm
+	}
+	return unit
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSemanticNearest(t *testing.T) {
+	s := NewSemantic(2)
+	s.Add("k8s", "v1/local", "kubernetes experience", []float32{1, 0, 0})
+	s.Add("go", "v1/local", "favorite language", []float32{0, 2, 0}) // Scaled to unit length
+
+	m, ok := s.Nearest("v1/local", []float32{0.9, 0.1, 0})
+	if !ok || m.Key != "k8s" || m.Question != "kubernetes experience" {
+		t.Fatalf("Nearest = %+v, %v; want k8s", m, ok)
+	}
+	if want := 0.9 / math.Hypot(0.9, 0.1); math.Abs(m.Similarity-want) > 1e-6 {
+		t.Errorf("Similarity = %f, want %f", m.Similarity, want)
+	}
+	if m, _ := s.Nearest("v1/local", []float32{0, 5, 0}); math.Abs(m.Similarity-1) > 1e-6 {
+		t.Errorf("Expected similarity 1 regardless of magnitude, got %f", m.Similarity)
+	}
+
+	if _, ok := s.Nearest("v2/local", []float32{1, 0, 0}); ok {
+		t.Error("Expected no match in another scope")
+	}
+	if _, ok := s.Nearest("v1/local", []float32{1, 0}); ok {
+		t.Error("Expected vectors of other dimensions not to match")
+	}
+	if _, ok := s.Nearest("v1/local", []float32{0, 0, 0}); ok {
+		t.Error("Expected a zero vector not to match")
+	}
+
+	// "go" was matched most recently, so "k8s" is evicted
+	s.Add("rust", "v1/local", "rust", []float32{0, 0, 1})
+	if m, _ := s.Nearest("v1/local", []float32{1, 0, 0}); m.Key == "k8s" {
+		t.Error("Expected the least recently matched question to be evicted")
+	}
+
+	s.Remove("rust")
+	if m, _ := s.Nearest("v1/local", []float32{0, 0, 1}); m.Key == "rust" {
+		t.Error("Expected a removed question not to match")
+	}
+
+	if n := s.Purge(); n != 1 {
+		t.Errorf("Purge() = %d, want 1", n)
+	}
+	if _, ok := s.Nearest("v1/local", []float32{0, 1, 0}); ok {
+		t.Error("Expected no match after purge")
+	}
+}
+
+func TestNilSemantic(t *testing.T) {
+	var s *Semantic
+	s.Add("k", "scope", "q", []float32{1})
+	s.Remove("k")
+	if _, ok := s.Nearest("scope", []float32{1}); ok {
+		t.Error("Expected a nil index to find nothing")
+	}
+	if s.Purge() != 0 {
+		t.Error("Expected a nil index to purge nothing")
+	}
+}
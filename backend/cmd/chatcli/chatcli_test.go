@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/testing/backendtest"
+	"christianmoore.me/avatar-backend/testing/fakes"
+)
+
+func TestScriptStreamsAnswersAndRecordsAudio(t *testing.T) {
+	b := backendtest.New(t, func(b *backendtest.Backend) {
+		b.Config.HeartbeatInterval = 20 * time.Millisecond
+	})
+	b.LLM.Enqueue(
+		fakes.LLMReply{Chunks: fakes.Words("He builds platforms."), Delay: 30 * time.Millisecond},
+		fakes.LLMReply{Chunks: fakes.Words("Mostly Kubernetes.")},
+	)
+	wavPath := filepath.Join(t.TempDir(), "answers.wav")
+
+	var out, status bytes.Buffer
+	script := strings.NewReader("# comments and blank lines are skipped\n\nWhat does he do?\nWith what?\n")
+	opts := Options{URL: b.Server.URL, Timeout: 5 * time.Second, WAVPath: wavPath, Script: true}
+	if err := Run(context.Background(), opts, script, &out, &status); err != nil {
+		t.Fatalf("Run failed: %v (status %q)", err, status.String())
+	}
+
+	if got := out.String(); got != "He builds platforms.\nMostly Kubernetes.\n" {
+		t.Errorf("Unexpected output %q", got)
+	}
+	if !strings.Contains(status.String(), "Connected: voice cedar, audio output") {
+		t.Errorf("Expected the session settings in the status output, got %q", status.String())
+	}
+
+	// Both spoken answers land in one valid WAV file
+	data, err := os.ReadFile(wavPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) <= wavHeaderSize || string(data[:4]) != "RIFF" || string(data[36:40]) != "data" {
+		t.Fatalf("Expected a WAV file with audio, got %d bytes", len(data))
+	}
+	if size := binary.LittleEndian.Uint32(data[40:44]); int(size) != len(data)-wavHeaderSize {
+		t.Errorf("Expected the data size %d in the header, got %d", len(data)-wavHeaderSize, size)
+	}
+	if rate := binary.LittleEndian.Uint32(data[24:28]); rate != sampleRate {
+		t.Errorf("Expected %d Hz, got %d", sampleRate, rate)
+	}
+}
+
+func TestScriptStopsAtFirstError(t *testing.T) {
+	b := backendtest.New(t, func(b *backendtest.Backend) {
+		b.Config.MaxMessageLength = 10
+	})
+
+	var out, status bytes.Buffer
+	script := strings.NewReader("This message is too long\nHi\n")
+	opts := Options{URL: b.Server.URL, Timeout: 5 * time.Second, Text: true, Script: true}
+	err := Run(context.Background(), opts, script, &out, &status)
+	if err == nil || err.Error() != "error: Message must be between 1 and 10 characters" {
+		t.Errorf("Expected the length error, got %v", err)
+	}
+	if reqs := b.LLM.Requests(); len(reqs) != 0 {
+		t.Errorf("Expected the script to stop before the second message, got %d LLM requests", len(reqs))
+	}
+}
+
+func TestSessionOptionsAreRequested(t *testing.T) {
+	b := backendtest.New(t, func(b *backendtest.Backend) {
+		b.Config.SessionVoices = []string{"cedar", "marin"}
+	})
+
+	var out, status bytes.Buffer
+	opts := Options{URL: b.Server.URL, Timeout: 5 * time.Second, Voice: "marin", Modality: "text", Script: true}
+	if err := Run(context.Background(), opts, strings.NewReader("Hi\n"), &out, &status); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status.String(), "Connected: voice marin, text output") {
+		t.Errorf("Expected the requested session options, got %q", status.String())
+	}
+
+	// Options outside the allowlist are refused at connect time
+	opts.Voice = "alloy"
+	if err := Run(context.Background(), opts, strings.NewReader(""), &out, &status); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Expected a disallowed voice to be rejected, got %v", err)
+	}
+}
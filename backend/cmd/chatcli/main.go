@@ -0,0 +1,330 @@
+// Command chatcli is a terminal client for /ws/chat, for debugging the backend
+// without the React app. It authenticates like the browser (token as the
+// WebSocket subprotocol), streams answers to stdout and answers heartbeats.
+//
+//	go run ./cmd/chatcli                              # interactive
+//	go run ./cmd/chatcli -wav answer.wav              # also record the spoken answers
+//	go run ./cmd/chatcli -script questions.txt -text  # one message per line, then exit
+//
+// The client uses the server's ClientMessage and ServerMessage types, so protocol
+// changes that break it fail to compile.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"christianmoore.me/avatar-backend/handlers"
+	"github.com/gorilla/websocket"
+)
+
+// How long to keep reading after a response ends, to absorb trailing events
+// (e.g. the Realtime backend's second response_done)
+const settleTime = 100 * time.Millisecond
+
+// Options configure a chat session
+type Options struct {
+	URL      string
+	APIKey   string        // Exchanged for a JWT instead of the per-IP limited anonymous token
+	Voice    string        // Session voice override
+	Modality string        // Session output modality override
+	Text     bool          // Ask for text-only replies
+	Timeout  time.Duration // Per response
+	WAVPath  string        // Record audio_delta PCM here when set
+	Script   bool          // Non-interactive: no prompt, stop at the first error
+}
+
+func main() {
+	var opts Options
+	flag.StringVar(&opts.URL, "url", "http://localhost:8080", "backend base URL")
+	flag.StringVar(&opts.APIKey, "api-key", os.Getenv("CHATCLI_API_KEY"), "API key for /api/token (default $CHATCLI_API_KEY)")
+	flag.StringVar(&opts.Voice, "voice", "", "session voice (must be allowed by SESSION_VOICES)")
+	flag.StringVar(&opts.Modality, "modality", "", "session output modality: audio or text")
+	flag.BoolVar(&opts.Text, "text", false, "ask for text-only replies")
+	flag.DurationVar(&opts.Timeout, "timeout", time.Minute, "time allowed per response")
+	flag.StringVar(&opts.WAVPath, "wav", "", "write the spoken answers to this WAV file")
+	script := flag.String("script", "", "send each line of this file (- for stdin) and exit")
+	flag.Parse()
+
+	in := io.Reader(os.Stdin)
+	if *script != "" {
+		opts.Script = true
+		if *script != "-" {
+			f, err := os.Open(*script)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open script: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			in = f
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := Run(ctx, opts, in, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Run connects and sends each line of in as a message, writing answers to out
+// and status to status. It returns when in is exhausted or "/quit" is read.
+func Run(ctx context.Context, opts Options, in io.Reader, out, status io.Writer) error {
+	token, err := fetchToken(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if opts.Voice != "" {
+		query.Set("voice", opts.Voice)
+	}
+	if opts.Modality != "" {
+		query.Set("modality", opts.Modality)
+	}
+	wsURL := "ws" + strings.TrimPrefix(strings.TrimSuffix(opts.URL, "/"), "http") + "/ws/chat"
+	if len(query) > 0 {
+		wsURL += "?" + query.Encode()
+	}
+
+	// Authenticate like the browser does, with the token as the subprotocol
+	dialer := websocket.Dialer{HandshakeTimeout: opts.Timeout, Subprotocols: []string{token}}
+	conn, resp, err := dialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		if resp != nil {
+			var body struct {
+				Error string `json:"error"`
+			}
+			json.NewDecoder(resp.Body).Decode(&body)
+			return fmt.Errorf("connect: %s %s", resp.Status, body.Error)
+		}
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != token {
+		return errors.New("connect: server did not echo the Sec-WebSocket-Protocol header (browsers would close with 1006)")
+	}
+
+	c := &client{conn: conn, events: make(chan handlers.ServerMessage, 256), done: make(chan struct{}), out: out, status: status}
+	defer close(c.done)
+	go c.read()
+
+	if opts.WAVPath != "" {
+		if c.wav, err = CreateWAV(opts.WAVPath); err != nil {
+			return err
+		}
+		defer func() {
+			if err := c.wav.Close(); err != nil {
+				fmt.Fprintf(status, "Failed to finish %s: %v\n", opts.WAVPath, err)
+			} else {
+				fmt.Fprintf(status, "Wrote %.1fs of audio to %s\n", float64(c.wav.size)/(sampleRate*bitsPerSample/8), opts.WAVPath)
+			}
+		}()
+	}
+
+	// The session settings come first
+	select {
+	case msg, ok := <-c.events:
+		if !ok || msg.Type != "session_created" || msg.Session == nil {
+			return fmt.Errorf("expected session_created, got %+v", msg)
+		}
+		fmt.Fprintf(status, "Connected: voice %s, %s output\n", msg.Session.Voice, msg.Session.OutputModality)
+	case <-time.After(opts.Timeout):
+		return errors.New("no session_created from the server")
+	}
+
+	modality := ""
+	if opts.Text {
+		modality = "text"
+	}
+	lines := bufio.NewScanner(in)
+	for {
+		if !opts.Script {
+			fmt.Fprint(out, "> ")
+		}
+		if !lines.Scan() {
+			if !opts.Script {
+				fmt.Fprintln(out)
+			}
+			return lines.Err()
+		}
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || (opts.Script && strings.HasPrefix(line, "#")) {
+			continue
+		}
+		if line == "/quit" {
+			return nil
+		}
+		if err := c.ask(ctx, line, modality, opts.Timeout); err != nil {
+			if opts.Script {
+				return err
+			}
+			fmt.Fprintf(status, "%v\n", err)
+		}
+	}
+}
+
+// fetchToken requests a JWT from /api/token
+func fetchToken(ctx context.Context, opts Options) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(opts.URL, "/")+"/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	if opts.APIKey != "" {
+		req.Header.Set("X-API-Key", opts.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		JWT   string `json:"jwt"`
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || body.JWT == "" {
+		return "", fmt.Errorf("token request returned %s %s", resp.Status, body.Error)
+	}
+	return body.JWT, nil
+}
+
+// client is an open chat session. A reader goroutine answers heartbeats and
+// forwards every other event.
+type client struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	events  chan handlers.ServerMessage
+	done    chan struct{} // Closed when the session stops reading events
+	out     io.Writer
+	status  io.Writer
+	wav     *WAVWriter
+}
+
+func (c *client) write(msg handlers.ClientMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+// read forwards events until the connection closes, then closes the channel
+func (c *client) read() {
+	defer close(c.events)
+	for {
+		var msg handlers.ServerMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				fmt.Fprintf(c.status, "Connection closed: %d %s\n", closeErr.Code, closeErr.Text)
+			}
+			return
+		}
+		if msg.Type == "heartbeat" {
+			c.write(handlers.ClientMessage{Type: "heartbeat_ack"})
+			continue
+		}
+		select {
+		case c.events <- msg:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// ask sends a message and prints the answer as it streams
+func (c *client) ask(ctx context.Context, message, modality string, timeout time.Duration) error {
+	// Discard anything left over from the previous response
+	for drained := false; !drained; {
+		select {
+		case _, ok := <-c.events:
+			if !ok {
+				return errors.New("connection closed")
+			}
+		default:
+			drained = true
+		}
+	}
+
+	if err := c.write(handlers.ClientMessage{Type: "message", Message: message, Modality: modality}); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	// A response ends at response_done, once any audio it started has finished
+	var gotAudio, audioDone, done bool
+	deadline := time.After(timeout)
+	for !done || (gotAudio && !audioDone) {
+		select {
+		case msg, ok := <-c.events:
+			if !ok {
+				return errors.New("connection closed")
+			}
+			switch msg.Type {
+			case "text_delta":
+				fmt.Fprint(c.out, msg.Text)
+			case "text_done":
+				fmt.Fprintln(c.out)
+			case "audio_delta":
+				gotAudio = true
+				if c.wav != nil {
+					pcm, err := base64.StdEncoding.DecodeString(msg.Audio)
+					if err != nil {
+						return fmt.Errorf("decode audio: %w", err)
+					}
+					if _, err := c.wav.Write(pcm); err != nil {
+						return fmt.Errorf("write audio: %w", err)
+					}
+				}
+			case "audio_done":
+				audioDone = true
+			case "response_done":
+				done = true
+			case "error":
+				return fmt.Errorf("error: %s", msg.Error)
+			case "backend_switched":
+				fmt.Fprintf(c.status, "[answering with the %s backend]\n", msg.Backend)
+			case "moderation_blocked":
+				// Refused without a response; any spoken refusal is absorbed below
+				fmt.Fprintf(c.status, "[%s] %s\n", msg.Type, msg.Text)
+				done = true
+			case "budget_exceeded", "server_draining":
+				if msg.Error != "" {
+					return fmt.Errorf("%s: %s", msg.Type, msg.Error)
+				}
+				fmt.Fprintf(c.status, "[%s] %s\n", msg.Type, msg.Text)
+			default:
+				fmt.Fprintf(c.status, "[%s]\n", msg.Type)
+			}
+		case <-deadline:
+			return fmt.Errorf("no complete response within %v", timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Absorb trailing events so they aren't mistaken for the next response
+	for settle := time.After(settleTime); ; {
+		select {
+		case _, ok := <-c.events:
+			if !ok {
+				return nil
+			}
+		case <-settle:
+			return nil
+		}
+	}
+}
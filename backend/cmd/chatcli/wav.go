@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Audio deltas from both backends are 24kHz mono PCM16
+const (
+	sampleRate    = 24000
+	bitsPerSample = 16
+	wavHeaderSize = 44
+)
+
+// WAVWriter streams PCM16 to a WAV file, filling in the sizes on Close
+type WAVWriter struct {
+	f    *os.File
+	size uint32
+}
+
+// CreateWAV creates a WAV file ready for PCM data
+func CreateWAV(path string) (*WAVWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAVWriter{f: f}
+	if err := w.writeHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends PCM16 samples
+func (w *WAVWriter) Write(pcm []byte) (int, error) {
+	n, err := w.f.Write(pcm)
+	w.size += uint32(n)
+	return n, err
+}
+
+// Close writes the final sizes into the header and closes the file
+func (w *WAVWriter) Close() error {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		w.f.Close()
+		return err
+	}
+	if err := w.writeHeader(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+func (w *WAVWriter) writeHeader() error {
+	blockAlign := bitsPerSample / 8
+	header := make([]byte, 0, wavHeaderSize)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, 36+w.size)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)                            // fmt chunk size
+	header = binary.LittleEndian.AppendUint16(header, 1)                             // PCM
+	header = binary.LittleEndian.AppendUint16(header, 1)                             // Mono
+	header = binary.LittleEndian.AppendUint32(header, sampleRate)                    // Sample rate
+	header = binary.LittleEndian.AppendUint32(header, sampleRate*uint32(blockAlign)) // Byte rate
+	header = binary.LittleEndian.AppendUint16(header, uint16(blockAlign))            // Block align
+	header = binary.LittleEndian.AppendUint16(header, bitsPerSample)                 // Bits per sample
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, w.size)
+	_, err := w.f.Write(header)
+	return err
+}
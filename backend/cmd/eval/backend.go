@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"christianmoore.me/avatar-backend/handlers"
+	"github.com/gorilla/websocket"
+)
+
+// How many times a rate-limited question is retried before giving up
+const maxRateLimitRetries = 10
+
+// Backend asks questions through the avatar backend's WebSocket API, one
+// connection per question so answers don't share conversation history
+type Backend struct {
+	URL       string        // Backend base URL (http or https)
+	APIKey    string        // Optional API key; otherwise a JWT is requested from /api/token
+	Timeout   time.Duration // Per question
+	RetryWait time.Duration // Pause before resending a rate-limited question
+	Client    *http.Client
+
+	token string
+}
+
+// Ask sends a text-only question and returns the complete answer
+func (b *Backend) Ask(ctx context.Context, question string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.Timeout)
+	defer cancel()
+
+	conn, err := b.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+
+	send := func() error {
+		return conn.WriteJSON(handlers.ClientMessage{Type: "message", Message: question, Modality: "text"})
+	}
+	if err := send(); err != nil {
+		return "", err
+	}
+
+	var answer strings.Builder
+	retries := 0
+	for {
+		var msg handlers.ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return "", fmt.Errorf("read: %w", err)
+		}
+		switch msg.Type {
+		case "text_delta":
+			answer.WriteString(msg.Text)
+		case "response_done":
+			return strings.TrimSpace(answer.String()), nil
+		case "heartbeat":
+			conn.WriteJSON(handlers.ClientMessage{Type: "heartbeat_ack"})
+		case "error":
+			if msg.Code != handlers.ErrorCodeRateLimited || retries == maxRateLimitRetries {
+				return "", fmt.Errorf("backend error: %s", msg.Error)
+			}
+			retries++
+			log.Printf("Rate limited, retrying in %v", b.RetryWait)
+			select {
+			case <-time.After(b.RetryWait):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			if err := send(); err != nil {
+				return "", err
+			}
+		case "moderation_blocked":
+			// The refusal is the answer, e.g. for prompt injection suites
+			return msg.Text, nil
+		case "budget_exceeded", "server_draining":
+			if msg.Error != "" {
+				return "", fmt.Errorf("%s: %s", msg.Type, msg.Error)
+			}
+		}
+	}
+}
+
+// dial opens a chat session, requesting a new token once if the cached one was rejected
+func (b *Backend) dial(ctx context.Context) (*websocket.Conn, error) {
+	wsURL := "ws" + strings.TrimPrefix(strings.TrimSuffix(b.URL, "/"), "http") + "/ws/chat"
+	for attempt := 0; ; attempt++ {
+		header := http.Header{}
+		if b.APIKey != "" {
+			header.Set("X-API-Key", b.APIKey)
+		} else {
+			if b.token == "" {
+				token, err := b.fetchToken(ctx)
+				if err != nil {
+					return nil, err
+				}
+				b.token = token
+			}
+			header.Set("Authorization", "Bearer "+b.token)
+		}
+
+		conn, resp, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+		if err == nil {
+			var greeting handlers.ServerMessage
+			if err := conn.ReadJSON(&greeting); err != nil || greeting.Type != "session_created" {
+				conn.Close()
+				return nil, fmt.Errorf("expected session_created, got %q: %v", greeting.Type, err)
+			}
+			return conn, nil
+		}
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized && b.APIKey == "" && attempt == 0 {
+			b.token = "" // Expired; request a new one
+			continue
+		}
+		if resp != nil {
+			return nil, fmt.Errorf("connect: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+}
+
+// fetchToken requests a JWT from /api/token
+func (b *Backend) fetchToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(b.URL, "/")+"/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned %s", resp.Status)
+	}
+	var body struct {
+		JWT string `json:"jwt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.JWT == "" {
+		return "", errors.New("token response has no jwt")
+	}
+	return body.JWT, nil
+}
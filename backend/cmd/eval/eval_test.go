@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/testing/backendtest"
+	"christianmoore.me/avatar-backend/testing/fakes"
+)
+
+func TestRunScoresAnswers(t *testing.T) {
+	server := backendtest.New(t, func(b *backendtest.Backend) {
+		b.Config.MessageRateInterval = 150 * time.Millisecond
+		b.Config.MessageBurst = 1
+		// Retries must not depend on the wording of the rate limit error
+		b.Config.Phrases = map[string]string{config.PhraseRateLimit: "Easy there, one question at a time."}
+	})
+	server.LLM.Enqueue(
+		fakes.LLMReply{Chunks: fakes.Words("He is a Cloud Solutions Architect at Amazon.")},
+		fakes.LLMReply{Chunks: fakes.Words("He studied at Harvard.")},
+	)
+
+	judgeLLM := fakes.NewLLM()
+	judgeLLM.Enqueue(
+		fakes.LLMReply{Chunks: []string{`{"score": 5, "reason": "Matches the profile."}`}},
+		fakes.LLMReply{Chunks: []string{`{"score": 1, "reason": "Invents a university."}`}},
+	)
+	judge := &Judge{URL: fakes.Serve(t, judgeLLM).URL, Model: "judge", Profile: "Christian works at Amazon.", Client: http.DefaultClient}
+
+	yes := true
+	suite := &Suite{
+		Name:            "test",
+		JudgeMinScore:   4,
+		RefusalPatterns: defaultRefusalPatterns,
+		Questions: []Case{
+			{ID: "role", Question: "What does he do?", MustMention: []string{"Amazon"}, MaxSentences: 2},
+			{ID: "college", Question: "Where did he study?", MustNotMention: []string{"Harvard"}, ExpectRefusal: &yes},
+		},
+	}
+	// Each question is rate limited after the first, so the retry path is exercised too
+	backend := &Backend{URL: server.Server.URL, Timeout: 5 * time.Second, RetryWait: 50 * time.Millisecond, Client: http.DefaultClient}
+
+	report := Run(context.Background(), suite, backend, judge, "v2")
+
+	if report.Suite != "test" || report.Label != "v2" || report.Total != 2 || report.Passed != 1 {
+		t.Errorf("Unexpected summary %+v", report)
+	}
+	role, college := report.Results[0], report.Results[1]
+	if !role.Passed || role.Score != 1 || role.Answer != "He is a Cloud Solutions Architect at Amazon." {
+		t.Errorf("Expected the role question to pass, got %+v", role)
+	}
+	// The forbidden term, the missing refusal and the judge all fail
+	if college.Passed || college.Score != 0 || len(college.Checks) != 3 {
+		t.Errorf("Expected every college check to fail, got %+v", college)
+	}
+	if got := college.Checks[2].Detail; got != "1/5: Invents a university." {
+		t.Errorf("Expected the judge's verdict in the report, got %q", got)
+	}
+	if report.Score != 0.5 {
+		t.Errorf("Expected 3 of 6 checks to pass, got score %v", report.Score)
+	}
+
+	// The judge sees the profile, question and answer
+	reqs := judgeLLM.Requests()
+	if len(reqs) != 2 || reqs[1].Messages[1].Content == "" || reqs[1].Temperature == nil || *reqs[1].Temperature != 0 {
+		t.Errorf("Unexpected judge requests %+v", reqs)
+	}
+}
+
+func TestRunRecordsBackendErrors(t *testing.T) {
+	suite := &Suite{Name: "test", JudgeMinScore: 4, Questions: []Case{{ID: "q", Question: "Hi?"}}}
+	backend := &Backend{URL: "http://127.0.0.1:1", Timeout: time.Second, Client: http.DefaultClient}
+
+	report := Run(context.Background(), suite, backend, nil, "")
+	if report.Passed != 0 || report.Score != 0 || report.Results[0].Error == "" {
+		t.Errorf("Expected an unreachable backend to fail the question, got %+v", report)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"christianmoore.me/avatar-backend/handlers"
+)
+
+// Criteria every answer is judged on, before any case-specific criteria
+const baseCriteria = "The answer must only state facts found in the profile. " +
+	"Penalize invented employers, dates, credentials, projects or hobbies, and answers that ignore the question."
+
+const judgeInstructions = `You grade answers given by an AI avatar that represents a person, using the profile below as the only source of truth.
+Score the answer from 1 (wrong, invented or unhelpful) to 5 (accurate, fully supported by the profile and meeting every criterion).
+Reply with only a JSON object: {"score": <1-5>, "reason": "<one sentence>"}
+
+Profile:
+`
+
+// Judge grades answers with an OpenAI-compatible chat completion model
+type Judge struct {
+	URL     string // Base URL; requests go to URL + /v1/chat/completions
+	Model   string
+	APIKey  string
+	Profile string // The system prompt answers must stay faithful to
+	Client  *http.Client
+}
+
+// verdict is the JSON the judge is asked to reply with
+type verdict struct {
+	Score  int    `json:"score"`
+	Reason string `json:"reason"`
+}
+
+// Grade asks the judge to score an answer from 1 to 5
+func (j *Judge) Grade(ctx context.Context, c Case, answer string) (score int, reason string, err error) {
+	criteria := baseCriteria
+	if c.Judge != "" {
+		criteria += " " + c.Judge
+	}
+	temperature := 0.0
+	body, err := json.Marshal(handlers.ChatCompletionRequest{
+		Model: j.Model,
+		Messages: []handlers.Message{
+			{Role: "system", Content: judgeInstructions + j.Profile},
+			{Role: "user", Content: fmt.Sprintf("Question: %s\n\nCriteria: %s\n\nAnswer: %s", c.Question, criteria, answer)},
+		},
+		Temperature: &temperature,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(j.URL, "/")+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if j.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+j.APIKey)
+	}
+	resp, err := j.Client.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("judge request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, "", fmt.Errorf("judge returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message handlers.Message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return 0, "", fmt.Errorf("decode judge response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return 0, "", fmt.Errorf("judge returned no choices")
+	}
+	v, err := parseVerdict(completion.Choices[0].Message.Content)
+	if err != nil {
+		return 0, "", err
+	}
+	return v.Score, v.Reason, nil
+}
+
+// parseVerdict extracts the JSON verdict, tolerating surrounding prose or code fences
+func parseVerdict(content string) (verdict, error) {
+	var v verdict
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return v, fmt.Errorf("judge reply has no JSON verdict: %q", content)
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &v); err != nil {
+		return v, fmt.Errorf("judge reply has an invalid verdict: %w", err)
+	}
+	if v.Score < 1 || v.Score > 5 {
+		return v, fmt.Errorf("judge score %d is outside 1-5", v.Score)
+	}
+	return v, nil
+}
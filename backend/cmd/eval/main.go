@@ -0,0 +1,74 @@
+// Command eval asks a running backend every question in a YAML suite and scores
+// the answers with rules and, optionally, an LLM judge. The YAML report can be
+// diffed between prompt versions to spot regressions and invented facts.
+//
+//	go run ./cmd/eval -suite eval/persona.yaml -label "$(git rev-parse --short HEAD)" -out report.yaml
+//	go run ./cmd/eval -judge-url https://api.openai.com -judge-model gpt-4o-mini -profile system_prompt.txt
+//
+// It exits with status 1 when any question fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+func main() {
+	suitePath := flag.String("suite", "eval/persona.yaml", "YAML suite of questions and rules")
+	url := flag.String("url", "http://localhost:8080", "backend base URL")
+	apiKey := flag.String("api-key", os.Getenv("EVAL_API_KEY"), "backend API key (default $EVAL_API_KEY; a JWT is requested when empty)")
+	timeout := flag.Duration("timeout", time.Minute, "time allowed per question")
+	retryWait := flag.Duration("retry-wait", 5*time.Second, "pause before resending a rate-limited question")
+	judgeURL := flag.String("judge-url", "", "OpenAI-compatible base URL for the LLM judge (disabled when empty)")
+	judgeModel := flag.String("judge-model", "gpt-4o-mini", "judge model")
+	judgeKey := flag.String("judge-api-key", os.Getenv("EVAL_JUDGE_API_KEY"), "judge API key (default $EVAL_JUDGE_API_KEY)")
+	profile := flag.String("profile", "system_prompt.txt", "profile the judge checks answers against")
+	label := flag.String("label", "", "label recorded in the report, e.g. the prompt version")
+	out := flag.String("out", "-", "report file (- for stdout)")
+	flag.Parse()
+
+	suite, err := LoadSuite(*suitePath)
+	if err != nil {
+		log.Fatalf("Failed to load suite: %v", err)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	var judge *Judge
+	if *judgeURL != "" {
+		data, err := os.ReadFile(*profile)
+		if err != nil {
+			log.Fatalf("Failed to read judge profile: %v", err)
+		}
+		judge = &Judge{URL: *judgeURL, Model: *judgeModel, APIKey: *judgeKey, Profile: string(data), Client: client}
+	}
+	backend := &Backend{URL: *url, APIKey: *apiKey, Timeout: *timeout, RetryWait: *retryWait, Client: client}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Running %d questions from %s against %s", len(suite.Questions), *suitePath, *url)
+	report := Run(ctx, suite, backend, judge, *label)
+	log.Printf("%d/%d questions passed, score %.3f", report.Passed, report.Total, report.Score)
+
+	data, err := yaml.Marshal(report)
+	if err != nil {
+		log.Fatalf("Failed to encode report: %v", err)
+	}
+	if *out == "-" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(*out, data, 0o644); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+
+	if report.Passed < report.Total {
+		os.Exit(1)
+	}
+}
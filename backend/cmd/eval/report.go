@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+)
+
+// Report is the scored outcome of a suite run. It holds no timings or timestamps
+// so reports from two prompt versions can be compared with diff.
+type Report struct {
+	Suite   string   `yaml:"suite"`
+	Label   string   `yaml:"label,omitempty"` // e.g. the prompt version under test
+	Score   float64  `yaml:"score"`           // Fraction of all checks passed
+	Passed  int      `yaml:"passed"`          // Questions with every check passed
+	Total   int      `yaml:"total"`           // Questions asked
+	Results []Result `yaml:"results"`
+}
+
+// Result is the outcome for one question
+type Result struct {
+	ID       string        `yaml:"id"`
+	Question string        `yaml:"question"`
+	Answer   string        `yaml:"answer"`
+	Error    string        `yaml:"error,omitempty"`
+	Passed   bool          `yaml:"passed"`
+	Score    float64       `yaml:"score"`
+	Checks   []CheckResult `yaml:"checks"`
+}
+
+// asker answers a question; *Backend in production
+type asker interface {
+	Ask(ctx context.Context, question string) (string, error)
+}
+
+// Run asks every question in order and scores the answers; judge may be nil
+func Run(ctx context.Context, suite *Suite, backend asker, judge *Judge, label string) Report {
+	report := Report{Suite: suite.Name, Label: label, Total: len(suite.Questions)}
+	var checks, passedChecks int
+
+	for _, c := range suite.Questions {
+		result := Result{ID: c.ID, Question: c.Question}
+		answer, err := backend.Ask(ctx, c.Question)
+		if err != nil {
+			// Unanswered questions fail every check
+			log.Printf("Question %s failed: %v", c.ID, err)
+			result.Error = err.Error()
+		} else {
+			result.Answer = answer
+			result.Checks = c.Check(answer, suite.RefusalPatterns)
+			if judge != nil {
+				result.Checks = append(result.Checks, judgeCheck(ctx, judge, c, answer, suite.JudgeMinScore))
+			}
+		}
+
+		passed := 0
+		for _, check := range result.Checks {
+			if check.Passed {
+				passed++
+			}
+		}
+		total := len(result.Checks)
+		if result.Error != "" {
+			total = max(total, 1)
+		}
+		result.Passed = result.Error == "" && passed == total
+		result.Score = 1
+		if total > 0 {
+			result.Score = round(float64(passed) / float64(total))
+		}
+		if result.Passed {
+			report.Passed++
+		}
+		checks += total
+		passedChecks += passed
+
+		log.Printf("%-24s %s (%d/%d checks)", c.ID, passFail(result.Passed), passed, total)
+		report.Results = append(report.Results, result)
+	}
+
+	report.Score = 1
+	if checks > 0 {
+		report.Score = round(float64(passedChecks) / float64(checks))
+	}
+	return report
+}
+
+// judgeCheck grades an answer, failing the check if the judge can't be reached
+func judgeCheck(ctx context.Context, judge *Judge, c Case, answer string, minScore int) CheckResult {
+	check := CheckResult{Rule: fmt.Sprintf("judge score at least %d", minScore)}
+	score, reason, err := judge.Grade(ctx, c, answer)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	check.Passed = score >= minScore
+	check.Detail = fmt.Sprintf("%d/5: %s", score, reason)
+	return check
+}
+
+// round keeps scores to three decimals so reports diff cleanly
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+func passFail(passed bool) string {
+	if passed {
+		return "PASS"
+	}
+	return "FAIL"
+}
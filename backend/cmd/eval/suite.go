@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Phrases that mark an answer as a refusal when the suite doesn't list its own
+var defaultRefusalPatterns = []string{
+	"i don't have",
+	"i do not have",
+	"i can only",
+	"i can't help",
+	"i cannot help",
+	"i'm not able to",
+	"i am not able to",
+	"only answer questions",
+	"outside of what i",
+	"not something i can",
+}
+
+// Suite is a set of questions and the rules their answers must satisfy
+type Suite struct {
+	Name            string   `yaml:"name"`
+	JudgeMinScore   int      `yaml:"judge_min_score"`  // Lowest passing judge score (1-5, default 4)
+	RefusalPatterns []string `yaml:"refusal_patterns"` // Phrases that mark a refusal (case-insensitive)
+	Questions       []Case   `yaml:"questions"`
+}
+
+// Case is one question with its rules. Terms in must_mention and must_not_mention
+// match case-insensitively; "a|b" matches either alternative.
+type Case struct {
+	ID             string   `yaml:"id"`
+	Question       string   `yaml:"question"`
+	MustMention    []string `yaml:"must_mention"`
+	MustNotMention []string `yaml:"must_not_mention"`
+	MaxSentences   int      `yaml:"max_sentences"`  // 0 = no limit
+	ExpectRefusal  *bool    `yaml:"expect_refusal"` // true: must refuse, false: must answer, unset: not checked
+	Judge          string   `yaml:"judge"`          // Extra criteria for the LLM judge
+}
+
+// CheckResult is the outcome of one rule for one answer
+type CheckResult struct {
+	Rule   string `yaml:"rule"`
+	Passed bool   `yaml:"passed"`
+	Detail string `yaml:"detail,omitempty"`
+}
+
+// LoadSuite reads and validates a YAML suite, reporting every problem at once
+func LoadSuite(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var suite Suite
+	if err := yaml.UnmarshalWithOptions(data, &suite, yaml.Strict()); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if suite.JudgeMinScore == 0 {
+		suite.JudgeMinScore = 4
+	}
+	if len(suite.RefusalPatterns) == 0 {
+		suite.RefusalPatterns = defaultRefusalPatterns
+	}
+	if err := suite.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &suite, nil
+}
+
+// Validate reports every problem with the suite
+func (s *Suite) Validate() error {
+	var problems []string
+	if s.Name == "" {
+		problems = append(problems, "name is required")
+	}
+	if s.JudgeMinScore < 1 || s.JudgeMinScore > 5 {
+		problems = append(problems, "judge_min_score must be between 1 and 5")
+	}
+	if len(s.Questions) == 0 {
+		problems = append(problems, "at least one question is required")
+	}
+	seen := make(map[string]bool)
+	for i, c := range s.Questions {
+		switch {
+		case c.ID == "":
+			problems = append(problems, fmt.Sprintf("question %d has no id", i+1))
+		case seen[c.ID]:
+			problems = append(problems, fmt.Sprintf("question id %q is used more than once", c.ID))
+		}
+		seen[c.ID] = true
+		if strings.TrimSpace(c.Question) == "" {
+			problems = append(problems, fmt.Sprintf("question %q has no question text", c.ID))
+		}
+		if c.MaxSentences < 0 {
+			problems = append(problems, fmt.Sprintf("question %q: max_sentences must be at least 0", c.ID))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// Check applies the case's rules to an answer
+func (c Case) Check(answer string, refusalPatterns []string) []CheckResult {
+	var results []CheckResult
+	// Models often use typographic apostrophes; match them like plain ones
+	lower := strings.ReplaceAll(strings.ToLower(answer), "’", "'")
+
+	for _, term := range c.MustMention {
+		results = append(results, CheckResult{
+			Rule:   fmt.Sprintf("mentions %q", term),
+			Passed: mentions(lower, term),
+		})
+	}
+	for _, term := range c.MustNotMention {
+		results = append(results, CheckResult{
+			Rule:   fmt.Sprintf("does not mention %q", term),
+			Passed: !mentions(lower, term),
+		})
+	}
+	if c.MaxSentences > 0 {
+		n := countSentences(answer)
+		results = append(results, CheckResult{
+			Rule:   fmt.Sprintf("at most %d sentences", c.MaxSentences),
+			Passed: n <= c.MaxSentences,
+			Detail: fmt.Sprintf("%d sentences", n),
+		})
+	}
+	if c.ExpectRefusal != nil {
+		refused := mentionsAny(lower, refusalPatterns)
+		rule := "answers instead of refusing"
+		if *c.ExpectRefusal {
+			rule = "refuses"
+		}
+		results = append(results, CheckResult{
+			Rule:   rule,
+			Passed: refused == *c.ExpectRefusal,
+		})
+	}
+	return results
+}
+
+// mentions reports whether the lowercased answer contains any "|"-separated alternative of term
+func mentions(lower, term string) bool {
+	return mentionsAny(lower, strings.Split(term, "|"))
+}
+
+// mentionsAny reports whether the lowercased answer contains any of the phrases
+func mentionsAny(lower string, phrases []string) bool {
+	for _, phrase := range phrases {
+		phrase = strings.ToLower(strings.TrimSpace(phrase))
+		if phrase != "" && strings.Contains(lower, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sentence ends: terminal punctuation followed by whitespace or the end of the text,
+// so decimals and versions like "2.5" don't count
+var sentenceEnd = regexp.MustCompile(`[.!?]+(\s+|$)`)
+
+// countSentences estimates the number of sentences in text
+func countSentences(text string) int {
+	n := 0
+	for _, part := range sentenceEnd.Split(strings.TrimSpace(text), -1) {
+		if strings.TrimSpace(part) != "" {
+			n++
+		}
+	}
+	return n
+}
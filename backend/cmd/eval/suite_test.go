@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		c      Case
+		answer string
+		failed []string // Rules expected to fail
+	}{
+		{
+			name:   "mentions with alternatives",
+			c:      Case{MustMention: []string{"Amazon|Ring", "architect"}},
+			answer: "He is a Cloud Solutions Architect at Ring.",
+		},
+		{
+			name:   "missing and forbidden terms",
+			c:      Case{MustMention: []string{"Terraform"}, MustNotMention: []string{"Pulumi"}},
+			answer: "He uses Pulumi.",
+			failed: []string{`mentions "Terraform"`, `does not mention "Pulumi"`},
+		},
+		{
+			name:   "too many sentences",
+			c:      Case{MaxSentences: 2},
+			answer: "One. Two! Three?",
+			failed: []string{"at most 2 sentences"},
+		},
+		{
+			name:   "decimals are not sentence ends",
+			c:      Case{MaxSentences: 1},
+			answer: "He runs k3s 1.30 on Raspberry Pi 5 boards.",
+		},
+		{
+			name:   "expected refusal",
+			c:      Case{ExpectRefusal: &yes},
+			answer: "I don’t have information about his education.",
+		},
+		{
+			name:   "missing refusal",
+			c:      Case{ExpectRefusal: &yes},
+			answer: "Preheat the oven to 350 degrees.",
+			failed: []string{"refuses"},
+		},
+		{
+			name:   "unexpected refusal",
+			c:      Case{ExpectRefusal: &no},
+			answer: "I can only answer questions about Christian.",
+			failed: []string{"answers instead of refusing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failed []string
+			for _, check := range tt.c.Check(tt.answer, defaultRefusalPatterns) {
+				if !check.Passed {
+					failed = append(failed, check.Rule)
+				}
+			}
+			if strings.Join(failed, ",") != strings.Join(tt.failed, ",") {
+				t.Errorf("Expected failed rules %q, got %q", tt.failed, failed)
+			}
+		})
+	}
+}
+
+func TestLoadSuiteReportsEveryProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suite.yaml")
+	os.WriteFile(path, []byte(`
+judge_min_score: 6
+questions:
+  - id: a
+    question: Who?
+  - id: a
+    question: ""
+    max_sentences: -1
+`), 0o644)
+
+	_, err := LoadSuite(path)
+	if err == nil {
+		t.Fatal("Expected an invalid suite to be rejected")
+	}
+	for _, want := range []string{
+		"name is required",
+		"judge_min_score must be between 1 and 5",
+		`question id "a" is used more than once`,
+		`question "a" has no question text`,
+		`question "a": max_sentences must be at least 0`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+}
+
+func TestLoadSuiteRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suite.yaml")
+	os.WriteFile(path, []byte("name: s\nquestions:\n  - id: a\n    question: Who?\n    must_mention_all: [x]\n"), 0o644)
+	if _, err := LoadSuite(path); err == nil {
+		t.Error("Expected a misspelled rule to be rejected")
+	}
+}
+
+func TestExampleSuiteLoads(t *testing.T) {
+	if _, err := LoadSuite("../../eval/persona.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseVerdict(t *testing.T) {
+	v, err := parseVerdict("```json\n{\"score\": 4, \"reason\": \"Accurate.\"}\n```")
+	if err != nil || v.Score != 4 || v.Reason != "Accurate." {
+		t.Errorf("Expected score 4, got %+v %v", v, err)
+	}
+	for _, content := range []string{"Looks good", `{"score": 9}`, `{"score": "high"}`} {
+		if _, err := parseVerdict(content); err == nil {
+			t.Errorf("Expected %q to be rejected", content)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Command fakeupstreams runs fake LLM, TTS, Realtime and embeddings servers so the backend and
+// frontend can be developed locally without GPUs or an OpenAI API key.
+//
+//	go run ./cmd/fakeupstreams
+//	BACKENDS=realtime,local OPENAI_API_KEY=fake OPENAI_REALTIME_URL=ws://localhost:9003/v1/realtime \
+//	  LOCAL_LLM_URL=http://localhost:9001 TTS_URL=http://localhost:9002 go run .
+//
+// Add EMBEDDINGS_URL=http://localhost:9004 EMBEDDINGS_MODEL=fake-embed to try the
+// semantic cache.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"christianmoore.me/avatar-backend/testing/fakes"
+)
+
+func main() {
+	llmAddr := flag.String("llm-addr", "localhost:9001", "address for the fake LLM server")
+	ttsAddr := flag.String("tts-addr", "localhost:9002", "address for the fake TTS server")
+	realtimeAddr := flag.String("realtime-addr", "localhost:9003", "address for the fake Realtime server")
+	embeddingsAddr := flag.String("embeddings-addr", "localhost:9004", "address for the fake embeddings server")
+	reply := flag.String("reply", fakes.DefaultReply, "text every fake answers with")
+	delay := flag.Duration("chunk-delay", 80*time.Millisecond, "pause between streamed words")
+	llmModel := flag.String("llm-model", "qwen2.5-7b-instruct", "model the fake LLM serves")
+	ttsModel := flag.String("tts-model", "neutss-air-4b", "model the fake TTS server lists")
+	flag.Parse()
+
+	chunks := fakes.Words(*reply)
+
+	llm := fakes.NewLLM(*llmModel)
+	llm.Default = fakes.LLMReply{Chunks: chunks, Delay: *delay}
+
+	tts := fakes.NewTTS()
+	tts.Models = []string{*ttsModel}
+
+	realtime := fakes.NewRealtime()
+	realtime.Default = fakes.RealtimeReply{Chunks: chunks, Delay: *delay}
+
+	embeddings := fakes.NewEmbeddings()
+	embeddings.Models = []string{"fake-embed"}
+
+	servers := []*http.Server{
+		{Addr: *llmAddr, Handler: llm},
+		{Addr: *ttsAddr, Handler: tts},
+		{Addr: *realtimeAddr, Handler: realtime},
+		{Addr: *embeddingsAddr, Handler: embeddings},
+	}
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Failed to serve on %s: %v", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	log.Printf("Fake LLM:      http://%s (model %s)", *llmAddr, *llmModel)
+	log.Printf("Fake TTS:      http://%s (model %s)", *ttsAddr, *ttsModel)
+	log.Printf("Fake Realtime: ws://%s/v1/realtime", *realtimeAddr)
+	log.Printf("Fake embeddings: http://%s (model fake-embed)", *embeddingsAddr)
+	log.Printf("Run the backend with:")
+	log.Printf("  BACKENDS=realtime,local OPENAI_API_KEY=fake OPENAI_REALTIME_URL=ws://%s/v1/realtime LOCAL_LLM_URL=http://%s TTS_URL=http://%s LOCAL_LLM_MODEL=%s TTS_MODEL=%s",
+		*realtimeAddr, *llmAddr, *ttsAddr, *llmModel, *ttsModel)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/testing/backendtest"
+)
+
+// newTestBackend serves the backend with streamed replies and room for a few messages per session
+func newTestBackend(t *testing.T, configure func(*config.Config)) *httptest.Server {
+	t.Helper()
+	b := backendtest.New(t, func(b *backendtest.Backend) {
+		b.LLM.Default.Delay = 5 * time.Millisecond
+		b.Config.MessageBurst = 10
+		if configure != nil {
+			configure(b.Config)
+		}
+	})
+	return b.Server
+}
+
+func newTestRunner(url string) *Runner {
+	return &Runner{
+		URL:            url,
+		Messages:       2,
+		Timeout:        5 * time.Second,
+		TokenRetryWait: 10 * time.Millisecond,
+		Questions:      defaultQuestions,
+		Mix:            []weighted{{"audio", 1}, {"text", 1}},
+		Client:         http.DefaultClient,
+		Stats:          NewStats(),
+	}
+}
+
+func TestRunAgainstFakeUpstreams(t *testing.T) {
+	server := newTestBackend(t, nil)
+	runner := newTestRunner(server.URL)
+
+	Run(context.Background(), runner, 4, 20*time.Millisecond, 1)
+
+	stats := runner.Stats
+	if stats.counts[countSessions] != 4 || stats.counts[countMessages] != 8 || stats.counts[countOK] != 8 {
+		t.Errorf("Expected 4 sessions and 8 successful messages, got %v (errors %v)", stats.counts, stats.errors)
+	}
+	for _, metric := range []string{metricConnect, metricFirstText, metricTotal} {
+		if p := stats.Percentiles(metric); p.N == 0 || p.P50 <= 0 || p.Max < p.P50 {
+			t.Errorf("Unexpected %s percentiles %+v", metric, p)
+		}
+	}
+	// Only audio messages produce audio
+	audio := stats.Percentiles(metricFirstAudio).N
+	if audio == 0 || audio == 8 || stats.audioBytes == 0 {
+		t.Errorf("Expected a mix of audio and text responses, got %d with audio", audio)
+	}
+
+	var out bytes.Buffer
+	stats.Print(&out, time.Second)
+	if !strings.Contains(out.String(), "Messages: 8 sent, 8 ok, 0 failed") {
+		t.Errorf("Unexpected summary:\n%s", out.String())
+	}
+}
+
+func TestRunRecordsErrors(t *testing.T) {
+	server := newTestBackend(t, func(cfg *config.Config) {
+		cfg.MaxConnectionsPerIP = 1
+		cfg.MessageBurst = 1
+	})
+	runner := newTestRunner(server.URL)
+
+	// Both sessions start together, so the second is over the per-IP connection limit
+	Run(context.Background(), runner, 2, 0, 1)
+
+	errs := runner.Stats.errors
+	if errs["connect_rejected"] != 1 || errs["rate_limited"] != 1 {
+		t.Errorf("Expected one rejected connection and one rate-limited message, got %v", errs)
+	}
+	if runner.Stats.counts[countOK] != 1 {
+		t.Errorf("Expected one successful message, got %v", runner.Stats.counts)
+	}
+}
@@ -0,0 +1,163 @@
+// Command loadtest simulates concurrent visitors on /ws/chat and prints latency
+// percentiles and error rates. Each session gets its own token, connects, and
+// asks questions with a configurable modality mix.
+//
+// For repeatable local runs, start the fake upstreams and a backend with the
+// per-IP limits raised (every session comes from one address):
+//
+//	go run ./cmd/fakeupstreams
+//	BACKENDS=local LOCAL_LLM_URL=http://localhost:9001 TTS_URL=http://localhost:9002 \
+//	  MAX_CONNECTIONS_PER_IP=1000 MESSAGE_BURST=100 go run .
+//	go run ./cmd/loadtest -sessions 200 -ramp 20s -messages 3 -mix audio:3,text:1
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"christianmoore.me/avatar-backend/handlers"
+)
+
+// Questions asked when no -questions file is given
+var defaultQuestions = []string{
+	"What does Christian do for work?",
+	"What's his experience with Kubernetes?",
+	"Which infrastructure as code tools does he use?",
+	"Tell me about his homelab.",
+	"What cars does he drive?",
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "backend base URL")
+	sessions := flag.Int("sessions", 10, "number of concurrent visitors")
+	ramp := flag.Duration("ramp", 10*time.Second, "time over which sessions start, evenly spaced")
+	messages := flag.Int("messages", 3, "messages per session")
+	think := flag.Duration("think", 2*time.Second, "pause between a response and the next message")
+	mix := flag.String("mix", "audio:1", "modality weights, e.g. audio:3,text:1")
+	questionsPath := flag.String("questions", "", "file with one question per line (default: built-in questions)")
+	timeout := flag.Duration("timeout", time.Minute, "time allowed per response")
+	apiKey := flag.String("api-key", os.Getenv("LOADTEST_API_KEY"), "API key for /api/token, avoiding its per-IP limit (default $LOADTEST_API_KEY)")
+	tokenRetries := flag.Int("token-retries", 20, "retries when /api/token is rate limited")
+	seed := flag.Uint64("seed", 1, "seed for question and modality choices")
+	flag.Parse()
+
+	weights, err := parseMix(*mix)
+	if err != nil {
+		log.Fatalf("Invalid -mix: %v", err)
+	}
+	questions := defaultQuestions
+	if *questionsPath != "" {
+		if questions, err = readQuestions(*questionsPath); err != nil {
+			log.Fatalf("Failed to read questions: %v", err)
+		}
+	}
+	if *sessions < 1 || *messages < 1 {
+		log.Fatal("-sessions and -messages must be at least 1")
+	}
+
+	runner := &Runner{
+		URL:            *url,
+		APIKey:         *apiKey,
+		Messages:       *messages,
+		Think:          *think,
+		Timeout:        *timeout,
+		TokenRetries:   *tokenRetries,
+		TokenRetryWait: handlers.TokenRequestRate,
+		Questions:      questions,
+		Mix:            weights,
+		Client:         &http.Client{Timeout: *timeout},
+		Stats:          NewStats(),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Starting %d sessions over %v against %s (%d messages each, mix %s)", *sessions, *ramp, *url, *messages, *mix)
+	start := time.Now()
+	Run(ctx, runner, *sessions, *ramp, *seed)
+	runner.Stats.Print(os.Stdout, time.Since(start))
+}
+
+// Run starts sessions evenly spaced over ramp and waits for all of them to finish
+func Run(ctx context.Context, runner *Runner, sessions int, ramp time.Duration, seed uint64) {
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(ramp / time.Duration(sessions)):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		// Each session has its own generator so runs with the same seed ask the same things
+		rng := rand.New(rand.NewPCG(seed, uint64(i)))
+		go func() {
+			defer wg.Done()
+			runner.Session(ctx, rng)
+		}()
+	}
+	wg.Wait()
+}
+
+// parseMix parses "audio:3,text:1" into modality weights
+func parseMix(s string) ([]weighted, error) {
+	var weights []weighted
+	for _, part := range strings.Split(s, ",") {
+		modality, weight, found := strings.Cut(strings.TrimSpace(part), ":")
+		n := 1
+		if found {
+			var err error
+			if n, err = strconv.Atoi(weight); err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid weight %q for %s", weight, modality)
+			}
+		}
+		if modality != "audio" && modality != "text" {
+			return nil, fmt.Errorf("unknown modality %q (want audio or text)", modality)
+		}
+		if n > 0 {
+			weights = append(weights, weighted{Modality: modality, Weight: n})
+		}
+	}
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("no modality has a positive weight")
+	}
+	return weights, nil
+}
+
+// readQuestions reads non-empty lines from a file
+func readQuestions(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var questions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			questions = append(questions, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(questions) == 0 {
+		return nil, fmt.Errorf("%s has no questions", path)
+	}
+	return questions, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"christianmoore.me/avatar-backend/handlers"
+	"github.com/gorilla/websocket"
+)
+
+// How long to keep reading after a response ends, to absorb trailing events
+// (e.g. the Realtime backend's second response_done) before the next message
+const settleTime = 100 * time.Millisecond
+
+// Runner holds the settings shared by every simulated visitor
+type Runner struct {
+	URL            string
+	APIKey         string        // Exchanged for JWTs, bypassing the per-IP token limit
+	Messages       int           // Per session
+	Think          time.Duration // Pause between a response and the next message
+	Timeout        time.Duration // Per response
+	TokenRetries   int           // Retries when /api/token is rate limited
+	TokenRetryWait time.Duration
+	Questions      []string
+	Mix            []weighted // Modality weights
+	Client         *http.Client
+	Stats          *Stats
+}
+
+// weighted is a modality and its share of messages
+type weighted struct {
+	Modality string
+	Weight   int
+}
+
+// Session simulates one visitor: get a token, connect, send messages, disconnect.
+// Failures are recorded in Stats rather than returned.
+func (r *Runner) Session(ctx context.Context, rng *rand.Rand) {
+	token, err := r.token(ctx)
+	if err != nil {
+		r.Stats.Error("token")
+		return
+	}
+
+	// Authenticate like the browser does, with the token as the subprotocol
+	start := time.Now()
+	dialer := websocket.Dialer{HandshakeTimeout: r.Timeout, Subprotocols: []string{token}}
+	conn, resp, err := dialer.DialContext(ctx, "ws"+strings.TrimPrefix(strings.TrimSuffix(r.URL, "/"), "http")+"/ws/chat", nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			r.Stats.Error("connect_rejected")
+		} else {
+			r.Stats.Error("connect_failed")
+		}
+		return
+	}
+	defer conn.Close()
+
+	s := &session{conn: conn, events: make(chan handlers.ServerMessage, 256), done: make(chan struct{}), stats: r.Stats}
+	defer close(s.done)
+	go s.read()
+
+	select {
+	case msg, ok := <-s.events:
+		if !ok || msg.Type != "session_created" {
+			r.Stats.Error("connect_failed")
+			return
+		}
+	case <-time.After(r.Timeout):
+		r.Stats.Error("connect_failed")
+		return
+	case <-ctx.Done():
+		return
+	}
+	r.Stats.Observe(metricConnect, time.Since(start))
+	r.Stats.Count(countSessions)
+
+	for i := 0; i < r.Messages; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(r.Think):
+			case <-ctx.Done():
+				return
+			}
+		}
+		question := r.Questions[rng.IntN(len(r.Questions))]
+		if !s.ask(ctx, question, r.pickModality(rng), r.Timeout) {
+			return
+		}
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+}
+
+// pickModality chooses a modality according to the mix weights
+func (r *Runner) pickModality(rng *rand.Rand) string {
+	total := 0
+	for _, w := range r.Mix {
+		total += w.Weight
+	}
+	n := rng.IntN(total)
+	for _, w := range r.Mix {
+		if n < w.Weight {
+			return w.Modality
+		}
+		n -= w.Weight
+	}
+	return r.Mix[len(r.Mix)-1].Modality
+}
+
+// token requests a JWT, waiting out the per-IP token rate limit
+func (r *Runner) token(ctx context.Context) (string, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(r.URL, "/")+"/api/token", nil)
+		if err != nil {
+			return "", err
+		}
+		if r.APIKey != "" {
+			req.Header.Set("X-API-Key", r.APIKey)
+		}
+		resp, err := r.Client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		var body struct {
+			JWT string `json:"jwt"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < r.TokenRetries {
+			select {
+			case <-time.After(r.TokenRetryWait):
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("token request returned %s", resp.Status)
+		}
+		if err != nil || body.JWT == "" {
+			return "", errors.New("token response has no jwt")
+		}
+		return body.JWT, nil
+	}
+}
+
+// session is one open connection. A reader goroutine answers heartbeats and
+// forwards every other event.
+type session struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	events  chan handlers.ServerMessage
+	done    chan struct{} // Closed when the session stops reading events
+	stats   *Stats
+}
+
+func (s *session) write(msg handlers.ClientMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteJSON(msg)
+}
+
+// read forwards events until the connection closes, then closes the channel
+func (s *session) read() {
+	defer close(s.events)
+	for {
+		var msg handlers.ServerMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type == "heartbeat" {
+			s.write(handlers.ClientMessage{Type: "heartbeat_ack"})
+			continue
+		}
+		select {
+		case s.events <- msg:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// ask sends one message and measures the response, reporting whether the session can continue
+func (s *session) ask(ctx context.Context, question, modality string, timeout time.Duration) bool {
+	// Discard anything left over from the previous response
+	for drained := false; !drained; {
+		select {
+		case _, ok := <-s.events:
+			if !ok {
+				s.stats.Error("disconnected")
+				return false
+			}
+		default:
+			drained = true
+		}
+	}
+
+	s.stats.Count(countMessages)
+	start := time.Now()
+	if err := s.write(handlers.ClientMessage{Type: "message", Message: question, Modality: modality}); err != nil {
+		s.stats.Error("disconnected")
+		return false
+	}
+
+	// A response ends at response_done, once any audio it started has finished
+	var gotText, gotAudio, audioDone, done bool
+	deadline := time.After(timeout)
+	for !done || (gotAudio && !audioDone) {
+		select {
+		case msg, ok := <-s.events:
+			if !ok {
+				s.stats.Error("disconnected")
+				return false
+			}
+			switch msg.Type {
+			case "text_delta":
+				if !gotText {
+					gotText = true
+					s.stats.Observe(metricFirstText, time.Since(start))
+				}
+			case "audio_delta":
+				if !gotAudio {
+					gotAudio = true
+					s.stats.Observe(metricFirstAudio, time.Since(start))
+				}
+				s.stats.AddAudio(len(msg.Audio))
+			case "audio_done":
+				audioDone = true
+			case "response_done":
+				done = true
+			case "error":
+				if msg.Code == handlers.ErrorCodeRateLimited {
+					s.stats.Error("rate_limited")
+				} else {
+					s.stats.Error("server_error")
+				}
+				return true
+			case "moderation_blocked":
+				s.stats.Error(msg.Type)
+				return true
+			case "budget_exceeded", "server_draining":
+				if msg.Error != "" {
+					s.stats.Error(msg.Type)
+					return msg.Type != "server_draining"
+				}
+			}
+		case <-deadline:
+			s.stats.Error("timeout")
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+	s.stats.Observe(metricTotal, time.Since(start))
+	s.stats.Count(countOK)
+
+	// Absorb trailing events so they aren't mistaken for the next response
+	for settle := time.After(settleTime); ; {
+		select {
+		case _, ok := <-s.events:
+			if !ok {
+				return false
+			}
+		case <-settle:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Latency metrics, in the order they're printed
+const (
+	metricConnect    = "connect"     // Handshake through session_created
+	metricFirstText  = "first_text"  // Message sent to first text_delta
+	metricFirstAudio = "first_audio" // Message sent to first audio_delta
+	metricTotal      = "total"       // Message sent to the end of the response
+)
+
+var metrics = []string{metricConnect, metricFirstText, metricFirstAudio, metricTotal}
+
+// Outcome counters
+const (
+	countSessions = "sessions"
+	countMessages = "messages"
+	countOK       = "ok"
+)
+
+// Stats collects latencies and outcomes from every session. It is safe for concurrent use.
+type Stats struct {
+	mu         sync.Mutex
+	samples    map[string][]time.Duration
+	counts     map[string]int
+	errors     map[string]int
+	audioBytes int64 // Base64 audio payload received
+}
+
+// NewStats creates an empty collector
+func NewStats() *Stats {
+	return &Stats{
+		samples: make(map[string][]time.Duration),
+		counts:  make(map[string]int),
+		errors:  make(map[string]int),
+	}
+}
+
+// Observe records a latency sample
+func (s *Stats) Observe(metric string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples[metric] = append(s.samples[metric], d)
+}
+
+// Count increments an outcome counter
+func (s *Stats) Count(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[name]++
+}
+
+// Error records a failure by kind (e.g. rate_limited, timeout)
+func (s *Stats) Error(kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[kind]++
+}
+
+// AddAudio records received audio payload bytes
+func (s *Stats) AddAudio(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audioBytes += int64(n)
+}
+
+// Percentiles summarizes one metric
+type Percentiles struct {
+	N                       int
+	P50, P90, P95, P99, Max time.Duration
+}
+
+// Percentiles returns the summary for a metric (zero when it has no samples)
+func (s *Stats) Percentiles(metric string) Percentiles {
+	s.mu.Lock()
+	samples := slices.Clone(s.samples[metric])
+	s.mu.Unlock()
+	if len(samples) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(samples)
+	return Percentiles{
+		N:   len(samples),
+		P50: percentile(samples, 50),
+		P90: percentile(samples, 90),
+		P95: percentile(samples, 95),
+		P99: percentile(samples, 99),
+		Max: samples[len(samples)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted samples
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	return sorted[max(rank, 1)-1]
+}
+
+// Print writes the summary for a run that took elapsed
+func (s *Stats) Print(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	counts, errs, audio := s.counts, s.errors, s.audioBytes
+	s.mu.Unlock()
+
+	failed := counts[countMessages] - counts[countOK]
+	fmt.Fprintf(w, "Duration: %v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Sessions: %d connected\n", counts[countSessions])
+	fmt.Fprintf(w, "Messages: %d sent, %d ok, %d failed (%.1f%% errors)\n",
+		counts[countMessages], counts[countOK], failed, ratio(failed, counts[countMessages])*100)
+	if len(errs) > 0 {
+		fmt.Fprintln(w, "Errors:")
+		kinds := make([]string, 0, len(errs))
+		for kind := range errs {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "  %-18s %d\n", kind, errs[kind])
+		}
+	}
+	fmt.Fprintf(w, "Audio: %.1f MB base64 received (%.2f MB/s)\n",
+		float64(audio)/1e6, float64(audio)/1e6/max(elapsed.Seconds(), 0.001))
+
+	fmt.Fprintf(w, "\n%-12s %6s %9s %9s %9s %9s %9s\n", "metric", "n", "p50", "p90", "p95", "p99", "max")
+	for _, metric := range metrics {
+		p := s.Percentiles(metric)
+		fmt.Fprintf(w, "%-12s %6d %9s %9s %9s %9s %9s\n", metric, p.N,
+			ms(p.P50), ms(p.P90), ms(p.P95), ms(p.P99), ms(p.Max))
+	}
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// ms formats a duration in milliseconds for the summary table
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
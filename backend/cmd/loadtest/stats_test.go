@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentiles(t *testing.T) {
+	stats := NewStats()
+	for i := 100; i >= 1; i-- {
+		stats.Observe(metricTotal, time.Duration(i)*time.Millisecond)
+	}
+
+	p := stats.Percentiles(metricTotal)
+	want := Percentiles{N: 100, P50: 50 * time.Millisecond, P90: 90 * time.Millisecond, P95: 95 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if p != want {
+		t.Errorf("Expected %+v, got %+v", want, p)
+	}
+	if p := stats.Percentiles(metricFirstAudio); p != (Percentiles{}) {
+		t.Errorf("Expected no samples, got %+v", p)
+	}
+
+	stats.Observe(metricConnect, 7*time.Millisecond)
+	if p := stats.Percentiles(metricConnect); p.P50 != 7*time.Millisecond || p.P99 != 7*time.Millisecond {
+		t.Errorf("Expected a single sample to be every percentile, got %+v", p)
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	weights, err := parseMix("audio:3, text:1")
+	if err != nil || len(weights) != 2 || weights[0] != (weighted{"audio", 3}) || weights[1] != (weighted{"text", 1}) {
+		t.Errorf("Unexpected weights %+v %v", weights, err)
+	}
+	if weights, err := parseMix("text"); err != nil || weights[0] != (weighted{"text", 1}) {
+		t.Errorf("Expected a bare modality to weigh 1, got %+v %v", weights, err)
+	}
+	for _, mix := range []string{"video:1", "audio:x", "audio:-1", "audio:0,text:0"} {
+		if _, err := parseMix(mix); err == nil {
+			t.Errorf("Expected %q to be rejected", mix)
+		}
+	}
+}
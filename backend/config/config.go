@@ -1,71 +1,906 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
+	"christianmoore.me/avatar-backend/origins"
+	"github.com/goccy/go-yaml"
 	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
 )
 
 type Config struct {
-	OpenAIAPIKey     string
-	OpenAIModel      string
-	Port             string
-	SystemPrompt     string
-	JWTSecret        string
-	TurnstileSecret  string
-	TurnstileSiteKey string
-	UseLocalPipeline bool
-	LocalLLMURL      string
-	TTSURL           string
-	TTSVoice         string
-	TTSSpeed         string
+	OpenAIAPIKey      string
+	OpenAIModel       string
+	OpenAIRealtimeURL string // Realtime WebSocket endpoint (e.g. a fake from cmd/fakeupstreams)
+	Port              string
+	SystemPrompt      string
+	SystemPromptPath  string
+	SystemPromptFile  string // File the prompt was actually read from ("" if the fallback text is used)
+	JWTSecret         string
+	JWTExpiration     time.Duration
+	TurnstileSecret   string
+	TurnstileSiteKey  string
+	UseLocalPipeline  bool
+	LocalLLMURL       string
+	LocalLLMModel     string
+	TTSURL            string
+	TTSModel          string
+	TTSVoice          string
+	TTSSpeed          float64
+	RealtimeVoice     string
+	APIKeysFile       string
+	RedisURL          string
+	RedisKeyPrefix    string
+	ConfigFile        string
+	AdminToken        string
+
+	// Realtime session settings. The voice comes from the persona (REALTIME_VOICE).
+	RealtimeOutputModality  string // "audio" (with transcript) or "text"
+	RealtimeMaxOutputTokens int    // 0 = model maximum
+	RealtimeTurnDetection   string // "server_vad", "semantic_vad" or "" for the API default
+
+	// Settings clients may override per session; empty disables the override
+	SessionVoices     []string
+	SessionModalities []string
+
+	// Local LLM sampling parameters (nil/0/empty leaves the server default)
+	LLMTemperature *float64
+	LLMTopP        *float64
+	LLMMaxTokens   int
+	LLMStop        []string
+
+	// Audio format requested from the TTS server ("wav" or "pcm")
+	TTSResponseFormat string
+
+	// Check at startup that the LLM and TTS servers list the configured models
+	ValidateModels bool
+
+	// How often the prompt and config files are checked for changes (0 = only on SIGHUP)
+	PersonaReloadInterval time.Duration
+
+	// Directory sessions are recorded to for replay (see the recording package); empty disables recording
+	RecordDir string
+
+	// Cache of answers to first-turn questions (see the cache package); a TTL of 0 disables it
+	ResponseCacheTTL        time.Duration
+	ResponseCacheMaxEntries int
+	ResponseCacheMaxBytes   int
+
+	// Reuse of cached answers for paraphrased questions, matched by the similarity of
+	// their embeddings: "off", "shadow" (log would-be hits but answer normally) or "on"
+	SemanticCacheMode       string
+	SemanticCacheThreshold  float64 // Minimum cosine similarity for a match
+	SemanticCacheMaxEntries int
+
+	// OpenAI-compatible embeddings server used by the semantic cache
+	EmbeddingsURL   string
+	EmbeddingsModel string
+
+	// Synthesized speech cache: memory limit (0 disables the cache), and an optional
+	// directory (e.g. under /app/data) with its own limit so clips survive restarts
+	TTSCacheMemoryBytes int
+	TTSCacheDir         string
+	TTSCacheDiskBytes   int
+
+	// Texts of canned server messages, keyed by phrase name (greeting, rate_limit,
+	// refusal for declined messages), and the phrases spoken with audio synthesized at
+	// startup. The greeting is only sent when its text is set.
+	Phrases       map[string]string
+	SpokenPhrases []string
+
+	// Screening of visitor messages before they reach a model (see the moderation
+	// package). Each check has an action: block, warn, flag or off.
+	ModerationPatterns      []string // Case-insensitive regular expressions, e.g. prompt injection phrases
+	ModerationPatternAction string
+	ModerationMaxLinks      int // 0 = any number
+	ModerationLinkAction    string
+	ModerationScripts       []string // Unicode scripts messages should be written in, e.g. Latin (none = any)
+	ModerationScriptAction  string
+
+	// Optional OpenAI-compatible moderation endpoint, and the action for messages it flags
+	ModerationAPIURL      string
+	ModerationAPIModel    string
+	ModerationAPIAction   string
+	ModerationAPIUpstream UpstreamConfig
+
+	// Checks of generated answers (see the guardrails package): "off", "monitor" (log
+	// violations) or "enforce" (also fix local pipeline answers before they're sent;
+	// Realtime answers are always only monitored)
+	GuardrailsMode         string
+	GuardrailsMaxSentences int // 0 = any number
+	GuardrailsMaxChars     int // 0 = any length
+	GuardrailsLeakWords    int // Words copied from the prompt's instructions in a row that count as a leak, 0 disables
+
+	// Backends tried in order for each turn ("realtime", "local"), failing over on errors.
+	// Defaults to the single backend selected by USE_LOCAL_PIPELINE.
+	Backends []string
+
+	// HTTP client settings for the local LLM and TTS servers
+	LLMUpstream UpstreamConfig
+	TTSUpstream UpstreamConfig
+
+	// HTTP client settings for the embeddings server
+	EmbeddingsUpstream UpstreamConfig
+
+	// Per-backend circuit breakers and how long a Realtime turn may take to start
+	BreakerFailureThreshold int
+	BreakerCooldown         time.Duration
+	RealtimeResponseTimeout time.Duration
+
+	// Origin patterns allowed by CORS and the WebSocket origin check (see origins.Policy)
+	CORSOrigins []string
+
+	// Message validation, rate limiting and connection limits
+	MaxMessageLength    int
+	MessageRateInterval time.Duration
+	MessageBurst        int
+	MaxConnectionsPerIP int
+	ConnectionTimeout   time.Duration
+	HeartbeatInterval   time.Duration
+
+	// How long shutdown waits for in-flight responses before closing connections
+	ShutdownTimeout time.Duration
+
+	// How often readiness re-checks the LLM/TTS servers and the Realtime API
+	ReadinessCheckInterval time.Duration
+	RealtimeCheckInterval  time.Duration
+
+	// Paid Realtime API budgets (0 = unlimited)
+	BudgetSessionTokens      int
+	BudgetSessionCostUSD     float64
+	BudgetIPDailyTokens      int
+	BudgetIPDailyCostUSD     float64
+	BudgetGlobalDailyTokens  int
+	BudgetGlobalDailyCostUSD float64
+	BudgetFallbackToLocal    bool
+
+	// Realtime API prices in USD per 1M tokens (defaults match gpt-realtime-mini)
+	PriceTextInputPerMTok        float64
+	PriceAudioInputPerMTok       float64
+	PriceCachedTextInputPerMTok  float64
+	PriceCachedAudioInputPerMTok float64
+	PriceTextOutputPerMTok       float64
+	PriceAudioOutputPerMTok      float64
+
+	// Problems found while loading (invalid values, unknown file keys), reported by Validate
+	problems []string
+}
+
+// MaxRealtimeOutputTokens is the largest per-response output cap the Realtime API accepts
+const MaxRealtimeOutputTokens = 4096
+
+// Canned server messages (see Config.Phrases)
+const (
+	PhraseGreeting  = "greeting"
+	PhraseRateLimit = "rate_limit"
+	PhraseRefusal   = "refusal"
+)
+
+// defaultPhrases are the built-in texts of the canned server messages
+var defaultPhrases = map[string]string{
+	PhraseGreeting:  "",
+	PhraseRateLimit: "Rate limit exceeded. Please wait before sending another message.",
+	PhraseRefusal:   "Sorry, I can only answer questions about Christian's background and experience.",
+}
+
+// Phrase returns the text of a canned server message, falling back to the built-in text
+func (c *Config) Phrase(name string) string {
+	if text, ok := c.Phrases[name]; ok {
+		return text
+	}
+	return defaultPhrases[name]
+}
+
+// defaultModerationPatterns catch common attempts to override the system prompt
+var defaultModerationPatterns = []string{
+	`\b(ignore|disregard|forget|override)\s+(all\s+|any\s+)?(of\s+)?(the\s+|your\s+)?(previous|prior|above|earlier|original|system)\s+(instructions|prompts?|rules|directions)`,
+	`\b(reveal|show|print|repeat|output|display)\s+(me\s+)?(your|the)\s+(system\s+prompt|initial\s+prompt|instructions|hidden\s+prompt)`,
+	`\byou\s+are\s+now\s+(a|an|in)\b`,
+	`\b(developer|god|dan)\s+mode\b`,
+	`\bjailbreak`,
+	`</?(system|assistant)>|\[/?(system|inst)\]`,
 }
 
+// UpstreamConfig holds HTTP client settings for one upstream service. Keys are the
+// upstream's prefix plus the setting, e.g. TTS_CONNECT_TIMEOUT or LLM_MAX_RETRIES.
+type UpstreamConfig struct {
+	ConnectTimeout        time.Duration // TCP connect and TLS handshake
+	ResponseHeaderTimeout time.Duration // Time to first response byte
+	RequestTimeout        time.Duration // Whole request per attempt, 0 for streaming upstreams
+	MaxRetries            int           // Retries for idempotent requests
+	RetryBackoff          time.Duration // Base delay, doubled per retry with full jitter
+	MaxIdleConns          int           // Pooled keep-alive connections
+
+	// TLS for private endpoints: extra CA bundle, client certificate for mTLS, SNI/verification
+	// name override and minimum version ("1.2" or "1.3"). Files are reloaded when they change.
+	CAFile        string
+	CertFile      string
+	KeyFile       string
+	ServerName    string
+	TLSMinVersion string
+
+	// Bearer token sent in the Authorization header (optional)
+	AuthToken string
+
+	// Circuit breaker (shared BREAKER_* settings)
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// Load builds the configuration from defaults, an optional config file (CONFIG_FILE,
+// YAML or TOML) and environment variables, in increasing order of precedence.
+// Config file keys are the environment variable names in lowercase (e.g. max_message_length).
 func Load() *Config {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Warning: No .env file found: %v", err)
 	}
 
-	cfg := &Config{
-		OpenAIAPIKey:     getEnv("OPENAI_API_KEY", ""),
-		OpenAIModel:      getEnv("OPENAI_MODEL", "gpt-realtime-mini"),
-		Port:             getEnv("PORT", "8080"),
-		JWTSecret:        getEnv("JWT_SECRET", ""),
-		TurnstileSecret:  getEnv("TURNSTILE_SECRET", ""),
-		TurnstileSiteKey: getEnv("TURNSTILE_SITE_KEY", ""),
-		UseLocalPipeline: getEnv("USE_LOCAL_PIPELINE", "false") == "true",
-		LocalLLMURL:      getEnv("LOCAL_LLM_URL", "https://llama.k3s.local.christianmoore.me:8443/qwen2.5-7b-instruct"),
-		TTSURL:           getEnv("TTS_URL", "https://llama.k3s.local.christianmoore.me:8443"),
-		TTSVoice:         getEnv("TTS_VOICE", "onyx"),
-		TTSSpeed:         getEnv("TTS_SPEED", "0.95"),
-	}
-
-	// Load system prompt from file (check data volume first, then fall back to local)
-	promptPath := getEnv("SYSTEM_PROMPT_PATH", "/app/data/system_prompt.txt")
-	promptBytes, err := os.ReadFile(promptPath)
-	if err != nil {
-		// Fall back to local file if volume mount not available
-		log.Printf("Warning: Could not load system prompt from %s: %v, trying local file", promptPath, err)
-		promptBytes, err = os.ReadFile("system_prompt.txt")
-		if err != nil {
-			log.Printf("Warning: Could not load system_prompt.txt: %v", err)
-			cfg.SystemPrompt = "Apologize that you were unable to load persona instructions. Refuse to answer any questions."
+	l := &loader{seen: make(map[string]bool)}
+	configFile := getEnv("CONFIG_FILE", "")
+	if path := configFile; path != "" {
+		if err := l.readFile(path); err != nil {
+			l.problems = append(l.problems, err.Error())
 		} else {
-			cfg.SystemPrompt = string(promptBytes)
+			log.Printf("Loaded config file %s", path)
 		}
-	} else {
-		cfg.SystemPrompt = string(promptBytes)
-		log.Printf("Loaded system prompt from %s (%d bytes)", promptPath, len(promptBytes))
 	}
 
+	cfg := &Config{
+		OpenAIAPIKey:      l.str("OPENAI_API_KEY", ""),
+		OpenAIModel:       l.str("OPENAI_MODEL", "gpt-realtime-mini"),
+		OpenAIRealtimeURL: l.str("OPENAI_REALTIME_URL", "wss://api.openai.com/v1/realtime"),
+		Port:              l.str("PORT", "8080"),
+		SystemPromptPath:  l.str("SYSTEM_PROMPT_PATH", "/app/data/system_prompt.txt"),
+		JWTSecret:         l.str("JWT_SECRET", ""),
+		JWTExpiration:     l.duration("JWT_EXPIRATION", 30*time.Minute),
+		TurnstileSecret:   l.str("TURNSTILE_SECRET", ""),
+		TurnstileSiteKey:  l.str("TURNSTILE_SITE_KEY", ""),
+		UseLocalPipeline:  l.boolean("USE_LOCAL_PIPELINE", false),
+		LocalLLMURL:       l.str("LOCAL_LLM_URL", ""),
+		LocalLLMModel:     l.str("LOCAL_LLM_MODEL", "qwen2.5-7b-instruct"),
+		TTSURL:            l.str("TTS_URL", ""),
+		TTSModel:          l.str("TTS_MODEL", "neutss-air-4b"),
+		TTSVoice:          l.str("TTS_VOICE", "onyx"),
+		TTSSpeed:          l.float("TTS_SPEED", 0.95),
+		RealtimeVoice:     l.str("REALTIME_VOICE", "cedar"),
+		APIKeysFile:       l.str("API_KEYS_FILE", ""),
+		RedisURL:          l.str("REDIS_URL", ""),
+		RedisKeyPrefix:    l.str("REDIS_KEY_PREFIX", "avatar:"),
+		ConfigFile:        configFile,
+		AdminToken:        l.str("ADMIN_TOKEN", ""),
+
+		RealtimeOutputModality:  l.str("REALTIME_OUTPUT_MODALITY", "audio"),
+		RealtimeMaxOutputTokens: l.integer("REALTIME_MAX_OUTPUT_TOKENS", 0),
+		RealtimeTurnDetection:   l.str("REALTIME_TURN_DETECTION", ""),
+		SessionVoices:           l.list("SESSION_VOICES", nil),
+		SessionModalities:       l.list("SESSION_MODALITIES", []string{"audio", "text"}),
+
+		LLMTemperature:    l.optionalFloat("LLM_TEMPERATURE"),
+		LLMTopP:           l.optionalFloat("LLM_TOP_P"),
+		LLMMaxTokens:      l.integer("LLM_MAX_TOKENS", 0),
+		LLMStop:           l.lines("LLM_STOP", nil),
+		TTSResponseFormat: l.str("TTS_RESPONSE_FORMAT", "wav"),
+		ValidateModels:    l.boolean("VALIDATE_MODELS", true),
+
+		PersonaReloadInterval: l.duration("PERSONA_RELOAD_INTERVAL", 10*time.Second),
+		RecordDir:             l.str("RECORD_DIR", ""),
+
+		ResponseCacheTTL:        l.duration("RESPONSE_CACHE_TTL", 0),
+		ResponseCacheMaxEntries: l.integer("RESPONSE_CACHE_MAX_ENTRIES", 500),
+		ResponseCacheMaxBytes:   l.integer("RESPONSE_CACHE_MAX_BYTES", 64<<20),
+
+		SemanticCacheMode:       l.str("SEMANTIC_CACHE_MODE", "off"),
+		SemanticCacheThreshold:  l.float("SEMANTIC_CACHE_THRESHOLD", 0.92),
+		SemanticCacheMaxEntries: l.integer("SEMANTIC_CACHE_MAX_ENTRIES", 2000),
+		EmbeddingsURL:           l.str("EMBEDDINGS_URL", ""),
+		EmbeddingsModel:         l.str("EMBEDDINGS_MODEL", ""),
+
+		TTSCacheMemoryBytes: l.integer("TTS_CACHE_MEMORY_BYTES", 32<<20),
+		TTSCacheDir:         l.str("TTS_CACHE_DIR", ""),
+		TTSCacheDiskBytes:   l.integer("TTS_CACHE_DISK_BYTES", 512<<20),
+
+		Phrases: map[string]string{
+			PhraseGreeting:  l.str("PHRASE_GREETING", defaultPhrases[PhraseGreeting]),
+			PhraseRateLimit: l.str("PHRASE_RATE_LIMIT", defaultPhrases[PhraseRateLimit]),
+			PhraseRefusal:   l.str("PHRASE_REFUSAL", defaultPhrases[PhraseRefusal]),
+		},
+		SpokenPhrases: l.list("SPOKEN_PHRASES", nil),
+
+		ModerationPatterns:      l.lines("MODERATION_PATTERNS", defaultModerationPatterns),
+		ModerationPatternAction: l.str("MODERATION_PATTERN_ACTION", "block"),
+		ModerationMaxLinks:      l.integer("MODERATION_MAX_LINKS", 2),
+		ModerationLinkAction:    l.str("MODERATION_LINK_ACTION", "block"),
+		ModerationScripts:       l.list("MODERATION_SCRIPTS", nil),
+		ModerationScriptAction:  l.str("MODERATION_SCRIPT_ACTION", "warn"),
+		ModerationAPIURL:        l.str("MODERATION_API_URL", ""),
+		ModerationAPIModel:      l.str("MODERATION_API_MODEL", "omni-moderation-latest"),
+		ModerationAPIAction:     l.str("MODERATION_API_ACTION", "block"),
+
+		GuardrailsMode:         l.str("GUARDRAILS_MODE", "monitor"),
+		GuardrailsMaxSentences: l.integer("GUARDRAILS_MAX_SENTENCES", 4),
+		GuardrailsMaxChars:     l.integer("GUARDRAILS_MAX_CHARS", 600),
+		GuardrailsLeakWords:    l.integer("GUARDRAILS_LEAK_WORDS", 20),
+
+		CORSOrigins: l.list("CORS_ORIGINS", []string{"http://localhost:5173", "http://localhost:3000", "https://christianmoore.me"}),
+
+		MaxMessageLength:    l.integer("MAX_MESSAGE_LENGTH", 4000),
+		MessageRateInterval: l.duration("MESSAGE_RATE_INTERVAL", 5*time.Second),
+		MessageBurst:        l.integer("MESSAGE_BURST", 3),
+		MaxConnectionsPerIP: l.integer("MAX_CONNECTIONS_PER_IP", 10),
+		ConnectionTimeout:   l.duration("CONNECTION_TIMEOUT", 10*time.Minute),
+		HeartbeatInterval:   l.duration("HEARTBEAT_INTERVAL", 30*time.Second),
+		ShutdownTimeout:     l.duration("SHUTDOWN_TIMEOUT", 25*time.Second),
+
+		ReadinessCheckInterval: l.duration("READINESS_CHECK_INTERVAL", 30*time.Second),
+		RealtimeCheckInterval:  l.duration("REALTIME_CHECK_INTERVAL", 5*time.Minute),
+
+		BudgetSessionTokens:      l.integer("BUDGET_SESSION_TOKENS", 0),
+		BudgetSessionCostUSD:     l.float("BUDGET_SESSION_COST_USD", 0),
+		BudgetIPDailyTokens:      l.integer("BUDGET_IP_DAILY_TOKENS", 0),
+		BudgetIPDailyCostUSD:     l.float("BUDGET_IP_DAILY_COST_USD", 0),
+		BudgetGlobalDailyTokens:  l.integer("BUDGET_GLOBAL_DAILY_TOKENS", 0),
+		BudgetGlobalDailyCostUSD: l.float("BUDGET_GLOBAL_DAILY_COST_USD", 0),
+		BudgetFallbackToLocal:    l.boolean("BUDGET_FALLBACK_TO_LOCAL", false),
+
+		PriceTextInputPerMTok:        l.float("PRICE_TEXT_INPUT_PER_MTOK", 0.60),
+		PriceAudioInputPerMTok:       l.float("PRICE_AUDIO_INPUT_PER_MTOK", 10.00),
+		PriceCachedTextInputPerMTok:  l.float("PRICE_CACHED_TEXT_INPUT_PER_MTOK", 0.06),
+		PriceCachedAudioInputPerMTok: l.float("PRICE_CACHED_AUDIO_INPUT_PER_MTOK", 0.30),
+		PriceTextOutputPerMTok:       l.float("PRICE_TEXT_OUTPUT_PER_MTOK", 2.40),
+		PriceAudioOutputPerMTok:      l.float("PRICE_AUDIO_OUTPUT_PER_MTOK", 20.00),
+	}
+	defaultBackends := []string{"realtime"}
+	if cfg.UseLocalPipeline {
+		defaultBackends = []string{"local"}
+	}
+	cfg.Backends = l.list("BACKENDS", defaultBackends)
+	cfg.BreakerFailureThreshold = l.integer("BREAKER_FAILURE_THRESHOLD", 3)
+	cfg.BreakerCooldown = l.duration("BREAKER_COOLDOWN", 30*time.Second)
+
+	// LLM responses stream, so there's no whole-request timeout and no retries
+	cfg.LLMUpstream = l.upstream("LLM", UpstreamConfig{
+		ConnectTimeout:        5 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		RetryBackoff:          250 * time.Millisecond,
+		MaxIdleConns:          16,
+	}, cfg)
+	cfg.TTSUpstream = l.upstream("TTS", UpstreamConfig{
+		ConnectTimeout:        5 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+		RequestTimeout:        90 * time.Second,
+		MaxRetries:            2,
+		RetryBackoff:          250 * time.Millisecond,
+		MaxIdleConns:          16,
+	}, cfg)
+	// Embedding happens before answering, so it has to fail fast
+	cfg.EmbeddingsUpstream = l.upstream("EMBEDDINGS", UpstreamConfig{
+		ConnectTimeout:        2 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Second,
+		RequestTimeout:        3 * time.Second,
+		RetryBackoff:          100 * time.Millisecond,
+		MaxIdleConns:          4,
+	}, cfg)
+	// Like embeddings, moderation runs before answering
+	cfg.ModerationAPIUpstream = l.upstream("MODERATION_API", UpstreamConfig{
+		ConnectTimeout:        2 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Second,
+		RequestTimeout:        3 * time.Second,
+		RetryBackoff:          100 * time.Millisecond,
+		MaxIdleConns:          4,
+	}, cfg)
+	cfg.RealtimeResponseTimeout = l.duration("REALTIME_RESPONSE_TIMEOUT", 10*time.Second)
+
+	cfg.problems = append(l.problems, l.unknownKeys()...)
+
+	prompt, file, err := ReadSystemPrompt(cfg.SystemPromptPath)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		prompt = "Apologize that you were unable to load persona instructions. Refuse to answer any questions."
+	}
+	cfg.SystemPrompt = prompt
+	cfg.SystemPromptFile = file
+
 	return cfg
 }
 
+// UsesBackend reports whether name is in the backend failover order
+func (c *Config) UsesBackend(name string) bool {
+	for _, backend := range c.Backends {
+		if backend == name {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalPipelineEnabled reports whether the local LLM + TTS pipeline is needed, as a
+// failover backend or as the fallback once the paid budget runs out
+func (c *Config) LocalPipelineEnabled() bool {
+	return c.UsesBackend("local") || c.BudgetFallbackToLocal
+}
+
+// ReadSystemPrompt loads the system prompt from path (the data volume), falling back to
+// ./system_prompt.txt. Returns the prompt and the file it was read from.
+func ReadSystemPrompt(path string) (string, string, error) {
+	promptBytes, err := os.ReadFile(path)
+	if err == nil {
+		log.Printf("Loaded system prompt from %s (%d bytes)", path, len(promptBytes))
+		return string(promptBytes), path, nil
+	}
+
+	// Fall back to local file if volume mount not available
+	log.Printf("Warning: Could not load system prompt from %s: %v, trying local file", path, err)
+	promptBytes, err = os.ReadFile("system_prompt.txt")
+	if err != nil {
+		return "", "", fmt.Errorf("could not load system_prompt.txt: %w", err)
+	}
+	return string(promptBytes), "system_prompt.txt", nil
+}
+
+// Validate checks the configuration and returns every problem found, one per line
+func (c *Config) Validate() error {
+	problems := append([]string{}, c.problems...)
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		add("PORT: must be a number between 1 and 65535, got %q", c.Port)
+	}
+	if len(c.Backends) == 0 {
+		add("BACKENDS: at least one backend is required")
+	}
+	seen := make(map[string]bool)
+	for _, backend := range c.Backends {
+		if backend != "realtime" && backend != "local" {
+			add("BACKENDS: unknown backend %q (expected realtime or local)", backend)
+		}
+		if seen[backend] {
+			add("BACKENDS: %q listed more than once", backend)
+		}
+		seen[backend] = true
+	}
+	if c.UsesBackend("realtime") && c.OpenAIAPIKey == "" {
+		add("OPENAI_API_KEY: required when the realtime backend is enabled")
+	}
+	if c.LocalPipelineEnabled() {
+		if c.LocalLLMURL == "" {
+			add("LOCAL_LLM_URL: required when the local pipeline is enabled")
+		}
+		if c.TTSURL == "" {
+			add("TTS_URL: required when the local pipeline is enabled")
+		}
+	}
+	for key, value := range map[string]string{"LOCAL_LLM_URL": c.LocalLLMURL, "TTS_URL": c.TTSURL, "EMBEDDINGS_URL": c.EmbeddingsURL, "MODERATION_API_URL": c.ModerationAPIURL} {
+		if value != "" && !isHTTPURL(value) {
+			add("%s: must be an http(s) URL, got %q", key, value)
+		}
+	}
+	if u, err := url.Parse(c.OpenAIRealtimeURL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		add("OPENAI_REALTIME_URL: must be a ws(s) URL, got %q", c.OpenAIRealtimeURL)
+	}
+
+	if len(c.CORSOrigins) == 0 {
+		add("CORS_ORIGINS: at least one origin is required")
+	}
+	if _, err := origins.NewPolicy(c.CORSOrigins); err != nil {
+		add("CORS_ORIGINS: %v", err)
+	}
+
+	if c.TTSSpeed < 0.25 || c.TTSSpeed > 4.0 {
+		add("TTS_SPEED: must be between 0.25 and 4.0, got %g", c.TTSSpeed)
+	}
+	if !isModality(c.RealtimeOutputModality) {
+		add("REALTIME_OUTPUT_MODALITY: must be audio or text, got %q", c.RealtimeOutputModality)
+	}
+	for _, modality := range c.SessionModalities {
+		if !isModality(modality) {
+			add("SESSION_MODALITIES: must be audio or text, got %q", modality)
+		}
+	}
+	if c.RealtimeMaxOutputTokens < 0 || c.RealtimeMaxOutputTokens > MaxRealtimeOutputTokens {
+		add("REALTIME_MAX_OUTPUT_TOKENS: must be between 0 (model maximum) and %d, got %d", MaxRealtimeOutputTokens, c.RealtimeMaxOutputTokens)
+	}
+	switch c.RealtimeTurnDetection {
+	case "", "server_vad", "semantic_vad":
+	default:
+		add("REALTIME_TURN_DETECTION: must be server_vad or semantic_vad, got %q", c.RealtimeTurnDetection)
+	}
+	if c.TTSResponseFormat != "wav" && c.TTSResponseFormat != "pcm" {
+		add("TTS_RESPONSE_FORMAT: must be wav or pcm, got %q", c.TTSResponseFormat)
+	}
+	if c.LLMTemperature != nil && (*c.LLMTemperature < 0 || *c.LLMTemperature > 2) {
+		add("LLM_TEMPERATURE: must be between 0 and 2, got %g", *c.LLMTemperature)
+	}
+	if c.LLMTopP != nil && (*c.LLMTopP <= 0 || *c.LLMTopP > 1) {
+		add("LLM_TOP_P: must be greater than 0 and at most 1, got %g", *c.LLMTopP)
+	}
+	if c.LLMMaxTokens < 0 {
+		add("LLM_MAX_TOKENS: must not be negative, got %d", c.LLMMaxTokens)
+	}
+	if len(c.LLMStop) > 4 {
+		add("LLM_STOP: at most 4 stop sequences are allowed, got %d", len(c.LLMStop))
+	}
+	positiveInts := map[string]int{
+		"MAX_MESSAGE_LENGTH":        c.MaxMessageLength,
+		"MESSAGE_BURST":             c.MessageBurst,
+		"MAX_CONNECTIONS_PER_IP":    c.MaxConnectionsPerIP,
+		"BREAKER_FAILURE_THRESHOLD": c.BreakerFailureThreshold,
+	}
+	for key, value := range positiveInts {
+		if value <= 0 {
+			add("%s: must be positive, got %d", key, value)
+		}
+	}
+	positiveDurations := map[string]time.Duration{
+		"JWT_EXPIRATION":            c.JWTExpiration,
+		"MESSAGE_RATE_INTERVAL":     c.MessageRateInterval,
+		"CONNECTION_TIMEOUT":        c.ConnectionTimeout,
+		"HEARTBEAT_INTERVAL":        c.HeartbeatInterval,
+		"SHUTDOWN_TIMEOUT":          c.ShutdownTimeout,
+		"BREAKER_COOLDOWN":          c.BreakerCooldown,
+		"REALTIME_RESPONSE_TIMEOUT": c.RealtimeResponseTimeout,
+		"READINESS_CHECK_INTERVAL":  c.ReadinessCheckInterval,
+		"REALTIME_CHECK_INTERVAL":   c.RealtimeCheckInterval,
+	}
+	for key, value := range positiveDurations {
+		if value <= 0 {
+			add("%s: must be a positive duration, got %s", key, value)
+		}
+	}
+	if c.PersonaReloadInterval < 0 {
+		add("PERSONA_RELOAD_INTERVAL: must not be negative, got %s", c.PersonaReloadInterval)
+	}
+	if c.ResponseCacheTTL < 0 {
+		add("RESPONSE_CACHE_TTL: must not be negative, got %s", c.ResponseCacheTTL)
+	}
+	if c.ResponseCacheTTL > 0 && (c.ResponseCacheMaxEntries <= 0 || c.ResponseCacheMaxBytes <= 0) {
+		add("RESPONSE_CACHE_MAX_ENTRIES / RESPONSE_CACHE_MAX_BYTES: must be positive when the response cache is enabled")
+	}
+	switch c.SemanticCacheMode {
+	case "off":
+	case "shadow", "on":
+		if c.ResponseCacheTTL == 0 {
+			add("SEMANTIC_CACHE_MODE: requires RESPONSE_CACHE_TTL, which enables the response cache")
+		}
+		if c.EmbeddingsURL == "" || c.EmbeddingsModel == "" {
+			add("EMBEDDINGS_URL / EMBEDDINGS_MODEL: required when the semantic cache is enabled")
+		}
+		if c.SemanticCacheThreshold <= 0 || c.SemanticCacheThreshold > 1 {
+			add("SEMANTIC_CACHE_THRESHOLD: must be greater than 0 and at most 1, got %g", c.SemanticCacheThreshold)
+		}
+		if c.SemanticCacheMaxEntries <= 0 {
+			add("SEMANTIC_CACHE_MAX_ENTRIES: must be positive, got %d", c.SemanticCacheMaxEntries)
+		}
+	default:
+		add("SEMANTIC_CACHE_MODE: must be off, shadow or on, got %q", c.SemanticCacheMode)
+	}
+	if c.TTSCacheMemoryBytes < 0 || c.TTSCacheDiskBytes < 0 {
+		add("TTS_CACHE_MEMORY_BYTES / TTS_CACHE_DISK_BYTES: must not be negative")
+	}
+	if c.TTSCacheDir != "" && c.TTSCacheMemoryBytes == 0 {
+		add("TTS_CACHE_DIR: requires TTS_CACHE_MEMORY_BYTES, which enables the cache")
+	}
+	for _, name := range c.SpokenPhrases {
+		if _, ok := defaultPhrases[name]; !ok {
+			add("SPOKEN_PHRASES: unknown phrase %q (must be greeting, rate_limit or refusal)", name)
+		}
+	}
+	if len(c.SpokenPhrases) > 0 && !c.LocalPipelineEnabled() {
+		add("SPOKEN_PHRASES: phrases are synthesized by the local TTS server, which isn't used (see BACKENDS)")
+	}
+	moderationActions := map[string]string{
+		"MODERATION_PATTERN_ACTION": c.ModerationPatternAction,
+		"MODERATION_LINK_ACTION":    c.ModerationLinkAction,
+		"MODERATION_SCRIPT_ACTION":  c.ModerationScriptAction,
+		"MODERATION_API_ACTION":     c.ModerationAPIAction,
+	}
+	for key, value := range moderationActions {
+		switch value {
+		case "block", "warn", "flag", "off":
+		default:
+			add("%s: must be block, warn, flag or off, got %q", key, value)
+		}
+	}
+	for _, pattern := range c.ModerationPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			add("MODERATION_PATTERNS: invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if c.ModerationMaxLinks < 0 {
+		add("MODERATION_MAX_LINKS: must not be negative, got %d", c.ModerationMaxLinks)
+	}
+	for _, script := range c.ModerationScripts {
+		if _, ok := unicode.Scripts[script]; !ok {
+			add("MODERATION_SCRIPTS: unknown Unicode script %q (e.g. Latin, Cyrillic, Han)", script)
+		}
+	}
+	switch c.GuardrailsMode {
+	case "off", "monitor", "enforce":
+	default:
+		add("GUARDRAILS_MODE: must be off, monitor or enforce, got %q", c.GuardrailsMode)
+	}
+	if c.GuardrailsMaxSentences < 0 || c.GuardrailsMaxChars < 0 || c.GuardrailsLeakWords < 0 {
+		add("GUARDRAILS_MAX_SENTENCES / GUARDRAILS_MAX_CHARS / GUARDRAILS_LEAK_WORDS: must not be negative")
+	}
+	if c.HeartbeatInterval >= c.ConnectionTimeout {
+		add("HEARTBEAT_INTERVAL: must be shorter than CONNECTION_TIMEOUT")
+	}
+	nonNegative := map[string]float64{
+		"BUDGET_SESSION_TOKENS":        float64(c.BudgetSessionTokens),
+		"BUDGET_SESSION_COST_USD":      c.BudgetSessionCostUSD,
+		"BUDGET_IP_DAILY_TOKENS":       float64(c.BudgetIPDailyTokens),
+		"BUDGET_IP_DAILY_COST_USD":     c.BudgetIPDailyCostUSD,
+		"BUDGET_GLOBAL_DAILY_TOKENS":   float64(c.BudgetGlobalDailyTokens),
+		"BUDGET_GLOBAL_DAILY_COST_USD": c.BudgetGlobalDailyCostUSD,
+	}
+	for key, value := range nonNegative {
+		if value < 0 {
+			add("%s: must not be negative, got %g", key, value)
+		}
+	}
+
+	for prefix, u := range map[string]UpstreamConfig{"LLM": c.LLMUpstream, "TTS": c.TTSUpstream, "EMBEDDINGS": c.EmbeddingsUpstream, "MODERATION_API": c.ModerationAPIUpstream} {
+		if u.ConnectTimeout <= 0 || u.ResponseHeaderTimeout <= 0 {
+			add("%s_CONNECT_TIMEOUT / %s_RESPONSE_HEADER_TIMEOUT: must be positive durations", prefix, prefix)
+		}
+		if u.RequestTimeout < 0 || u.RetryBackoff < 0 || u.MaxRetries < 0 || u.MaxIdleConns < 0 {
+			add("%s upstream: timeouts, retries and pool size must not be negative", prefix)
+		}
+		if (u.CertFile == "") != (u.KeyFile == "") {
+			add("%s_CERT_FILE / %s_KEY_FILE: both are required for a client certificate", prefix, prefix)
+		}
+		if u.TLSMinVersion != "" && u.TLSMinVersion != "1.2" && u.TLSMinVersion != "1.3" {
+			add("%s_TLS_MIN_VERSION: must be 1.2 or 1.3, got %q", prefix, u.TLSMinVersion)
+		}
+		for key, path := range map[string]string{"_CA_FILE": u.CAFile, "_CERT_FILE": u.CertFile, "_KEY_FILE": u.KeyFile} {
+			if path == "" {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				add("%s%s: %v", prefix, key, err)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	// Map iteration order is random; sort for stable output
+	sort.Strings(problems)
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+func isModality(value string) bool {
+	return value == "audio" || value == "text"
+}
+
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+// loader resolves typed settings from environment variables and config file values,
+// collecting parse errors instead of failing on the first one
+type loader struct {
+	file     map[string]any
+	seen     map[string]bool
+	problems []string
+}
+
+// readFile parses a YAML or TOML config file (chosen by extension)
+func (l *loader) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("CONFIG_FILE: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &l.file)
+	case ".toml":
+		err = toml.Unmarshal(data, &l.file)
+	default:
+		return fmt.Errorf("CONFIG_FILE: unsupported format %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("CONFIG_FILE: failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// lookup returns the raw value for key from the environment, then the config file
+func (l *loader) lookup(key string) (any, bool) {
+	l.seen[strings.ToLower(key)] = true
+	if value := os.Getenv(key); value != "" {
+		return value, true
+	}
+	if value, ok := l.file[strings.ToLower(key)]; ok && value != nil {
+		return value, true
+	}
+	return nil, false
+}
+
+// unknownKeys reports config file keys that don't match any setting (likely typos)
+func (l *loader) unknownKeys() []string {
+	var problems []string
+	for key := range l.file {
+		if !l.seen[key] {
+			problems = append(problems, fmt.Sprintf("CONFIG_FILE: unknown key %q", key))
+		}
+	}
+	return problems
+}
+
+func (l *loader) invalid(key string, value any, kind string) {
+	l.problems = append(l.problems, fmt.Sprintf("%s: invalid %s %q", key, kind, fmt.Sprint(value)))
+}
+
+// upstream loads the <prefix>_* HTTP client settings, using cfg's breaker settings
+func (l *loader) upstream(prefix string, defaults UpstreamConfig, cfg *Config) UpstreamConfig {
+	return UpstreamConfig{
+		ConnectTimeout:        l.duration(prefix+"_CONNECT_TIMEOUT", defaults.ConnectTimeout),
+		ResponseHeaderTimeout: l.duration(prefix+"_RESPONSE_HEADER_TIMEOUT", defaults.ResponseHeaderTimeout),
+		RequestTimeout:        l.duration(prefix+"_REQUEST_TIMEOUT", defaults.RequestTimeout),
+		MaxRetries:            l.integer(prefix+"_MAX_RETRIES", defaults.MaxRetries),
+		RetryBackoff:          l.duration(prefix+"_RETRY_BACKOFF", defaults.RetryBackoff),
+		MaxIdleConns:          l.integer(prefix+"_MAX_IDLE_CONNS", defaults.MaxIdleConns),
+		CAFile:                l.str(prefix+"_CA_FILE", ""),
+		CertFile:              l.str(prefix+"_CERT_FILE", ""),
+		KeyFile:               l.str(prefix+"_KEY_FILE", ""),
+		ServerName:            l.str(prefix+"_SERVER_NAME", ""),
+		TLSMinVersion:         l.str(prefix+"_TLS_MIN_VERSION", ""),
+		AuthToken:             l.str(prefix+"_AUTH_TOKEN", ""),
+		BreakerThreshold:      cfg.BreakerFailureThreshold,
+		BreakerCooldown:       cfg.BreakerCooldown,
+	}
+}
+
+func (l *loader) str(key, defaultValue string) string {
+	value, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	return fmt.Sprint(value)
+}
+
+func (l *loader) boolean(key string, defaultValue bool) bool {
+	value, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(fmt.Sprint(value))
+	if err != nil {
+		l.invalid(key, value, "boolean")
+		return defaultValue
+	}
+	return parsed
+}
+
+func (l *loader) integer(key string, defaultValue int) int {
+	value, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil {
+		l.invalid(key, value, "integer")
+		return defaultValue
+	}
+	return parsed
+}
+
+func (l *loader) float(key string, defaultValue float64) float64 {
+	value, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+	if err != nil {
+		l.invalid(key, value, "number")
+		return defaultValue
+	}
+	return parsed
+}
+
+// optionalFloat returns nil when key isn't set, so "unset" and 0 can be told apart
+func (l *loader) optionalFloat(key string) *float64 {
+	if _, ok := l.lookup(key); !ok {
+		return nil
+	}
+	value := l.float(key, 0)
+	return &value
+}
+
+func (l *loader) duration(key string, defaultValue time.Duration) time.Duration {
+	value, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(fmt.Sprint(value))
+	if err != nil {
+		l.invalid(key, value, "duration (e.g. 30s, 10m)")
+		return defaultValue
+	}
+	return parsed
+}
+
+// list reads a comma-separated environment variable or a config file array
+func (l *loader) list(key string, defaultValue []string) []string {
+	value, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+
+	var items []string
+	switch v := value.(type) {
+	case string:
+		items = strings.Split(v, ",")
+	case []any:
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+	default:
+		l.invalid(key, value, "list")
+		return defaultValue
+	}
+
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// lines reads a newline-separated environment variable or a config file array, for
+// items that may contain commas (regular expressions, stop sequences). Items are
+// kept verbatim, only empty lines are dropped.
+func (l *loader) lines(key string, defaultValue []string) []string {
+	value, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+
+	var items []string
+	switch v := value.(type) {
+	case string:
+		items = strings.Split(strings.ReplaceAll(v, "\r\n", "\n"), "\n")
+	case []any:
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+	default:
+		l.invalid(key, value, "list")
+		return defaultValue
+	}
+
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
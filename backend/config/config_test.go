@@ -2,7 +2,11 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetEnv(t *testing.T) {
@@ -44,6 +48,206 @@ func TestGetEnv(t *testing.T) {
 	}
 }
 
+func TestLoaderTypedValues(t *testing.T) {
+	os.Setenv("TEST_INT", "42")
+	os.Setenv("TEST_FLOAT", "0.25")
+	os.Setenv("TEST_DURATION", "90s")
+	os.Setenv("TEST_LIST", "https://a.example, https://b.example,")
+	os.Setenv("TEST_LINES", "\\d{3,4}\n\nUser:, \r\n###")
+	os.Setenv("TEST_BAD_NUMBER", "not-a-number")
+	os.Setenv("TEST_ZERO", "0")
+	defer func() {
+		os.Unsetenv("TEST_ZERO")
+		os.Unsetenv("TEST_INT")
+		os.Unsetenv("TEST_FLOAT")
+		os.Unsetenv("TEST_DURATION")
+		os.Unsetenv("TEST_LIST")
+		os.Unsetenv("TEST_LINES")
+		os.Unsetenv("TEST_BAD_NUMBER")
+	}()
+
+	l := &loader{seen: make(map[string]bool)}
+
+	if got := l.integer("TEST_INT", 1); got != 42 {
+		t.Errorf("Expected 42, got %d", got)
+	}
+	if got := l.float("TEST_FLOAT", 1); got != 0.25 {
+		t.Errorf("Expected 0.25, got %f", got)
+	}
+	if got := l.float("UNSET_FLOAT", 1.5); got != 1.5 {
+		t.Errorf("Expected default 1.5, got %f", got)
+	}
+	if got := l.optionalFloat("UNSET_FLOAT"); got != nil {
+		t.Errorf("Expected nil for unset optional float, got %g", *got)
+	}
+	if got := l.optionalFloat("TEST_ZERO"); got == nil || *got != 0 {
+		t.Errorf("Expected explicit 0, got %v", got)
+	}
+	if got := l.duration("TEST_DURATION", time.Second); got != 90*time.Second {
+		t.Errorf("Expected 90s, got %s", got)
+	}
+	if got := l.list("TEST_LIST", nil); !reflect.DeepEqual(got, []string{"https://a.example", "https://b.example"}) {
+		t.Errorf("Unexpected list: %v", got)
+	}
+	if got := l.lines("TEST_LINES", nil); !reflect.DeepEqual(got, []string{`\d{3,4}`, "User:, ", "###"}) {
+		t.Errorf("Unexpected lines: %q", got)
+	}
+
+	if len(l.problems) != 0 {
+		t.Fatalf("Expected no problems, got %v", l.problems)
+	}
+	if got := l.integer("TEST_BAD_NUMBER", 7); got != 7 {
+		t.Errorf("Expected default 7 for invalid value, got %d", got)
+	}
+	if len(l.problems) != 1 {
+		t.Errorf("Expected invalid value to be reported, got %v", l.problems)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "YAML",
+			file: "config.yaml",
+			content: `max_message_length: 500
+connection_timeout: 5m
+tts_speed: 1.1
+cors_origins:
+  - https://preview.example.com
+`,
+		},
+		{
+			name: "TOML",
+			file: "config.toml",
+			content: `max_message_length = 500
+connection_timeout = "5m"
+tts_speed = 1.1
+cors_origins = ["https://preview.example.com"]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+			os.Setenv("CONFIG_FILE", path)
+			os.Setenv("MAX_MESSAGE_LENGTH", "800") // Environment overrides the file
+			defer os.Unsetenv("CONFIG_FILE")
+			defer os.Unsetenv("MAX_MESSAGE_LENGTH")
+
+			cfg := Load()
+
+			if cfg.MaxMessageLength != 800 {
+				t.Errorf("Expected env override 800, got %d", cfg.MaxMessageLength)
+			}
+			if cfg.ConnectionTimeout != 5*time.Minute {
+				t.Errorf("Expected ConnectionTimeout 5m, got %s", cfg.ConnectionTimeout)
+			}
+			if cfg.TTSSpeed != 1.1 {
+				t.Errorf("Expected TTSSpeed 1.1, got %g", cfg.TTSSpeed)
+			}
+			if !reflect.DeepEqual(cfg.CORSOrigins, []string{"https://preview.example.com"}) {
+				t.Errorf("Unexpected CORSOrigins: %v", cfg.CORSOrigins)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	os.Setenv("OPENAI_API_KEY", "test-api-key")
+	defer os.Unsetenv("OPENAI_API_KEY")
+
+	cfg := Load()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Default config should be valid, got: %v", err)
+	}
+
+	cfg.Backends = []string{"realtime", "local", "bogus"}
+	cfg.TTSSpeed = 10
+	cfg.MaxConnectionsPerIP = 0
+	cfg.CORSOrigins = []string{"not-a-url"}
+	cfg.TTSResponseFormat = "mp3"
+	cfg.RealtimeOutputModality = "video"
+	temperature := 3.0
+	cfg.LLMTemperature = &temperature
+	cfg.ResponseCacheTTL = time.Hour
+	cfg.ResponseCacheMaxBytes = 0
+	cfg.SpokenPhrases = []string{"farewell"}
+	cfg.SemanticCacheMode = "on"
+	cfg.SemanticCacheThreshold = 1.5
+	cfg.ModerationPatterns = []string{"(unclosed"}
+	cfg.ModerationLinkAction = "delete"
+	cfg.ModerationScripts = []string{"Klingon"}
+	cfg.GuardrailsMode = "strict"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+
+	// Every problem is reported, not just the first
+	for _, want := range []string{"BACKENDS", "LOCAL_LLM_URL", "TTS_URL", "TTS_SPEED", "MAX_CONNECTIONS_PER_IP", "CORS_ORIGINS", "TTS_RESPONSE_FORMAT", "LLM_TEMPERATURE", "REALTIME_OUTPUT_MODALITY", "RESPONSE_CACHE_MAX_BYTES", "SPOKEN_PHRASES", "EMBEDDINGS_URL", "SEMANTIC_CACHE_THRESHOLD", "MODERATION_PATTERNS", "MODERATION_LINK_ACTION", "MODERATION_SCRIPTS", "GUARDRAILS_MODE"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected validation error to mention %s, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadBackends(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"default realtime", nil, []string{"realtime"}},
+		{"local pipeline", map[string]string{"USE_LOCAL_PIPELINE": "true"}, []string{"local"}},
+		{"explicit order", map[string]string{"USE_LOCAL_PIPELINE": "true", "BACKENDS": "realtime,local"}, []string{"realtime", "local"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg := Load()
+			if !reflect.DeepEqual(cfg.Backends, tt.want) {
+				t.Errorf("Expected backends %v, got %v", tt.want, cfg.Backends)
+			}
+		})
+	}
+}
+
+func TestValidateReportsLoadProblems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("max_mesage_length: 10\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	os.Setenv("CONFIG_FILE", path)
+	os.Setenv("OPENAI_API_KEY", "test-api-key")
+	os.Setenv("CONNECTION_TIMEOUT", "ten minutes")
+	defer os.Unsetenv("CONFIG_FILE")
+	defer os.Unsetenv("OPENAI_API_KEY")
+	defer os.Unsetenv("CONNECTION_TIMEOUT")
+
+	err := Load().Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	if !strings.Contains(err.Error(), `unknown key "max_mesage_length"`) {
+		t.Errorf("Expected unknown key to be reported, got:\n%v", err)
+	}
+	if !strings.Contains(err.Error(), "CONNECTION_TIMEOUT") {
+		t.Errorf("Expected invalid duration to be reported, got:\n%v", err)
+	}
+}
+
 func TestLoadDefaultValues(t *testing.T) {
 	// Clear environment variables
 	os.Unsetenv("OPENAI_API_KEY")
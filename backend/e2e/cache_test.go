@@ -0,0 +1,231 @@
+package e2e
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/handlers"
+	"christianmoore.me/avatar-backend/testing/fakes"
+)
+
+// withResponseCache enables the response cache and lifts the message rate limit
+func withResponseCache(cfg *config.Config) {
+	cfg.ResponseCacheTTL = time.Hour
+	cfg.ResponseCacheMaxEntries = 10
+	cfg.ResponseCacheMaxBytes = 1 << 20
+	cfg.MessageBurst = 100
+}
+
+func TestResponseCache(t *testing.T) {
+	h := newHarness(t, withResponseCache)
+	h.llm.Enqueue(
+		fakes.LLMReply{Chunks: fakes.Words("Christian runs Kubernetes in production.")},
+		fakes.LLMReply{Chunks: fakes.Words("Mostly Go.")},
+		fakes.LLMReply{Chunks: fakes.Words("Regenerated after the purge.")},
+	)
+
+	first, err := h.connect("").ask("What's his experience with Kubernetes?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same question in another session is answered from the cache with the same events
+	c := h.connect("")
+	cached, err := c.ask("  what's his EXPERIENCE with kubernetes ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cached.Messages, first.Messages) {
+		t.Errorf("Expected the cached answer to match the original\n got: %+v\nwant: %+v", cached.Messages, first.Messages)
+	}
+	if llm, tts := len(h.llm.Requests()), len(h.tts.Requests()); llm != 1 || tts != 1 {
+		t.Errorf("Expected one LLM and one TTS request, got %d and %d", llm, tts)
+	}
+
+	// Later turns depend on the conversation, so they're neither served from nor stored in the cache
+	if r, err := c.ask("What's his experience with Kubernetes?"); err != nil || r.Text != "Mostly Go." {
+		t.Errorf("Expected a generated second turn, got %q %v", r.Text, err)
+	}
+
+	// An audio answer also serves text-only requests
+	r, err := h.connect("").askWith(handlers.ClientMessage{Type: "message", Message: "What's his experience with Kubernetes", Modality: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"text_delta", "text_done", "response_done"}; !reflect.DeepEqual(r.Events, want) || r.Text != first.Text {
+		t.Errorf("Expected the cached text as %v, got %q as %v", want, r.Text, r.Events)
+	}
+
+	if purged := h.chat.ResponseCache().Purge(); purged != 1 {
+		t.Errorf("Expected 1 cached answer to be purged, got %d", purged)
+	}
+	if r, err := h.connect("").ask("What's his experience with Kubernetes?"); err != nil || r.Text != "Regenerated after the purge." {
+		t.Errorf("Expected a generated answer after the purge, got %q %v", r.Text, err)
+	}
+}
+
+func TestResponseCacheRealtime(t *testing.T) {
+	h := newHarness(t, withResponseCache, func(cfg *config.Config) {
+		cfg.Backends = []string{handlers.BackendRealtime}
+		cfg.RealtimeOutputModality = "text"
+		cfg.SessionVoices = []string{"marin"}
+	})
+	h.realtime.Enqueue(fakes.RealtimeReply{Chunks: fakes.Words("Christian works on Kubernetes.")})
+
+	c := h.connect("")
+	first, err := c.ask("What does Christian do?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The answer is stored once the Realtime response is done
+	if msg, err := c.next(); err != nil || msg.Type != "response_done" {
+		t.Fatalf("Expected the final response_done, got %+v %v", msg, err)
+	}
+
+	cached, err := h.connect("").ask("What does Christian do?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Text != first.Text {
+		t.Errorf("Expected the cached text %q, got %q", first.Text, cached.Text)
+	}
+	if n := h.realtime.Connections(); n != 1 {
+		t.Errorf("Expected a cache hit not to connect to the Realtime API, got %d connections", n)
+	}
+
+	// A follow-up in the session served from the cache continues from the cached turn
+	c = h.connect("")
+	if _, err := c.ask("What does Christian do?"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ask("And before that?"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := h.realtime.Messages(), []string{"What does Christian do?", "What does Christian do?", "And before that?"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the cached question before the follow-up, got %q", got)
+	}
+	if got := h.realtime.AssistantMessages(); !reflect.DeepEqual(got, []string{first.Text}) {
+		t.Errorf("Expected the cached answer in the Realtime conversation, got %q", got)
+	}
+
+	// Sessions with another voice don't share answers
+	if r, err := h.connect("?voice=marin").ask("What does Christian do?"); err != nil || r.Text != fakes.DefaultReply {
+		t.Errorf("Expected a generated answer for another voice, got %q %v", r.Text, err)
+	}
+	if n := h.realtime.Connections(); n != 3 {
+		t.Errorf("Expected another voice to connect to the Realtime API, got %d connections", n)
+	}
+}
+
+func TestResponseCacheReplayPacing(t *testing.T) {
+	h := newHarness(t, withResponseCache, func(cfg *config.Config) {
+		cfg.Backends = []string{handlers.BackendRealtime}
+		cfg.RealtimeOutputModality = "audio"
+	})
+	h.realtime.Enqueue(fakes.RealtimeReply{
+		Chunks: fakes.Words("Christian runs Kubernetes and writes Go."),
+		Delay:  30 * time.Millisecond,
+	})
+
+	c := h.connect("")
+	first, err := c.ask("What does Christian do?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The answer is stored once the Realtime response is done, after the audio
+	for {
+		msg, err := c.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == "response_done" {
+			break
+		}
+	}
+	cached, err := h.connect("").ask("What does Christian do?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Text and audio are interleaved as they were live, not all text then all audio
+	textDone := slices.Index(first.Events, "text_done")
+	if want := first.Events[:textDone]; !reflect.DeepEqual(cached.Events[:min(textDone, len(cached.Events))], want) {
+		t.Errorf("Expected the cached deltas in the live order\n got: %v\nwant: %v", cached.Events, want)
+	}
+	if cached.Text != first.Text {
+		t.Errorf("Expected the cached text %q, got %q", first.Text, cached.Text)
+	}
+	// ...and paced like the original stream
+	if live, replay := first.Elapsed-first.First, cached.Elapsed-cached.First; replay < live/2 {
+		t.Errorf("Expected the replay to take about as long as the live answer (%v), took %v", live, replay)
+	}
+}
+
+// withSemanticCache enables the response cache and the semantic cache in mode
+func withSemanticCache(mode string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		withResponseCache(cfg)
+		cfg.SemanticCacheMode = mode
+		cfg.SemanticCacheThreshold = 0.8
+	}
+}
+
+func TestSemanticCache(t *testing.T) {
+	h := newHarness(t, withSemanticCache("on"))
+	h.llm.Enqueue(
+		fakes.LLMReply{Chunks: fakes.Words("Christian runs Kubernetes in production.")},
+		fakes.LLMReply{Chunks: fakes.Words("Mostly Go.")},
+	)
+
+	first, err := h.connect("").ask("What is his experience with Kubernetes in production?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A paraphrase shares most words, so the fake embeddings are similar enough
+	cached, err := h.connect("").ask("What's his experience with Kubernetes in production?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cached.Messages, first.Messages) {
+		t.Errorf("Expected the paraphrase to get the cached answer\n got: %+v\nwant: %+v", cached.Messages, first.Messages)
+	}
+	if n := len(h.llm.Requests()); n != 1 {
+		t.Errorf("Expected one LLM request, got %d", n)
+	}
+
+	// An unrelated question is generated
+	if r, err := h.connect("").ask("Which programming languages does he use?"); err != nil || r.Text != "Mostly Go." {
+		t.Errorf("Expected a generated answer to a different question, got %q %v", r.Text, err)
+	}
+	if n := len(h.embeddings.Inputs()); n != 3 {
+		t.Errorf("Expected every exact-cache miss to be embedded, got %d", n)
+	}
+
+	// Embedding failures don't stop questions being answered
+	h.embeddings.Status = http.StatusServiceUnavailable
+	if r, err := h.connect("").ask("Where did he study?"); err != nil || r.Text != fakes.DefaultReply {
+		t.Errorf("Expected a generated answer when embedding fails, got %q %v", r.Text, err)
+	}
+}
+
+func TestSemanticCacheShadow(t *testing.T) {
+	h := newHarness(t, withSemanticCache("shadow"))
+	h.llm.Enqueue(
+		fakes.LLMReply{Chunks: fakes.Words("Christian runs Kubernetes in production.")},
+		fakes.LLMReply{Chunks: fakes.Words("Generated in shadow mode.")},
+	)
+
+	if _, err := h.connect("").ask("What is his experience with Kubernetes in production?"); err != nil {
+		t.Fatal(err)
+	}
+	// Would-be hits are only logged
+	r, err := h.connect("").ask("What's his experience with Kubernetes in production?")
+	if err != nil || r.Text != "Generated in shadow mode." {
+		t.Errorf("Expected shadow mode to generate the answer, got %q %v", r.Text, err)
+	}
+}
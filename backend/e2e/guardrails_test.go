@@ -0,0 +1,92 @@
+package e2e
+
+import (
+	"expvar"
+	"testing"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/handlers"
+	"christianmoore.me/avatar-backend/metrics"
+	"christianmoore.me/avatar-backend/testing/fakes"
+)
+
+// withGuardrails enables guardrails in mode, allowing answers of up to two sentences
+func withGuardrails(mode string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.GuardrailsMode = mode
+		cfg.GuardrailsMaxSentences = 2
+		cfg.MessageBurst = 100
+	}
+}
+
+// violations returns how many violations of check have been counted
+func violations(check string) int64 {
+	if v, ok := metrics.Guardrails.Get(check).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestGuardrailsEnforce(t *testing.T) {
+	h := newHarness(t, withGuardrails("enforce"))
+	h.llm.Enqueue(
+		fakes.LLMReply{Chunks: fakes.Words("He runs Kubernetes. Email chris@gmail.com for details. He also writes Go. He has cats.")},
+		fakes.LLMReply{Chunks: fakes.Words("He runs Kubernetes.")},
+	)
+	c := h.connect("")
+
+	// The answer is fixed before it's sent or spoken
+	r, err := c.ask("What does he do?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "He runs Kubernetes. He also writes Go."
+	if r.Text != want {
+		t.Errorf("Expected the fixed answer %q, got %q", want, r.Text)
+	}
+	if requests := h.tts.Requests(); len(requests) != 1 || requests[0].Input != want {
+		t.Errorf("Expected the fixed answer to be spoken, got %+v", requests)
+	}
+
+	// Answers without violations keep their streamed chunks
+	r, err = c.ask("And?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deltas := 0
+	for _, msg := range r.Messages {
+		if msg.Type == "text_delta" {
+			deltas++
+		}
+	}
+	if r.Text != "He runs Kubernetes." || deltas != 3 {
+		t.Errorf("Expected the original 3 chunks, got %q in %d", r.Text, deltas)
+	}
+}
+
+func TestGuardrailsMonitor(t *testing.T) {
+	for _, backend := range []string{handlers.BackendLocal, handlers.BackendRealtime} {
+		t.Run(backend, func(t *testing.T) {
+			h := newHarness(t, withGuardrails("monitor"), func(cfg *config.Config) {
+				cfg.Backends = []string{backend}
+				cfg.RealtimeOutputModality = "text"
+			})
+			answer := "Email chris@gmail.com for details."
+			h.llm.Enqueue(fakes.LLMReply{Chunks: fakes.Words(answer)})
+			h.realtime.Enqueue(fakes.RealtimeReply{Chunks: fakes.Words(answer)})
+
+			before := violations("contact")
+			r, err := h.connect("").askWith(handlers.ClientMessage{Type: "message", Message: "How do I reach him?", Modality: "text"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Monitoring only logs and counts violations
+			if r.Text != answer {
+				t.Errorf("Expected the answer unchanged, got %q", r.Text)
+			}
+			if n := violations("contact") - before; n != 1 {
+				t.Errorf("Expected 1 contact violation to be counted, got %d", n)
+			}
+		})
+	}
+}
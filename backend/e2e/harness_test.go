@@ -0,0 +1,360 @@
+// Package e2e drives real WebSocket clients through the full router against fake
+// upstreams, asserting on the events, timing and close codes clients see
+package e2e
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/handlers"
+	"christianmoore.me/avatar-backend/recording"
+	"christianmoore.me/avatar-backend/testing/backendtest"
+	"christianmoore.me/avatar-backend/testing/fakes"
+	"github.com/gorilla/websocket"
+)
+
+// How long a client waits for the next event before failing
+const readTimeout = 5 * time.Second
+
+// harness serves the production router with fake LLM, TTS, Realtime and embeddings upstreams
+type harness struct {
+	t      *testing.T
+	cfg    *config.Config
+	chat   *handlers.ChatHandler
+	server *httptest.Server
+
+	llm        *fakes.LLM
+	tts        *fakes.TTS
+	realtime   *fakes.Realtime
+	embeddings *fakes.Embeddings
+
+	jwt string // Cached token from /api/token
+}
+
+// newHarness starts a server answering through the local pipeline by default;
+// configure adjusts the config before the handlers are built
+func newHarness(t *testing.T, configure ...func(*config.Config)) *harness {
+	t.Helper()
+	b := backendtest.New(t, func(b *backendtest.Backend) {
+		for _, fn := range configure {
+			fn(b.Config)
+		}
+	})
+	return &harness{
+		t:          t,
+		cfg:        b.Config,
+		chat:       b.Chat,
+		server:     b.Server,
+		llm:        b.LLM,
+		tts:        b.TTS,
+		realtime:   b.Realtime,
+		embeddings: b.Embeddings,
+	}
+}
+
+// newToken requests a fresh JWT from /api/token (each is its own rate limit session)
+func (h *harness) newToken() string {
+	h.t.Helper()
+	resp, err := http.Post(h.server.URL+"/api/token", "application/json", nil)
+	if err != nil {
+		h.t.Fatalf("Token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		h.t.Fatalf("Token request returned %s", resp.Status)
+	}
+	var body struct {
+		JWT string `json:"jwt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		h.t.Fatal(err)
+	}
+	return body.JWT
+}
+
+// token returns a JWT shared by every call on this harness
+func (h *harness) token() string {
+	h.t.Helper()
+	if h.jwt == "" {
+		h.jwt = h.newToken()
+	}
+	return h.jwt
+}
+
+// bearer returns headers authenticating with the token in the Authorization header
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// dial opens /ws/chat without reading anything; resp is set when the handshake got
+// an HTTP response, including rejections
+func (h *harness) dial(query string, header http.Header, subprotocols ...string) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: readTimeout, Subprotocols: subprotocols}
+	url := "ws" + strings.TrimPrefix(h.server.URL, "http") + "/ws/chat" + query
+	return dialer.Dial(url, header)
+}
+
+// open dials and reads the session_created greeting. It doesn't touch t, so it's
+// safe to call from other goroutines.
+func (h *harness) open(query string, header http.Header, subprotocols ...string) (*client, error) {
+	conn, resp, err := h.dial(query, header, subprotocols...)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("dial: %w (%s)", err, resp.Status)
+		}
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	c := &client{conn: conn, resp: resp}
+
+	msg, err := c.next()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if msg.Type != "session_created" || msg.Session == nil {
+		conn.Close()
+		return nil, fmt.Errorf("expected session_created, got %+v", msg)
+	}
+	c.session = *msg.Session
+	return c, nil
+}
+
+// connect opens a session authenticated with the shared token, closed when the test ends
+func (h *harness) connect(query string) *client {
+	h.t.Helper()
+	return h.connectWith(query, bearer(h.token()))
+}
+
+// connectWith opens a session with the given headers and subprotocols
+func (h *harness) connectWith(query string, header http.Header, subprotocols ...string) *client {
+	h.t.Helper()
+	c, err := h.open(query, header, subprotocols...)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.t.Cleanup(func() { c.conn.Close() })
+	return c
+}
+
+// expectRejected asserts that the handshake fails with the given HTTP status and error
+func (h *harness) expectRejected(query string, header http.Header, status int, message string) {
+	h.t.Helper()
+	conn, resp, err := h.dial(query, header)
+	if err == nil {
+		conn.Close()
+		h.t.Fatalf("Expected the handshake to be rejected with %d", status)
+	}
+	if resp == nil {
+		h.t.Fatalf("Expected an HTTP %d response, got %v", status, err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != status || body.Error != message {
+		h.t.Errorf("Expected %d %q, got %d %q", status, message, resp.StatusCode, body.Error)
+	}
+}
+
+// client is one WebSocket session. It answers heartbeats like the frontend does.
+// A client must only be used from one goroutine.
+type client struct {
+	conn       *websocket.Conn
+	resp       *http.Response
+	session    handlers.SessionOptions
+	heartbeats int // Heartbeats received and acknowledged
+}
+
+// send writes a client message
+func (c *client) send(msg handlers.ClientMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(readTimeout))
+	return c.conn.WriteJSON(msg)
+}
+
+// read returns the next event, including heartbeats
+func (c *client) read() (handlers.ServerMessage, error) {
+	var msg handlers.ServerMessage
+	c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	err := c.conn.ReadJSON(&msg)
+	return msg, err
+}
+
+// next returns the next event other than a heartbeat, acknowledging heartbeats
+func (c *client) next() (handlers.ServerMessage, error) {
+	for {
+		msg, err := c.read()
+		if err != nil || msg.Type != "heartbeat" {
+			return msg, err
+		}
+		c.heartbeats++
+		if err := c.send(handlers.ClientMessage{Type: "heartbeat_ack"}); err != nil {
+			return msg, err
+		}
+	}
+}
+
+// response is everything a client saw for one message
+type response struct {
+	Messages []handlers.ServerMessage
+	Events   []string      // Event types with runs of the same type collapsed
+	Text     string        // Concatenated text deltas
+	First    time.Duration // Time to the first event
+	Elapsed  time.Duration // Time to response_done
+}
+
+// ask sends a chat message and reads events through the first response_done
+func (c *client) ask(message string) (response, error) {
+	return c.askWith(handlers.ClientMessage{Type: "message", Message: message})
+}
+
+// askWith sends msg and reads events through the first response_done
+func (c *client) askWith(msg handlers.ClientMessage) (response, error) {
+	var r response
+	start := time.Now()
+	if err := c.send(msg); err != nil {
+		return r, err
+	}
+	for {
+		event, err := c.next()
+		if err != nil {
+			return r, fmt.Errorf("read after %v: %w", r.Events, err)
+		}
+		if len(r.Messages) == 0 {
+			r.First = time.Since(start)
+		}
+		r.Messages = append(r.Messages, event)
+		if len(r.Events) == 0 || r.Events[len(r.Events)-1] != event.Type {
+			r.Events = append(r.Events, event.Type)
+		}
+		if event.Type == "text_delta" {
+			r.Text += event.Text
+		}
+		if event.Type == "error" {
+			return r, fmt.Errorf("server error: %s", event.Error)
+		}
+		if event.Type == "response_done" {
+			r.Elapsed = time.Since(start)
+			return r, nil
+		}
+	}
+}
+
+// expectError sends msg and asserts the only reply is an error event with the given text
+func (c *client) expectError(t *testing.T, msg handlers.ClientMessage, want string) {
+	t.Helper()
+	if err := c.send(msg); err != nil {
+		t.Fatal(err)
+	}
+	event, err := c.next()
+	if err != nil {
+		t.Fatalf("Expected error %q, got %v", want, err)
+	}
+	if event.Type != "error" || event.Error != want {
+		t.Errorf("Expected error %q, got %+v", want, event)
+	}
+}
+
+// untilClosed reads events until the server closes the connection, returning the
+// event types seen and the close code (websocket.CloseAbnormalClosure when the
+// socket was dropped without a close frame)
+func (c *client) untilClosed() (events []string, code int, err error) {
+	for {
+		msg, err := c.next()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return events, closeErr.Code, nil
+			}
+			return events, 0, err
+		}
+		if len(events) == 0 || events[len(events)-1] != msg.Type {
+			events = append(events, msg.Type)
+		}
+	}
+}
+
+// replay connects with a recording's session options, sends each recorded client
+// message and returns the messages the server sent back, split into turns like
+// recording.Turns. Each turn reads as many messages as were recorded for it, or with
+// untilQuiet (to update a recording) everything until the server goes quiet.
+func (h *harness) replay(rec *recording.Recording, untilQuiet time.Duration) [][]json.RawMessage {
+	h.t.Helper()
+	query := ""
+	if rec.Session.Query != "" {
+		query = "?" + rec.Session.Query
+	}
+	conn, _, err := h.dial(query, bearer(h.token()))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &client{conn: conn}
+
+	var server [][]json.RawMessage
+	for i, turn := range rec.Turns() {
+		if turn.Client != nil {
+			conn.SetWriteDeadline(time.Now().Add(readTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, turn.Client); err != nil {
+				h.t.Fatalf("Turn %d: %v", i, err)
+			}
+		}
+		var messages []json.RawMessage
+		for untilQuiet > 0 || len(messages) < len(turn.Server) {
+			var msg handlers.ServerMessage
+			if untilQuiet > 0 {
+				msg, err = c.nextWithin(untilQuiet)
+				if isTimeout(err) {
+					break
+				}
+			} else {
+				msg, err = c.next()
+			}
+			if err != nil {
+				h.t.Fatalf("Turn %d: expected %d messages, got %d: %v", i, len(turn.Server), len(messages), err)
+			}
+			data, _ := json.Marshal(msg)
+			messages = append(messages, data)
+		}
+		server = append(server, messages)
+	}
+
+	// Nothing may follow the last recorded message
+	if untilQuiet == 0 {
+		if msg, err := c.nextWithin(quietPeriod); !isTimeout(err) {
+			h.t.Errorf("Expected no more messages after the recording ends, got %+v %v", msg, err)
+		}
+	}
+	return server
+}
+
+// How long a client waits to be sure no further events are coming
+const quietPeriod = 500 * time.Millisecond
+
+// nextWithin is next with a shorter read timeout
+func (c *client) nextWithin(timeout time.Duration) (handlers.ServerMessage, error) {
+	for {
+		var msg handlers.ServerMessage
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		if err := c.conn.ReadJSON(&msg); err != nil || msg.Type != "heartbeat" {
+			return msg, err
+		}
+		if err := c.send(handlers.ClientMessage{Type: "heartbeat_ack"}); err != nil {
+			return msg, err
+		}
+	}
+}
+
+// isTimeout reports whether err is a read deadline expiring
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
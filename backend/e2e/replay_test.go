@@ -0,0 +1,66 @@
+package e2e
+
+import (
+	"encoding/json"
+	"flag"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/handlers"
+	"christianmoore.me/avatar-backend/recording"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/recordings with the messages the server sends now")
+
+// TestReplayRecordings replays every session in testdata/recordings through the chat
+// handler, serving the Realtime API from the recording, and expects the client to get
+// the recorded messages. Recordings come from RECORD_DIR; after an intended behaviour
+// change, run with -update and review the diff.
+func TestReplayRecordings(t *testing.T) {
+	paths, err := filepath.Glob("testdata/recordings/*.jsonl")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No recordings found: %v", err)
+	}
+
+	for _, path := range paths {
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".jsonl"), func(t *testing.T) {
+			rec, err := recording.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := newHarness(t, func(cfg *config.Config) {
+				cfg.Backends = rec.Session.Backends
+				cfg.MessageBurst = 100
+				cfg.RealtimeResponseTimeout = 200 * time.Millisecond
+			})
+			h.chat.SetRealtimeDialer(rec.Dialer())
+
+			if *update {
+				updated, err := rec.WithServer(h.replay(rec, time.Second))
+				if err == nil {
+					err = updated.Save(path)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			got := h.replay(rec, 0)
+			for i, turn := range rec.Turns() {
+				for j, data := range turn.Server {
+					var want, have handlers.ServerMessage
+					json.Unmarshal(data, &want)
+					json.Unmarshal(got[i][j], &have)
+					if !reflect.DeepEqual(want, have) {
+						t.Errorf("Turn %d message %d: expected %s, got %s", i, j, data, got[i][j])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/handlers"
+	"christianmoore.me/avatar-backend/testing/fakes"
+	"github.com/gorilla/websocket"
+)
+
+// Events a client sees for one spoken answer from the local pipeline
+var localAudioEvents = []string{"text_delta", "text_done", "audio_delta", "audio_done", "response_done"}
+
+func TestJWTSources(t *testing.T) {
+	h := newHarness(t)
+	token := h.token()
+
+	t.Run("authorization header", func(t *testing.T) {
+		c := h.connectWith("", bearer(token))
+		if c.session.Voice != "cedar" {
+			t.Errorf("Expected the persona's voice, got %+v", c.session)
+		}
+	})
+
+	t.Run("subprotocol", func(t *testing.T) {
+		// Browsers can't set headers, so the token travels as the subprotocol and must be echoed
+		c := h.connectWith("", nil, token)
+		if got := c.conn.Subprotocol(); got != token {
+			t.Errorf("Expected the subprotocol to be echoed, got %q", got)
+		}
+	})
+
+	t.Run("query parameter", func(t *testing.T) {
+		h.connectWith("?token="+token, nil)
+	})
+
+	t.Run("header takes precedence", func(t *testing.T) {
+		h.connectWith("?token=invalid", bearer(token))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		h.expectRejected("", nil, http.StatusUnauthorized, "Authentication required")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		h.expectRejected("?token=invalid", nil, http.StatusUnauthorized, "Authentication required")
+		h.expectRejected("", bearer(token+"x"), http.StatusUnauthorized, "Authentication required")
+	})
+
+	t.Run("signed with another secret", func(t *testing.T) {
+		other := newHarness(t, func(cfg *config.Config) { cfg.JWTSecret = "another-secret" })
+		h.expectRejected("", bearer(other.token()), http.StatusUnauthorized, "Authentication required")
+	})
+}
+
+func TestLocalConversation(t *testing.T) {
+	h := newHarness(t)
+	h.llm.Enqueue(
+		fakes.LLMReply{Chunks: fakes.Words("Christian builds platforms.")},
+		fakes.LLMReply{Chunks: fakes.Words("Mostly Go and Kubernetes.")},
+	)
+	c := h.connect("")
+
+	for _, want := range []string{"Christian builds platforms.", "Mostly Go and Kubernetes."} {
+		r, err := c.ask("Tell me more")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r.Events, localAudioEvents) {
+			t.Errorf("Unexpected event sequence %v", r.Events)
+		}
+		if r.Text != want {
+			t.Errorf("Expected %q, got %q", want, r.Text)
+		}
+	}
+
+	// Text-only messages skip speech entirely
+	r, err := c.askWith(handlers.ClientMessage{Type: "message", Message: "Quietly", Modality: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"text_delta", "text_done", "response_done"}; !reflect.DeepEqual(r.Events, want) {
+		t.Errorf("Expected %v for a text-only message, got %v", want, r.Events)
+	}
+}
+
+func TestStreamingTiming(t *testing.T) {
+	const chunkDelay = 50 * time.Millisecond
+	h := newHarness(t)
+	h.llm.Enqueue(fakes.LLMReply{Chunks: fakes.Words("one two three four"), Delay: chunkDelay})
+	c := h.connect("")
+
+	r, err := c.askWith(handlers.ClientMessage{Type: "message", Message: "Count", Modality: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Text is streamed as it's generated rather than after the whole reply
+	if r.Elapsed < 4*chunkDelay {
+		t.Errorf("Expected the reply to take at least %v, took %v", 4*chunkDelay, r.Elapsed)
+	}
+	if r.First > r.Elapsed-2*chunkDelay {
+		t.Errorf("Expected the first delta well before the end, got it after %v of %v", r.First, r.Elapsed)
+	}
+	if deltas := len(r.Messages) - 2; deltas != 4 {
+		t.Errorf("Expected one text_delta per chunk, got %d", deltas)
+	}
+}
+
+func TestMessageValidation(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) {
+		cfg.MaxMessageLength = 20
+		cfg.MessageBurst = 20
+	})
+	c := h.connect("")
+
+	lengthError := "Message must be between 1 and 20 characters"
+	tests := []struct {
+		name string
+		msg  handlers.ClientMessage
+		want string
+	}{
+		{"empty", handlers.ClientMessage{Type: "message"}, lengthError},
+		{"too long", handlers.ClientMessage{Type: "message", Message: strings.Repeat("a", 21)}, lengthError},
+		{"control characters only", handlers.ClientMessage{Type: "message", Message: " \x00\x07\x1b "}, "Message cannot be empty"},
+		{"unknown type", handlers.ClientMessage{Type: "subscribe", Message: "Hi"}, "Invalid message type"},
+		{"unavailable modality", handlers.ClientMessage{Type: "message", Message: "Hi", Modality: "video"}, `Modality "video" is not available`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.expectError(t, tt.msg, tt.want)
+		})
+	}
+	if reqs := h.llm.Requests(); len(reqs) != 0 {
+		t.Fatalf("Expected rejected messages not to reach the LLM, got %d requests", len(reqs))
+	}
+
+	// Control characters are stripped before the message reaches the model
+	if _, err := c.ask(" Hel\x01lo\x1b\tthere\n "); err != nil {
+		t.Fatal(err)
+	}
+	reqs := h.llm.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("Expected one LLM request, got %d", len(reqs))
+	}
+	messages := reqs[0].Messages
+	if got := messages[len(messages)-1].Content; got != "Hello\tthere" {
+		t.Errorf("Expected the sanitized message, got %q", got)
+	}
+}
+
+func TestMessageRateLimit(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) {
+		cfg.MessageBurst = 2
+		cfg.MessageRateInterval = time.Hour
+	})
+	limited := handlers.ClientMessage{Type: "message", Message: "One more"}
+	rateError := "Rate limit exceeded. Please wait before sending another message."
+
+	c := h.connect("")
+	for i := 0; i < 2; i++ {
+		if _, err := c.ask("Hi"); err != nil {
+			t.Fatalf("Message %d within the burst failed: %v", i+1, err)
+		}
+	}
+	c.expectError(t, limited, rateError)
+	// Heartbeat acks and other traffic keep working while limited
+	c.expectError(t, handlers.ClientMessage{Type: "subscribe"}, "Invalid message type")
+
+	// The limit follows the token, so reconnecting doesn't reset it
+	c.conn.Close()
+	c = h.connect("")
+	c.expectError(t, limited, rateError)
+
+	// A new token is a new session
+	c = h.connectWith("", bearer(h.newToken()))
+	if _, err := c.ask("Hi"); err != nil {
+		t.Errorf("Expected a new session to get its own burst: %v", err)
+	}
+	if reqs := h.llm.Requests(); len(reqs) != 3 {
+		t.Errorf("Expected 3 LLM requests, got %d", len(reqs))
+	}
+}
+
+func TestConnectionLimitPerIP(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) { cfg.MaxConnectionsPerIP = 2 })
+
+	first := h.connect("")
+	h.connect("")
+	h.expectRejected("", bearer(h.token()), http.StatusTooManyRequests, "Too many concurrent connections from your IP address")
+
+	// Closing a session frees its slot once the server notices
+	first.conn.Close()
+	deadline := time.Now().Add(readTimeout)
+	for {
+		c, err := h.open("", bearer(h.token()))
+		if err == nil {
+			c.conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Slot was never released: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHeartbeats(t *testing.T) {
+	const (
+		interval = 50 * time.Millisecond
+		timeout  = 300 * time.Millisecond
+	)
+	h := newHarness(t, func(cfg *config.Config) {
+		cfg.HeartbeatInterval = interval
+		cfg.ConnectionTimeout = timeout
+	})
+
+	t.Run("acknowledged", func(t *testing.T) {
+		c := h.connect("")
+		// Stay connected well past the timeout by answering heartbeats while idle
+		for start := time.Now(); time.Since(start) < 3*timeout; {
+			msg, err := c.read()
+			if err != nil {
+				t.Fatalf("Connection dropped after %v: %v", time.Since(start), err)
+			}
+			if msg.Type != "heartbeat" {
+				t.Fatalf("Unexpected event %+v", msg)
+			}
+			if err := c.send(handlers.ClientMessage{Type: "heartbeat_ack"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := c.askWith(handlers.ClientMessage{Type: "message", Message: "Still there?", Modality: "text"}); err != nil {
+			t.Errorf("Expected the session to still answer: %v", err)
+		}
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		c := h.connect("")
+		start := time.Now()
+		// Read without acknowledging; the server drops the socket at the read deadline
+		var heartbeats int
+		for {
+			msg, err := c.read()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+					t.Errorf("Expected the socket to be dropped, got %v", err)
+				}
+				break
+			}
+			if msg.Type != "heartbeat" {
+				t.Fatalf("Unexpected event %+v", msg)
+			}
+			heartbeats++
+		}
+		if elapsed := time.Since(start); elapsed < timeout-interval || elapsed > timeout+time.Second {
+			t.Errorf("Expected the connection to close after about %v, took %v", timeout, elapsed)
+		}
+		if heartbeats < 2 {
+			t.Errorf("Expected heartbeats every %v before closing, got %d", interval, heartbeats)
+		}
+	})
+
+	t.Run("during a response", func(t *testing.T) {
+		h.llm.Enqueue(fakes.LLMReply{Chunks: fakes.Words("slow words arrive one by one"), Delay: interval})
+		c := h.connect("")
+		r, err := c.ask("Take your time")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Heartbeats interleave with deltas without corrupting the stream
+		if !reflect.DeepEqual(r.Events, localAudioEvents) || r.Text != "slow words arrive one by one" {
+			t.Errorf("Unexpected response %v %q", r.Events, r.Text)
+		}
+		if c.heartbeats == 0 {
+			t.Error("Expected heartbeats while the response streamed")
+		}
+	})
+}
+
+func TestRealtimeConversation(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) {
+		cfg.Backends = []string{handlers.BackendRealtime}
+		cfg.RealtimeOutputModality = "text"
+	})
+	h.realtime.Enqueue(fakes.RealtimeReply{Chunks: fakes.Words("Christian works on Kubernetes.")})
+	c := h.connect("")
+
+	r, err := c.ask("What does\x00 Christian do?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"text_delta", "text_done", "response_done"}; !reflect.DeepEqual(r.Events, want) {
+		t.Errorf("Expected %v, got %v", want, r.Events)
+	}
+	if r.Text != "Christian works on Kubernetes." {
+		t.Errorf("Unexpected text %q", r.Text)
+	}
+	// text_done is followed by an early response_done, then the one for response.done
+	if msg, err := c.next(); err != nil || msg.Type != "response_done" {
+		t.Errorf("Expected a second response_done, got %+v %v", msg, err)
+	}
+	if got := h.realtime.Messages(); !reflect.DeepEqual(got, []string{"What does Christian do?"}) {
+		t.Errorf("Expected the sanitized message upstream, got %q", got)
+	}
+}
+
+func TestFailoverToLocal(t *testing.T) {
+	const responseTimeout = 200 * time.Millisecond
+	h := newHarness(t, func(cfg *config.Config) {
+		cfg.Backends = []string{handlers.BackendRealtime, handlers.BackendLocal}
+		cfg.RealtimeResponseTimeout = responseTimeout
+	})
+	h.realtime.Enqueue(fakes.RealtimeReply{Silent: true})
+	h.llm.Enqueue(fakes.LLMReply{Chunks: fakes.Words("Answered locally.")})
+	c := h.connect("")
+
+	r, err := c.ask("Hello?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]string{"backend_switched"}, localAudioEvents...)
+	if !reflect.DeepEqual(r.Events, want) {
+		t.Errorf("Expected %v, got %v", want, r.Events)
+	}
+	if r.Messages[0].Backend != handlers.BackendLocal {
+		t.Errorf("Expected a switch to the local backend, got %+v", r.Messages[0])
+	}
+	if r.First < responseTimeout {
+		t.Errorf("Expected the switch after the %v Realtime timeout, got it after %v", responseTimeout, r.First)
+	}
+	if r.Text != "Answered locally." {
+		t.Errorf("Unexpected text %q", r.Text)
+	}
+}
+
+func TestDrainClosesWithServiceRestart(t *testing.T) {
+	h := newHarness(t)
+	h.llm.Enqueue(fakes.LLMReply{Chunks: fakes.Words("an answer that takes a while"), Delay: 50 * time.Millisecond})
+
+	// One client mid-response and several idle ones
+	busy := h.connect("")
+	if err := busy.send(handlers.ClientMessage{Type: "message", Message: "Hi", Modality: "text"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := busy.next(); err != nil || msg.Type != "text_delta" {
+		t.Fatalf("Expected the response to start, got %+v %v", msg, err)
+	}
+	idle := make([]*client, 3)
+	for i := range idle {
+		idle[i] = h.connect("")
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		drained <- h.chat.Drain(ctx)
+	}()
+
+	// Idle clients are told to reconnect and closed with 1012 (service restart)
+	var wg sync.WaitGroup
+	for i, c := range idle {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, code, err := c.untilClosed()
+			if err != nil || code != websocket.CloseServiceRestart || !reflect.DeepEqual(events, []string{"server_draining"}) {
+				t.Errorf("Idle client %d: expected server_draining then 1012, got %v %d %v", i, events, code, err)
+			}
+		}()
+	}
+
+	// The in-flight response finishes before the busy client is closed
+	events, code, err := busy.untilClosed()
+	if err != nil || code != websocket.CloseServiceRestart {
+		t.Errorf("Expected close code 1012, got %d %v", code, err)
+	}
+	if !slicesContainInOrder(events, "server_draining", "response_done") {
+		t.Errorf("Expected the response to complete after server_draining, got %v", events)
+	}
+	wg.Wait()
+
+	if err := <-drained; err != nil {
+		t.Errorf("Expected drain to complete, got %v", err)
+	}
+	h.expectRejected("", bearer(h.token()), http.StatusServiceUnavailable, "Server is shutting down")
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	const (
+		clients  = 10
+		messages = 3
+	)
+	h := newHarness(t, func(cfg *config.Config) {
+		cfg.MaxConnectionsPerIP = clients
+		cfg.MessageBurst = clients * messages
+	})
+	header := bearer(h.token())
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := h.open("", header)
+			if err != nil {
+				t.Errorf("Client %d: %v", i, err)
+				return
+			}
+			defer c.conn.Close()
+			for j := 0; j < messages; j++ {
+				r, err := c.ask("Hi")
+				if err != nil {
+					t.Errorf("Client %d message %d: %v", i, j, err)
+					return
+				}
+				if !reflect.DeepEqual(r.Events, localAudioEvents) || r.Text != fakes.DefaultReply {
+					t.Errorf("Client %d message %d: unexpected response %v %q", i, j, r.Events, r.Text)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reqs := h.llm.Requests(); len(reqs) != clients*messages {
+		t.Errorf("Expected %d LLM requests, got %d", clients*messages, len(reqs))
+	}
+}
+
+func TestConcurrentDialsRespectConnectionLimit(t *testing.T) {
+	const (
+		limit = 3
+		dials = 12
+	)
+	h := newHarness(t, func(cfg *config.Config) { cfg.MaxConnectionsPerIP = limit })
+	header := bearer(h.token())
+
+	var (
+		mu       sync.Mutex
+		accepted []*client
+		rejected int
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < dials; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, resp, err := h.dial("", header)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				accepted = append(accepted, &client{conn: conn, resp: resp})
+			case resp != nil && resp.StatusCode == http.StatusTooManyRequests:
+				rejected++
+			default:
+				t.Errorf("Dial %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, c := range accepted {
+		c.conn.Close()
+	}
+
+	if len(accepted) != limit || rejected != dials-limit {
+		t.Errorf("Expected %d accepted and %d rejected, got %d and %d", limit, dials-limit, len(accepted), rejected)
+	}
+}
+
+// slicesContainInOrder reports whether items appear in s in the given order
+func slicesContainInOrder(s []string, items ...string) bool {
+	for _, v := range s {
+		if len(items) > 0 && v == items[0] {
+			items = items[1:]
+		}
+	}
+	return len(items) == 0
+}
@@ -0,0 +1,76 @@
+// Package embeddings turns text into vectors with an OpenAI-compatible
+// /v1/embeddings endpoint, such as a local embedding model server
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/upstream"
+)
+
+// Request is an embeddings request for one input
+type Request struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+// Response carries one embedding per input
+type Response struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Client embeds text with one model. It is safe for concurrent use.
+type Client struct {
+	url   string
+	model string
+	http  *upstream.Client
+}
+
+// New creates a client for the embeddings server at url
+func New(url, model string, cfg config.UpstreamConfig) (*Client, error) {
+	client, err := upstream.New("embeddings", cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{url: url, model: model, http: client}, nil
+}
+
+// Embed returns the embedding of text
+func (c *Client) Embed(ctx context.Context, text string) ([]float32, error) {
+	body, err := json.Marshal(Request{Model: c.model, Input: text})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url+"/v1/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req, true) // Embedding is idempotent, so safe to retry
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("embeddings API returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	var result Response
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse embeddings response: %w", err)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embeddings response has no embedding")
+	}
+	return result.Data[0].Embedding, nil
+}
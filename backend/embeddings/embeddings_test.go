@@ -0,0 +1,42 @@
+package embeddings
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/testing/fakes"
+)
+
+func TestEmbed(t *testing.T) {
+	fake := fakes.NewEmbeddings()
+	server := fakes.Serve(t, fake)
+	client, err := New(server.URL, "embed", config.UpstreamConfig{
+		ConnectTimeout:        time.Second,
+		ResponseHeaderTimeout: time.Second,
+		BreakerThreshold:      3,
+		BreakerCooldown:       time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.Embed(context.Background(), "Kubernetes experience?")
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if !slices.Equal(got, fakes.Embed("Kubernetes experience?")) {
+		t.Errorf("Unexpected embedding %v", got)
+	}
+	if inputs := fake.Inputs(); len(inputs) != 1 || inputs[0] != "Kubernetes experience?" {
+		t.Errorf("Unexpected inputs %q", inputs)
+	}
+
+	fake.Status = http.StatusServiceUnavailable
+	if _, err := client.Embed(context.Background(), "hi"); err == nil {
+		t.Error("Expected an error for a failed request")
+	}
+}
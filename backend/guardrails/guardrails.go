@@ -0,0 +1,281 @@
+// Package guardrails checks generated answers before they're spoken: their length,
+// system prompt text they repeat, and contact details the profile doesn't contain.
+// A Guard can also fix the answer, for backends whose output isn't streamed yet.
+package guardrails
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/persona"
+)
+
+// Checks that produce violations
+const (
+	CheckLength     = "length"      // More sentences or characters than allowed
+	CheckPromptLeak = "prompt_leak" // A long run of words copied from the system prompt
+	CheckContact    = "contact"     // An email address, phone number or link not in the profile
+)
+
+// Violation is one problem found in an answer
+type Violation struct {
+	Check  string
+	Detail string
+}
+
+func (v Violation) String() string {
+	return v.Check + ": " + v.Detail
+}
+
+// Guard checks answers against the persona they were generated for.
+// A nil *Guard is valid and finds nothing.
+type Guard struct {
+	MaxSentences int // 0 = any number
+	MaxChars     int // 0 = any length
+	LeakWords    int // Shortest run of prompt words counted as a leak, 0 disables the check
+
+	// Fix answers before they're sent (see Apply) rather than only reporting them
+	Enforce bool
+
+	// Answer sent instead of one that leaks the prompt or is left empty by a fix
+	Replacement string
+}
+
+// New creates the guard configured in cfg, or nil when guardrails are off
+func New(cfg *config.Config) *Guard {
+	if cfg.GuardrailsMode != "monitor" && cfg.GuardrailsMode != "enforce" {
+		return nil
+	}
+	return &Guard{
+		MaxSentences: cfg.GuardrailsMaxSentences,
+		MaxChars:     cfg.GuardrailsMaxChars,
+		LeakWords:    cfg.GuardrailsLeakWords,
+		Enforce:      cfg.GuardrailsMode == "enforce",
+		Replacement:  cfg.Phrase(config.PhraseRefusal),
+	}
+}
+
+// Enforcing reports whether answers should be held back and fixed with Apply
+func (g *Guard) Enforcing() bool {
+	return g != nil && g.Enforce
+}
+
+// Check returns the violations in answer, generated for p. Only the prompt's
+// instructions count as leaked; answers may quote its profile blocks.
+func (g *Guard) Check(answer string, p *persona.Persona) []Violation {
+	if g == nil {
+		return nil
+	}
+	var violations []Violation
+	if run := g.leakedRun(answer, p.Instructions); run != "" {
+		violations = append(violations, Violation{CheckPromptLeak, fmt.Sprintf("repeats %q", run)})
+	}
+	for _, contact := range unknownContacts(answer, p.Prompt) {
+		violations = append(violations, Violation{CheckContact, fmt.Sprintf("%q isn't in the profile", contact)})
+	}
+	n := len(sentences(answer))
+	if g.MaxSentences > 0 && n > g.MaxSentences {
+		violations = append(violations, Violation{CheckLength, fmt.Sprintf("%d sentences (at most %d)", n, g.MaxSentences)})
+	} else if g.MaxChars > 0 && len(answer) > g.MaxChars {
+		violations = append(violations, Violation{CheckLength, fmt.Sprintf("%d characters (at most %d)", len(answer), g.MaxChars)})
+	}
+	return violations
+}
+
+// Apply returns answer with its violations fixed, and the violations found: an answer
+// that leaks the prompt is replaced, sentences with unknown contact details are
+// removed, and long answers are truncated to whole sentences where possible.
+func (g *Guard) Apply(answer string, p *persona.Persona) (string, []Violation) {
+	violations := g.Check(answer, p)
+	if len(violations) == 0 {
+		return answer, nil
+	}
+	fixed := answer
+	for _, v := range violations {
+		if v.Check == CheckPromptLeak {
+			return g.Replacement, violations
+		}
+		if v.Check == CheckContact {
+			var kept []string
+			for _, s := range sentences(fixed) {
+				if len(unknownContacts(s, p.Prompt)) == 0 {
+					kept = append(kept, s)
+				}
+			}
+			fixed = strings.Join(kept, "")
+		}
+	}
+	fixed = strings.TrimSpace(g.truncate(fixed))
+	if fixed == "" {
+		return g.Replacement, violations
+	}
+	return fixed, violations
+}
+
+// truncate shortens text to MaxSentences sentences and MaxChars characters, cutting
+// at a word boundary if even the first sentence is too long
+func (g *Guard) truncate(text string) string {
+	var b strings.Builder
+	for i, s := range sentences(text) {
+		if g.MaxSentences > 0 && i == g.MaxSentences {
+			break
+		}
+		if g.MaxChars > 0 && b.Len()+len(strings.TrimRightFunc(s, unicode.IsSpace)) > g.MaxChars {
+			break
+		}
+		b.WriteString(s)
+	}
+	if b.Len() > 0 {
+		return b.String()
+	}
+	if g.MaxChars == 0 || len(text) <= g.MaxChars {
+		return text
+	}
+	n := g.MaxChars
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	cut := text[:n]
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSpace(r) }) + "..."
+}
+
+// sentences splits text after each run of ., ! or ? followed by whitespace, keeping
+// the whitespace so the sentences join back into the original text
+func sentences(text string) []string {
+	var out []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if !isTerminator(text[i]) {
+			continue
+		}
+		j := i + 1
+		for j < len(text) && isTerminator(text[j]) {
+			j++
+		}
+		if j < len(text) && !isSpace(text[j]) {
+			i = j - 1 // e.g. a decimal point or a domain name
+			continue
+		}
+		for j < len(text) && isSpace(text[j]) {
+			j++
+		}
+		out = append(out, text[start:j])
+		start = j
+		i = j - 1
+	}
+	if strings.TrimSpace(text[start:]) != "" {
+		out = append(out, text[start:])
+	}
+	return out
+}
+
+func isTerminator(c byte) bool {
+	return c == '.' || c == '!' || c == '?'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r'
+}
+
+// words splits text into lowercase words, ignoring punctuation
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+}
+
+// leakedRun returns the first run of LeakWords words of answer that also appears in
+// instructions, or "" if there's none
+func (g *Guard) leakedRun(answer, instructions string) string {
+	if g.LeakWords <= 0 {
+		return ""
+	}
+	answerWords := words(answer)
+	if len(answerWords) < g.LeakWords {
+		return ""
+	}
+	promptRuns := make(map[string]bool)
+	promptWords := words(instructions)
+	for i := 0; i+g.LeakWords <= len(promptWords); i++ {
+		promptRuns[strings.Join(promptWords[i:i+g.LeakWords], " ")] = true
+	}
+	for i := 0; i+g.LeakWords <= len(answerWords); i++ {
+		if run := strings.Join(answerWords[i:i+g.LeakWords], " "); promptRuns[run] {
+			return run
+		}
+	}
+	return ""
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+?\(?\d[\d\s().-]{6,}\d`)
+	linkPattern  = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'()]+`)
+)
+
+// unknownContacts returns the email addresses, phone numbers and links in text that
+// don't appear in profile
+func unknownContacts(text, profile string) []string {
+	lowerProfile := strings.ToLower(profile)
+	var unknown []string
+	for _, email := range emailPattern.FindAllString(text, -1) {
+		if !strings.Contains(lowerProfile, strings.ToLower(email)) {
+			unknown = append(unknown, email)
+		}
+	}
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		link = strings.TrimRight(link, ".,;:!?")
+		if !strings.Contains(lowerProfile, bareLink(link)) {
+			unknown = append(unknown, link)
+		}
+	}
+	var profilePhones []string
+	for _, phone := range phonePattern.FindAllString(profile, -1) {
+		if d := digits(phone); len(d) >= 9 {
+			profilePhones = append(profilePhones, d)
+		}
+	}
+	for _, phone := range phonePattern.FindAllString(text, -1) {
+		// Years and ranges like "2015 - 2020" aren't phone numbers
+		if d := digits(phone); len(d) >= 9 && !knownPhone(d, profilePhones) {
+			unknown = append(unknown, strings.TrimSpace(phone))
+		}
+	}
+	return unknown
+}
+
+// knownPhone reports whether the digits of a phone number match a profile number,
+// with or without the country code
+func knownPhone(number string, profilePhones []string) bool {
+	for _, known := range profilePhones {
+		if strings.HasSuffix(known, number) || strings.HasSuffix(number, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// bareLink lowercases a link and drops its scheme, www. and trailing slash
+func bareLink(link string) string {
+	link = strings.ToLower(link)
+	for _, prefix := range []string{"https://", "http://", "www."} {
+		link = strings.TrimPrefix(link, prefix)
+	}
+	return strings.TrimSuffix(link, "/")
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,93 @@
+package guardrails
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"christianmoore.me/avatar-backend/persona"
+)
+
+var testPersona = persona.New(`You are a virtual assistant with knowledge about Christian Moore.
+Your sole job is to answer questions about Christian using only profile facts in this system message.
+<profile>
+Christian designed and operated production Kubernetes platforms across AWS and on-premise data centers.
+Contact: christian@example.com, https://christianmoore.me and +1 (603) 555-0100.
+</profile>`, "test", "cedar", "onyx", 1)
+
+func testGuard() *Guard {
+	return &Guard{MaxSentences: 2, MaxChars: 120, LeakWords: 8, Enforce: true, Replacement: "Sorry."}
+}
+
+func checks(violations []Violation) []string {
+	var out []string
+	for _, v := range violations {
+		out = append(out, v.Check)
+	}
+	return out
+}
+
+func TestSentences(t *testing.T) {
+	text := "He uses Go 1.22 at christianmoore.me. Really?! Yes...  And Rust"
+	got := sentences(text)
+	want := []string{"He uses Go 1.22 at christianmoore.me. ", "Really?! ", "Yes...  ", "And Rust"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sentences() = %q, want %q", got, want)
+	}
+	if strings.Join(got, "") != text {
+		t.Error("Expected sentences to join back into the text")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	g := testGuard()
+	tests := []struct {
+		answer string
+		want   []string
+	}{
+		{"Christian has ten years of Kubernetes experience.", nil},
+		{"Email christian@example.com or see https://christianmoore.me/.", nil},
+		{"Call him at 603-555-0100.", nil},
+		{"He worked there from 2014 - 2017.", nil},
+		{"Email chris@gmail.com.", []string{CheckContact}},
+		{"Call 555 867 5309 or visit www.linkedin.com/in/someone.", []string{CheckContact, CheckContact}},
+		{"My job is to answer questions about Christian using only profile facts.", []string{CheckPromptLeak}},
+		{"He designed and operated production Kubernetes platforms across AWS and on-premise data centers.", nil},
+		{"One. Two. Three.", []string{CheckLength}},
+		{strings.Repeat("word ", 30) + ".", []string{CheckLength}},
+	}
+	for _, tt := range tests {
+		if got := checks(g.Check(tt.answer, testPersona)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.answer, got, tt.want)
+		}
+	}
+
+	var nilGuard *Guard
+	if nilGuard.Check("Email chris@gmail.com.", testPersona) != nil || nilGuard.Enforcing() {
+		t.Error("Expected a nil guard to find nothing")
+	}
+}
+
+func TestApply(t *testing.T) {
+	g := testGuard()
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{"He runs Kubernetes.", "He runs Kubernetes."},
+		{"He runs Kubernetes. He uses Go. He likes cats.", "He runs Kubernetes. He uses Go."},
+		{"He runs Kubernetes. Email chris@gmail.com for more. He uses Go.", "He runs Kubernetes. He uses Go."},
+		{"Email chris@gmail.com.", "Sorry."},
+		{"Sure! My job is to answer questions about Christian using only profile facts.", "Sorry."},
+		{strings.Repeat("Kubernetes ", 15), "Kubernetes Kubernetes Kubernetes Kubernetes Kubernetes Kubernetes Kubernetes Kubernetes Kubernetes Kubernetes..."},
+	}
+	for _, tt := range tests {
+		got, violations := g.Apply(tt.answer, testPersona)
+		if got != tt.want {
+			t.Errorf("Apply(%q) = %q, want %q", tt.answer, got, tt.want)
+		}
+		if (got == tt.answer) != (len(violations) == 0) {
+			t.Errorf("Apply(%q) reported %v", tt.answer, violations)
+		}
+	}
+}
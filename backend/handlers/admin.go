@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"christianmoore.me/avatar-backend/cache"
+	"christianmoore.me/avatar-backend/persona"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler serves operational endpoints protected by a static admin token
+type AdminHandler struct {
+	token     string
+	personas  *persona.Store
+	watcher   *persona.Watcher
+	responses *cache.Responses
+}
+
+func NewAdminHandler(token string, personas *persona.Store, watcher *persona.Watcher) *AdminHandler {
+	return &AdminHandler{
+		token:    token,
+		personas: personas,
+		watcher:  watcher,
+	}
+}
+
+// SetResponseCache exposes the response cache to the cache endpoints
+func (h *AdminHandler) SetResponseCache(responses *cache.Responses) {
+	h.responses = responses
+}
+
+// RequireAdmin rejects requests without the admin bearer token.
+// Admin endpoints are disabled entirely when no token is configured.
+func (h *AdminHandler) RequireAdmin(c *gin.Context) {
+	if h.token == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Admin endpoints are disabled"})
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+		return
+	}
+	c.Next()
+}
+
+// personaResponse describes a persona without exposing the prompt itself
+func personaResponse(p *persona.Persona) gin.H {
+	return gin.H{
+		"prompt_version": p.Version,
+		"prompt_bytes":   len(p.Prompt),
+		"source":         p.Source,
+		"loaded_at":      p.LoadedAt,
+		"realtime_voice": p.RealtimeVoice,
+		"tts_voice":      p.TTSVoice,
+		"tts_speed":      p.TTSSpeed,
+	}
+}
+
+// HandleGetPersona reports the persona new sessions will use
+func (h *AdminHandler) HandleGetPersona(c *gin.Context) {
+	c.JSON(http.StatusOK, personaResponse(h.personas.Current()))
+}
+
+// HandleReloadPersona reloads the persona immediately (same as SIGHUP)
+func (h *AdminHandler) HandleReloadPersona(c *gin.Context) {
+	p, err := h.watcher.Reload()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":          err.Error(),
+			"prompt_version": h.personas.Current().Version,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, personaResponse(p))
+}
+
+// responseCacheResponse describes the response cache contents and limits
+func responseCacheResponse(responses *cache.Responses) gin.H {
+	stats := responses.Stats()
+	return gin.H{
+		"enabled":     responses != nil,
+		"entries":     stats.Entries,
+		"bytes":       stats.Bytes,
+		"max_entries": stats.MaxEntries,
+		"max_bytes":   stats.MaxBytes,
+		"ttl":         stats.TTL.String(),
+	}
+}
+
+// HandleGetResponseCache reports how many answers are cached
+func (h *AdminHandler) HandleGetResponseCache(c *gin.Context) {
+	c.JSON(http.StatusOK, responseCacheResponse(h.responses))
+}
+
+// HandlePurgeResponseCache drops every cached answer, e.g. after correcting the
+// profile without changing the prompt
+func (h *AdminHandler) HandlePurgeResponseCache(c *gin.Context) {
+	purged := h.responses.Purge()
+	response := responseCacheResponse(h.responses)
+	response["purged"] = purged
+	c.JSON(http.StatusOK, response)
+}
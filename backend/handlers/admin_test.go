@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/cache"
+	"christianmoore.me/avatar-backend/persona"
+	"github.com/gin-gonic/gin"
+)
+
+func newAdminRouter(token string) (*gin.Engine, *persona.Store) {
+	gin.SetMode(gin.TestMode)
+	personas := persona.NewStore(persona.New("You are Christian.", "test", "cedar", "onyx", 1))
+	handler := NewAdminHandler(token, personas, nil)
+
+	router := gin.New()
+	admin := router.Group("/admin", handler.RequireAdmin)
+	admin.GET("/persona", handler.HandleGetPersona)
+	return router, personas
+}
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		wantStatus int
+	}{
+		{"disabled without token", "", "Bearer anything", http.StatusNotFound},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newAdminRouter(tt.token)
+			req := httptest.NewRequest("GET", "/admin/persona", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandleGetPersona(t *testing.T) {
+	router, personas := newAdminRouter("secret")
+	req := httptest.NewRequest("GET", "/admin/persona", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response["prompt_version"] != personas.Current().Version {
+		t.Errorf("Expected prompt_version %s, got %v", personas.Current().Version, response["prompt_version"])
+	}
+	if _, ok := response["prompt"]; ok {
+		t.Error("Expected the prompt text not to be exposed")
+	}
+}
+
+func TestHandlePurgeResponseCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	responses := cache.NewResponses(time.Hour, 10, 1<<20)
+	responses.Put("key", &cache.Answer{Chunks: []cache.Chunk{{Kind: cache.ChunkText, Text: "Hi"}}})
+
+	handler := NewAdminHandler("secret", nil, nil)
+	handler.SetResponseCache(responses)
+	router := gin.New()
+	router.POST("/admin/cache/responses/purge", handler.RequireAdmin, handler.HandlePurgeResponseCache)
+
+	req := httptest.NewRequest("POST", "/admin/cache/responses/purge", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response["purged"] != 1.0 || response["entries"] != 0.0 {
+		t.Errorf("Expected 1 answer purged and none left, got %v", response)
+	}
+	if _, ok := responses.Get("key"); ok {
+		t.Error("Expected the cache to be empty")
+	}
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"christianmoore.me/avatar-backend/metrics"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// API key prefix and scopes
+const (
+	APIKeyPrefix = "avk_" // Raw keys start with this prefix so they can't be confused with JWTs
+
+	ScopeToken = "token" // Exchange the key for a JWT on /api/token
+	ScopeChat  = "chat"  // Connect directly to /ws/chat with the key
+)
+
+var (
+	ErrAPIKeyInvalid       = errors.New("invalid API key")
+	ErrAPIKeyExpired       = errors.New("API key expired")
+	ErrAPIKeyScope         = errors.New("API key not authorized for this endpoint")
+	ErrAPIKeyRateLimited   = errors.New("API key rate limit exceeded")
+	ErrAPIKeyQuotaExceeded = errors.New("API key daily quota exceeded")
+)
+
+// APIKey describes a trusted server-to-server integration.
+// Only the SHA-256 hash of the raw key is stored.
+type APIKey struct {
+	Name          string     `json:"name"`
+	Hash          string     `json:"hash"`                      // hex-encoded SHA-256 of the raw key
+	Scopes        []string   `json:"scopes"`                    // ScopeToken and/or ScopeChat
+	RatePerMinute int        `json:"rate_per_minute,omitempty"` // 0 = unlimited
+	DailyQuota    int        `json:"daily_quota,omitempty"`     // 0 = unlimited
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+func (k *APIKey) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyState tracks rate limiting and daily usage for a single key
+type apiKeyState struct {
+	key      APIKey
+	hash     []byte
+	limiter  *rate.Limiter
+	usageDay string
+	usage    int
+}
+
+// APIKeyStore validates API keys and enforces per-key limits
+type APIKeyStore struct {
+	mu   sync.Mutex
+	keys []*apiKeyState
+	now  func() time.Time
+}
+
+// apiKeysFile is the on-disk format of the API keys file
+type apiKeysFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash stored for a raw key
+func HashAPIKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadAPIKeys reads API key definitions from a JSON file
+func LoadAPIKeys(path string) (*APIKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+
+	var file apiKeysFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys file: %w", err)
+	}
+
+	return NewAPIKeyStore(file.Keys)
+}
+
+// NewAPIKeyStore creates a store from a list of key definitions
+func NewAPIKeyStore(keys []APIKey) (*APIKeyStore, error) {
+	store := &APIKeyStore{now: time.Now}
+	names := make(map[string]bool)
+
+	for _, k := range keys {
+		if k.Name == "" {
+			return nil, fmt.Errorf("API key is missing a name")
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("duplicate API key name %q", k.Name)
+		}
+		names[k.Name] = true
+
+		hash, err := hex.DecodeString(strings.ToLower(k.Hash))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("API key %q: hash must be a hex-encoded SHA-256 digest", k.Name)
+		}
+		for _, s := range k.Scopes {
+			if s != ScopeToken && s != ScopeChat {
+				return nil, fmt.Errorf("API key %q: unknown scope %q", k.Name, s)
+			}
+		}
+
+		state := &apiKeyState{key: k, hash: hash}
+		if k.RatePerMinute > 0 {
+			state.limiter = rate.NewLimiter(rate.Limit(float64(k.RatePerMinute)/60), k.RatePerMinute)
+		}
+		store.keys = append(store.keys, state)
+	}
+
+	return store, nil
+}
+
+// Len returns the number of configured keys
+func (s *APIKeyStore) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.keys)
+}
+
+// Authenticate validates a raw key for the given scope and counts one use against its limits
+func (s *APIKeyStore) Authenticate(raw, scope string) (*APIKey, error) {
+	state, err := s.verify(raw, scope)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.use(state); err != nil {
+		return nil, err
+	}
+	key := state.key
+	return &key, nil
+}
+
+// Verify validates a raw key for the given scope without counting a use, for
+// connections whose messages are counted instead
+func (s *APIKeyStore) Verify(raw, scope string) (*APIKey, error) {
+	state, err := s.verify(raw, scope)
+	if err != nil {
+		return nil, err
+	}
+	key := state.key
+	return &key, nil
+}
+
+func (s *APIKeyStore) verify(raw, scope string) (*apiKeyState, error) {
+	if s == nil || !strings.HasPrefix(raw, APIKeyPrefix) {
+		metrics.APIKeyRejections.Add("unknown", 1)
+		return nil, ErrAPIKeyInvalid
+	}
+
+	sum := sha256.Sum256([]byte(raw))
+	var state *apiKeyState
+	for _, candidate := range s.keys {
+		if subtle.ConstantTimeCompare(sum[:], candidate.hash) == 1 {
+			state = candidate
+		}
+	}
+	if state == nil {
+		metrics.APIKeyRejections.Add("unknown", 1)
+		return nil, ErrAPIKeyInvalid
+	}
+
+	if err := s.checkExpiry(state); err != nil {
+		return nil, err
+	}
+	if !state.key.hasScope(scope) {
+		metrics.APIKeyRejections.Add(state.key.Name, 1)
+		return nil, ErrAPIKeyScope
+	}
+	return state, nil
+}
+
+// Lookup returns the named key if it's still configured and hasn't expired, e.g.
+// for a JWT issued to it
+func (s *APIKeyStore) Lookup(name string) (*APIKey, error) {
+	state := s.find(name)
+	if state == nil {
+		metrics.APIKeyRejections.Add("unknown", 1)
+		return nil, ErrAPIKeyInvalid
+	}
+	if err := s.checkExpiry(state); err != nil {
+		return nil, err
+	}
+	key := state.key
+	return &key, nil
+}
+
+// Use counts one request against the named key's rate limit and daily quota
+func (s *APIKeyStore) Use(name string) error {
+	state := s.find(name)
+	if state == nil {
+		return ErrAPIKeyInvalid
+	}
+	return s.use(state)
+}
+
+func (s *APIKeyStore) find(name string) *apiKeyState {
+	if s == nil {
+		return nil
+	}
+	for _, state := range s.keys {
+		if state.key.Name == name {
+			return state
+		}
+	}
+	return nil
+}
+
+func (s *APIKeyStore) checkExpiry(state *apiKeyState) error {
+	if state.key.ExpiresAt != nil && s.now().After(*state.key.ExpiresAt) {
+		metrics.APIKeyRejections.Add(state.key.Name, 1)
+		return ErrAPIKeyExpired
+	}
+	return nil
+}
+
+func (s *APIKeyStore) use(state *apiKeyState) error {
+	// Keys can expire during a long session
+	if err := s.checkExpiry(state); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if state.limiter != nil && !state.limiter.AllowN(now, 1) {
+		metrics.APIKeyRejections.Add(state.key.Name, 1)
+		return ErrAPIKeyRateLimited
+	}
+
+	// Reset usage at UTC midnight
+	day := now.UTC().Format("2006-01-02")
+	if state.usageDay != day {
+		state.usageDay = day
+		state.usage = 0
+	}
+	if state.key.DailyQuota > 0 && state.usage >= state.key.DailyQuota {
+		metrics.APIKeyRejections.Add(state.key.Name, 1)
+		return ErrAPIKeyQuotaExceeded
+	}
+	state.usage++
+
+	metrics.APIKeyRequests.Add(state.key.Name, 1)
+	return nil
+}
+
+// apiKeyFromRequest extracts a raw API key from the X-API-Key header or an Authorization bearer token
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	authHeader := c.GetHeader("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer "+APIKeyPrefix) {
+		return authHeader[7:]
+	}
+	return ""
+}
+
+// apiKeyErrorStatus maps API key errors to HTTP status codes
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrAPIKeyRateLimited), errors.Is(err, ErrAPIKeyQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrAPIKeyScope):
+		return http.StatusForbidden
+	default:
+		return http.StatusUnauthorized
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/testing/fakes"
+	"github.com/gin-gonic/gin"
+)
+
+const testRawAPIKey = "avk_test-integration-key"
+
+func newTestAPIKeyStore(t *testing.T, key APIKey) *APIKeyStore {
+	t.Helper()
+	if key.Name == "" {
+		key.Name = "test-bot"
+	}
+	key.Hash = HashAPIKey(testRawAPIKey)
+	store, err := NewAPIKeyStore([]APIKey{key})
+	if err != nil {
+		t.Fatalf("NewAPIKeyStore failed: %v", err)
+	}
+	return store
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	store := newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeToken}})
+
+	key, err := store.Authenticate(testRawAPIKey, ScopeToken)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if key.Name != "test-bot" {
+		t.Errorf("Expected key name 'test-bot', got '%s'", key.Name)
+	}
+
+	if _, err := store.Authenticate("avk_wrong-key", ScopeToken); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("Expected ErrAPIKeyInvalid for unknown key, got %v", err)
+	}
+
+	if _, err := store.Authenticate(testRawAPIKey, ScopeChat); !errors.Is(err, ErrAPIKeyScope) {
+		t.Errorf("Expected ErrAPIKeyScope for missing scope, got %v", err)
+	}
+}
+
+func TestAPIKeyExpired(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	store := newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeChat}, ExpiresAt: &expired})
+
+	if _, err := store.Authenticate(testRawAPIKey, ScopeChat); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("Expected ErrAPIKeyExpired, got %v", err)
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	store := newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeChat}, RatePerMinute: 2})
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Authenticate(testRawAPIKey, ScopeChat); err != nil {
+			t.Fatalf("Request %d should be allowed: %v", i+1, err)
+		}
+	}
+	if err := store.Use("test-bot"); !errors.Is(err, ErrAPIKeyRateLimited) {
+		t.Errorf("Expected ErrAPIKeyRateLimited, got %v", err)
+	}
+}
+
+func TestAPIKeyDailyQuota(t *testing.T) {
+	store := newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeChat}, DailyQuota: 1})
+	day := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return day }
+
+	if err := store.Use("test-bot"); err != nil {
+		t.Fatalf("First request should be allowed: %v", err)
+	}
+	if err := store.Use("test-bot"); !errors.Is(err, ErrAPIKeyQuotaExceeded) {
+		t.Errorf("Expected ErrAPIKeyQuotaExceeded, got %v", err)
+	}
+
+	// Quota resets the next day
+	day = day.Add(24 * time.Hour)
+	if err := store.Use("test-bot"); err != nil {
+		t.Errorf("Quota should reset on a new day: %v", err)
+	}
+}
+
+func TestAPIKeyExpiresDuringSession(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	store := newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeChat}, ExpiresAt: &expires})
+	store.now = func() time.Time { return now }
+
+	if _, err := store.Verify(testRawAPIKey, ScopeChat); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := store.Use("test-bot"); err != nil {
+		t.Fatalf("Use before expiry failed: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if err := store.Use("test-bot"); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("Expected ErrAPIKeyExpired once the key expired, got %v", err)
+	}
+	if _, err := store.Lookup("test-bot"); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("Expected Lookup to report the expired key, got %v", err)
+	}
+}
+
+func TestAPIKeyVerifyDoesNotCountUse(t *testing.T) {
+	store := newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeChat}, DailyQuota: 1})
+
+	if _, err := store.Verify(testRawAPIKey, ScopeChat); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := store.Use("test-bot"); err != nil {
+		t.Errorf("Expected the quota to be left for the first message: %v", err)
+	}
+	if err := store.Use("test-bot"); !errors.Is(err, ErrAPIKeyQuotaExceeded) {
+		t.Errorf("Expected ErrAPIKeyQuotaExceeded, got %v", err)
+	}
+}
+
+func TestAPIKeyLookup(t *testing.T) {
+	var missing *APIKeyStore
+	if _, err := missing.Lookup("test-bot"); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("Expected ErrAPIKeyInvalid without a key store, got %v", err)
+	}
+	store := newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeToken}})
+	if _, err := store.Lookup("other-bot"); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("Expected ErrAPIKeyInvalid for an unknown name, got %v", err)
+	}
+	if key, err := store.Lookup("test-bot"); err != nil || key.Name != "test-bot" {
+		t.Errorf("Expected test-bot, got %v (%v)", key, err)
+	}
+}
+
+func TestWebSocketRejectsUnresolvableAPIKey(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name  string
+		store *APIKeyStore
+	}{
+		{"no key store", nil},
+		{"expired key", newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeToken}, ExpiresAt: &expired})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := NewAuthHandler("test-secret-key", "", "")
+			token, err := auth.generateJWTForAPIKey("test-bot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth.SetAPIKeyStore(tt.store)
+			handler, _ := newTestChatServer(t)
+			handler.authHandler = auth
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/ws/chat?token="+token, nil)
+			handler.HandleWebSocket(c)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected the session to be refused at connect, got status %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestWebSocketHandshakeDoesNotCountAgainstQuota(t *testing.T) {
+	llm := fakes.NewLLM("qwen2.5-7b-instruct")
+	tts := fakes.NewTTS()
+	llmServer, ttsServer := fakes.Serve(t, llm), fakes.Serve(t, tts)
+	handler, server := newTestChatServer(t, func(cfg *config.Config) {
+		cfg.Backends = []string{BackendLocal}
+		cfg.LocalLLMURL = llmServer.URL
+		cfg.LocalLLMModel = "qwen2.5-7b-instruct"
+		cfg.TTSURL = ttsServer.URL
+	})
+	handler.authHandler = NewAuthHandler("test-secret-key", "", "")
+	handler.authHandler.SetAPIKeyStore(newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeChat}, DailyQuota: 1}))
+
+	conn, _ := dialTestSession(t, server, "?token="+testRawAPIKey)
+	if err := conn.WriteJSON(ClientMessage{Type: "message", Message: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	var msg ServerMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type == "error" {
+		t.Errorf("Expected the first message to be answered, got error %q", msg.Error)
+	}
+}
+
+func TestNewAPIKeyStoreValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []APIKey
+	}{
+		{"missing name", []APIKey{{Hash: HashAPIKey(testRawAPIKey)}}},
+		{"invalid hash", []APIKey{{Name: "a", Hash: "not-hex"}}},
+		{"unknown scope", []APIKey{{Name: "a", Hash: HashAPIKey(testRawAPIKey), Scopes: []string{"admin"}}}},
+		{"duplicate name", []APIKey{{Name: "a", Hash: HashAPIKey("avk_1")}, {Name: "a", Hash: HashAPIKey("avk_2")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAPIKeyStore(tt.keys); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	content := `{"keys": [{"name": "kiosk", "hash": "` + HashAPIKey(testRawAPIKey) + `", "scopes": ["chat"]}]}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+
+	store, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatalf("LoadAPIKeys failed: %v", err)
+	}
+	if store.Len() != 1 {
+		t.Errorf("Expected 1 key, got %d", store.Len())
+	}
+	if _, err := store.Authenticate(testRawAPIKey, ScopeChat); err != nil {
+		t.Errorf("Authenticate failed: %v", err)
+	}
+}
+
+func TestHandleGetTokenWithAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewAuthHandler("test-secret-key", "", "")
+	handler.SetAPIKeyStore(newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeToken}}))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/token", nil)
+	c.Request.Header.Set("X-API-Key", testRawAPIKey)
+
+	handler.HandleGetToken(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	claims, err := handler.VerifyJWT(response["jwt"])
+	if err != nil {
+		t.Fatalf("VerifyJWT failed: %v", err)
+	}
+	if claims.APIKey != "test-bot" {
+		t.Errorf("Expected token attributed to 'test-bot', got '%s'", claims.APIKey)
+	}
+}
+
+func TestHandleGetTokenWithInvalidAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewAuthHandler("test-secret-key", "", "")
+	handler.SetAPIKeyStore(newTestAPIKeyStore(t, APIKey{Scopes: []string{ScopeToken}}))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/token", nil)
+	c.Request.Header.Set("Authorization", "Bearer avk_not-a-real-key")
+
+	handler.HandleGetToken(c)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
@@ -2,23 +2,33 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
 	"time"
 
+	"christianmoore.me/avatar-backend/limits"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 const (
-	JWTExpirationTime = 30 * time.Minute // JWT tokens valid for 30 minutes
+	JWTExpirationTime = 30 * time.Minute // Default JWT lifetime (configurable via JWT_EXPIRATION)
+
+	// Token endpoint rate limiting per IP (shared across replicas when using Redis)
+	TokenRequestRate  = 6 * time.Second // 1 token per 6 seconds
+	TokenRequestBurst = 5               // Allow burst of 5 (page refreshes, retries)
 )
 
 type AuthHandler struct {
 	jwtSecret        []byte
 	turnstileSecret  string
 	turnstileSiteKey string
+	apiKeys          *APIKeyStore
+	limits           limits.LimitStore
+	jwtExpiration    time.Duration
 }
 
 func NewAuthHandler(jwtSecret, turnstileSecret, turnstileSiteKey string) *AuthHandler {
@@ -26,9 +36,45 @@ func NewAuthHandler(jwtSecret, turnstileSecret, turnstileSiteKey string) *AuthHa
 		jwtSecret:        []byte(jwtSecret),
 		turnstileSecret:  turnstileSecret,
 		turnstileSiteKey: turnstileSiteKey,
+		limits:           limits.NewMemoryStore(),
+		jwtExpiration:    JWTExpirationTime,
 	}
 }
 
+// SetJWTExpiration overrides how long issued tokens stay valid
+func (h *AuthHandler) SetJWTExpiration(d time.Duration) {
+	h.jwtExpiration = d
+}
+
+// SetLimitStore replaces the default in-memory limit store (e.g. with a shared Redis store)
+func (h *AuthHandler) SetLimitStore(store limits.LimitStore) {
+	h.limits = store
+}
+
+// allowTokenRequest applies the per-IP token endpoint rate limit.
+// Store errors fail open so a Redis outage doesn't block all visitors.
+func (h *AuthHandler) allowTokenRequest(c *gin.Context) bool {
+	allowed, err := h.limits.Allow(c.Request.Context(), "token:ip:"+c.ClientIP(), limits.Limit{Every: TokenRequestRate, Burst: TokenRequestBurst})
+	if err != nil {
+		log.Printf("Warning: limit store error, allowing token request: %v", err)
+		return true
+	}
+	if !allowed {
+		log.Printf("Token rate limit exceeded for IP %s", c.ClientIP())
+	}
+	return allowed
+}
+
+// SetAPIKeyStore enables API key authentication for trusted integrations
+func (h *AuthHandler) SetAPIKeyStore(store *APIKeyStore) {
+	h.apiKeys = store
+}
+
+// AuthenticateAPIKey validates a raw API key for the given scope
+func (h *AuthHandler) AuthenticateAPIKey(raw, scope string) (*APIKey, error) {
+	return h.apiKeys.Authenticate(raw, scope)
+}
+
 // TurnstileVerifyRequest from frontend
 type TurnstileVerifyRequest struct {
 	Token string `json:"token" binding:"required"`
@@ -52,6 +98,7 @@ type CloudflareTurnstileResponse struct {
 // JWTClaims for our session tokens
 type JWTClaims struct {
 	jwt.RegisteredClaims
+	APIKey string `json:"api_key,omitempty"` // Name of the API key the token was issued to, if any
 }
 
 // HandleVerifyTurnstile verifies Cloudflare Turnstile token and issues JWT
@@ -65,6 +112,14 @@ func (h *AuthHandler) HandleVerifyTurnstile(c *gin.Context) {
 		return
 	}
 
+	if !h.allowTokenRequest(c) {
+		c.JSON(http.StatusTooManyRequests, TurnstileVerifyResponse{
+			Success: false,
+			Error:   "Too many requests",
+		})
+		return
+	}
+
 	// Verify Turnstile token with Cloudflare API
 	verified, err := h.verifyTurnstileToken(req.Token, c.ClientIP())
 	if err != nil {
@@ -111,9 +166,28 @@ func (h *AuthHandler) HandleGetSiteKey(c *gin.Context) {
 }
 
 // HandleGetToken issues a JWT token without verification (rate-limited by Traefik)
+// If an API key is presented it is validated and the token is attributed to it
 func (h *AuthHandler) HandleGetToken(c *gin.Context) {
+	var keyName string
+	if rawKey := apiKeyFromRequest(c); rawKey != "" {
+		key, err := h.AuthenticateAPIKey(rawKey, ScopeToken)
+		if err != nil {
+			log.Printf("API key rejected on /api/token for IP %s: %v", c.ClientIP(), err)
+			c.JSON(apiKeyErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		keyName = key.Name
+	} else if !h.allowTokenRequest(c) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many requests",
+		})
+		return
+	}
+
 	// Generate JWT token
-	token, err := h.generateJWT()
+	token, err := h.generateJWTForAPIKey(keyName)
 	if err != nil {
 		log.Printf("JWT generation error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -122,7 +196,11 @@ func (h *AuthHandler) HandleGetToken(c *gin.Context) {
 		return
 	}
 
-	log.Printf("JWT issued for IP %s", c.ClientIP())
+	if keyName != "" {
+		log.Printf("JWT issued for API key %s (IP %s)", keyName, c.ClientIP())
+	} else {
+		log.Printf("JWT issued for IP %s", c.ClientIP())
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"jwt": token,
 	})
@@ -171,6 +249,11 @@ func (h *AuthHandler) verifyTurnstileToken(token, remoteIP string) (bool, error)
 
 // generateJWT creates a new JWT token
 func (h *AuthHandler) generateJWT() (string, error) {
+	return h.generateJWTForAPIKey("")
+}
+
+// generateJWTForAPIKey creates a new JWT token attributed to the named API key
+func (h *AuthHandler) generateJWTForAPIKey(keyName string) (string, error) {
 	// If no JWT secret configured, return empty (for development)
 	if len(h.jwtSecret) == 0 {
 		log.Printf("Warning: JWT_SECRET not configured, authentication disabled")
@@ -179,15 +262,24 @@ func (h *AuthHandler) generateJWT() (string, error) {
 
 	claims := JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(JWTExpirationTime)),
+			ID:        newSessionID(), // Keys per-session limits that survive reconnects
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(h.jwtExpiration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
+		APIKey: keyName,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(h.jwtSecret)
 }
 
+// newSessionID returns a random JWT ID
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // VerifyJWT validates a JWT token
 func (h *AuthHandler) VerifyJWT(tokenString string) (*JWTClaims, error) {
 	// If no JWT secret configured, allow (for development)
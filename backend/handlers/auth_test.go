@@ -207,3 +207,41 @@ func TestVerifyTurnstileTokenWithoutSecret(t *testing.T) {
 		t.Error("verifyTurnstileToken should return true when no secret is configured")
 	}
 }
+
+func TestHandleGetTokenRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewAuthHandler("test-secret-key", "", "")
+
+	var lastCode int
+	for i := 0; i <= TokenRequestBurst; i++ {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/api/token", nil)
+		handler.HandleGetToken(c)
+		lastCode = w.Code
+	}
+
+	if lastCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 after burst, got %d", lastCode)
+	}
+}
+
+func TestGenerateJWTSessionID(t *testing.T) {
+	handler := NewAuthHandler("test-secret-key", "", "")
+
+	first, _ := handler.generateJWT()
+	second, _ := handler.generateJWT()
+
+	firstClaims, err := handler.VerifyJWT(first)
+	if err != nil {
+		t.Fatalf("VerifyJWT failed: %v", err)
+	}
+	secondClaims, err := handler.VerifyJWT(second)
+	if err != nil {
+		t.Fatalf("VerifyJWT failed: %v", err)
+	}
+
+	if firstClaims.ID == "" || firstClaims.ID == secondClaims.ID {
+		t.Errorf("Expected unique session IDs, got '%s' and '%s'", firstClaims.ID, secondClaims.ID)
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"christianmoore.me/avatar-backend/limits"
+	"christianmoore.me/avatar-backend/metrics"
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+)
+
+// Budget scopes reported when a ceiling is reached
+const (
+	BudgetScopeSession = "session"
+	BudgetScopeIP      = "ip"
+	BudgetScopeGlobal  = "global"
+)
+
+// TokenPricing holds Realtime API prices in USD per 1M tokens
+type TokenPricing struct {
+	TextInputPerMTok   float64
+	AudioInputPerMTok  float64
+	TextOutputPerMTok  float64
+	AudioOutputPerMTok float64
+
+	// Input tokens served from the prompt cache (part of the input counts above)
+	CachedTextInputPerMTok  float64
+	CachedAudioInputPerMTok float64
+}
+
+// Cost returns the USD cost of a Realtime response
+func (p TokenPricing) Cost(u *openairt.TokenUsage) float64 {
+	if u == nil {
+		return 0
+	}
+
+	// Fall back to pricing everything as text when the breakdown is missing
+	textIn, audioIn := u.InputTokens, 0
+	cachedText, cachedAudio := 0, 0
+	if d := u.InputTokenDetails; d != nil {
+		textIn, audioIn = d.TextTokens, d.AudioTokens
+		if c := d.CachedTokensDetails; c != nil {
+			cachedText, cachedAudio = c.TextTokens, c.AudioTokens
+		} else {
+			// Without the breakdown, count cached tokens as text (the prompt) first
+			cachedText = min(d.CachedTokens, textIn)
+			cachedAudio = min(d.CachedTokens-cachedText, audioIn)
+		}
+	}
+	textIn -= cachedText
+	audioIn -= cachedAudio
+	textOut, audioOut := u.OutputTokens, 0
+	if d := u.OutputTokenDetails; d != nil {
+		textOut, audioOut = d.TextTokens, d.AudioTokens
+	}
+
+	return (float64(textIn)*p.TextInputPerMTok +
+		float64(audioIn)*p.AudioInputPerMTok +
+		float64(cachedText)*p.CachedTextInputPerMTok +
+		float64(cachedAudio)*p.CachedAudioInputPerMTok +
+		float64(textOut)*p.TextOutputPerMTok +
+		float64(audioOut)*p.AudioOutputPerMTok) / 1_000_000
+}
+
+// Usage is an accumulated token count and cost
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+	CostUSD      float64
+}
+
+// TotalTokens returns input plus output tokens
+func (u Usage) TotalTokens() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+func (u *Usage) add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CostUSD += other.CostUSD
+}
+
+// exceeds reports whether usage has reached a token or cost ceiling (0 = unlimited)
+func (u Usage) exceeds(maxTokens int, maxCostUSD float64) bool {
+	return (maxTokens > 0 && u.TotalTokens() >= maxTokens) ||
+		(maxCostUSD > 0 && u.CostUSD >= maxCostUSD)
+}
+
+// BudgetConfig defines spend ceilings for the paid Realtime API (0 = unlimited)
+type BudgetConfig struct {
+	SessionTokens      int
+	SessionCostUSD     float64
+	IPDailyTokens      int
+	IPDailyCostUSD     float64
+	GlobalDailyTokens  int
+	GlobalDailyCostUSD float64
+	Pricing            TokenPricing
+	FallbackToLocal    bool // Route to the local pipeline once the paid budget is exhausted
+}
+
+// BudgetTracker accounts paid usage per IP and globally, resetting daily (UTC). The
+// daily counters live in the limit store, so with Redis the ceilings apply across all
+// replicas; with the in-memory store they apply per replica.
+type BudgetTracker struct {
+	config BudgetConfig
+	store  limits.LimitStore
+	now    func() time.Time
+}
+
+// NewBudgetTracker creates a tracker enforcing the given ceilings
+func NewBudgetTracker(config BudgetConfig) *BudgetTracker {
+	return &BudgetTracker{
+		config: config,
+		store:  limits.NewMemoryStore(),
+		now:    time.Now,
+	}
+}
+
+// SetLimitStore replaces the default in-memory store for daily counters (e.g. with a shared Redis store)
+func (t *BudgetTracker) SetLimitStore(store limits.LimitStore) {
+	t.store = store
+}
+
+// FallbackToLocal reports whether exhausted sessions should use the local pipeline
+func (t *BudgetTracker) FallbackToLocal() bool {
+	return t != nil && t.config.FallbackToLocal
+}
+
+// Daily usage counters, with cost kept in micro-USD as the store holds integers
+const (
+	counterInputTokens  = "input_tokens"
+	counterOutputTokens = "output_tokens"
+	counterCostMicroUSD = "cost_micro_usd"
+)
+
+// dailyKeys returns today's (UTC) per-IP and global counter keys and how long until the day ends
+func (t *BudgetTracker) dailyKeys(clientIP string) (ipKey, globalKey string, ttl time.Duration) {
+	now := t.now().UTC()
+	day := now.Format("2006-01-02")
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return "budget:ip:" + day + ":" + clientIP, "budget:global:" + day, midnight.AddDate(0, 0, 1).Sub(now)
+}
+
+// dailyUsage adds usage to the counters at key and returns the new totals
+func (t *BudgetTracker) dailyUsage(ctx context.Context, key string, usage Usage, ttl time.Duration) (Usage, error) {
+	var deltas map[string]int64
+	if usage != (Usage{}) {
+		deltas = map[string]int64{
+			counterInputTokens:  int64(usage.InputTokens),
+			counterOutputTokens: int64(usage.OutputTokens),
+			counterCostMicroUSD: int64(math.Round(usage.CostUSD * 1_000_000)),
+		}
+	}
+	values, err := t.store.AddCounters(ctx, key, deltas, ttl)
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		InputTokens:  int(values[counterInputTokens]),
+		OutputTokens: int(values[counterOutputTokens]),
+		CostUSD:      float64(values[counterCostMicroUSD]) / 1_000_000,
+	}, nil
+}
+
+// NewSession starts accounting for a single WebSocket connection
+func (t *BudgetTracker) NewSession(clientIP string) *SessionBudget {
+	if t == nil {
+		return nil
+	}
+	return &SessionBudget{tracker: t, clientIP: clientIP}
+}
+
+// SessionBudget tracks usage for one connection against all budget scopes.
+// A nil *SessionBudget is valid and never exceeds.
+type SessionBudget struct {
+	tracker  *BudgetTracker
+	clientIP string
+	mu       sync.Mutex
+	paid     Usage
+	local    Usage
+}
+
+// Exceeded returns the first budget scope whose paid ceiling has been reached, or "".
+// Store errors fail open so a Redis outage doesn't block all visitors.
+func (s *SessionBudget) Exceeded(ctx context.Context) string {
+	if s == nil {
+		return ""
+	}
+	cfg := s.tracker.config
+
+	s.mu.Lock()
+	session := s.paid
+	s.mu.Unlock()
+	if session.exceeds(cfg.SessionTokens, cfg.SessionCostUSD) {
+		return BudgetScopeSession
+	}
+
+	ipKey, globalKey, ttl := s.tracker.dailyKeys(s.clientIP)
+	if cfg.IPDailyTokens > 0 || cfg.IPDailyCostUSD > 0 {
+		ip, err := s.tracker.dailyUsage(ctx, ipKey, Usage{}, ttl)
+		if err != nil {
+			log.Printf("Warning: limit store error, skipping IP budget check: %v", err)
+		} else if ip.exceeds(cfg.IPDailyTokens, cfg.IPDailyCostUSD) {
+			return BudgetScopeIP
+		}
+	}
+	if cfg.GlobalDailyTokens > 0 || cfg.GlobalDailyCostUSD > 0 {
+		global, err := s.tracker.dailyUsage(ctx, globalKey, Usage{}, ttl)
+		if err != nil {
+			log.Printf("Warning: limit store error, skipping global budget check: %v", err)
+		} else if global.exceeds(cfg.GlobalDailyTokens, cfg.GlobalDailyCostUSD) {
+			return BudgetScopeGlobal
+		}
+	}
+	return ""
+}
+
+// RecordRealtime accounts a completed Realtime response against all budgets
+func (s *SessionBudget) RecordRealtime(ctx context.Context, u *openairt.TokenUsage) {
+	if s == nil || u == nil {
+		return
+	}
+	usage := Usage{
+		InputTokens:  u.InputTokens,
+		OutputTokens: u.OutputTokens,
+		CostUSD:      s.tracker.config.Pricing.Cost(u),
+	}
+
+	s.mu.Lock()
+	s.paid.add(usage)
+	session := s.paid
+	s.mu.Unlock()
+
+	ipKey, globalKey, ttl := s.tracker.dailyKeys(s.clientIP)
+	if _, err := s.tracker.dailyUsage(ctx, ipKey, usage, ttl); err != nil {
+		log.Printf("Warning: limit store error, IP budget not updated: %v", err)
+	}
+	global, err := s.tracker.dailyUsage(ctx, globalKey, usage, ttl)
+	if err != nil {
+		log.Printf("Warning: limit store error, global budget not updated: %v", err)
+	}
+
+	metrics.TokensUsed.Add(BackendRealtime, int64(usage.TotalTokens()))
+	metrics.CostUSD.Add(usage.CostUSD)
+	log.Printf("Realtime usage: %d in / %d out tokens, $%.5f (session: %d tokens $%.4f, global today: %d tokens $%.4f)",
+		usage.InputTokens, usage.OutputTokens, usage.CostUSD,
+		session.TotalTokens(), session.CostUSD, global.TotalTokens(), global.CostUSD)
+}
+
+// RecordLocal accounts local pipeline usage (not subject to paid ceilings)
+func (s *SessionBudget) RecordLocal(usage Usage) {
+	metrics.TokensUsed.Add(BackendLocal, int64(usage.TotalTokens()))
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.local.add(usage)
+	total := s.local.TotalTokens()
+	s.mu.Unlock()
+
+	log.Printf("Local usage: %d in / %d out tokens (session: %d tokens)", usage.InputTokens, usage.OutputTokens, total)
+}
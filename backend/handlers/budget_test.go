@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/limits"
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+)
+
+func TestTokenPricingCost(t *testing.T) {
+	pricing := TokenPricing{
+		TextInputPerMTok:   1,
+		AudioInputPerMTok:  10,
+		TextOutputPerMTok:  2,
+		AudioOutputPerMTok: 20,
+
+		CachedTextInputPerMTok:  0.1,
+		CachedAudioInputPerMTok: 1,
+	}
+
+	usage := &openairt.TokenUsage{
+		InputTokens:        1500,
+		OutputTokens:       3000,
+		InputTokenDetails:  &openairt.InputTokenDetails{TextTokens: 1000, AudioTokens: 500},
+		OutputTokenDetails: &openairt.OutputTokenDetails{TextTokens: 1000, AudioTokens: 2000},
+	}
+
+	// (1000*1 + 500*10 + 1000*2 + 2000*20) / 1M
+	expected := 0.048
+	if cost := pricing.Cost(usage); math.Abs(cost-expected) > 1e-9 {
+		t.Errorf("Expected cost %f, got %f", expected, cost)
+	}
+
+	// Cached input tokens are billed at the cached rates instead: (200*1 + 800*0.1 +
+	// 400*10 + 100*1 + 1000*2 + 2000*20) / 1M
+	usage.InputTokenDetails.CachedTokens = 900
+	usage.InputTokenDetails.CachedTokensDetails = &openairt.CachedTokensDetails{TextTokens: 800, AudioTokens: 100}
+	expected = 0.04638
+	if cost := pricing.Cost(usage); math.Abs(cost-expected) > 1e-9 {
+		t.Errorf("Expected cost with cached input %f, got %f", expected, cost)
+	}
+
+	// Without the breakdown, cached tokens are counted as text first
+	usage.InputTokenDetails.CachedTokensDetails = nil
+	expected = (100*1 + 900*0.1 + 500*10 + 1000*2 + 2000*20) / 1e6
+	if cost := pricing.Cost(usage); math.Abs(cost-expected) > 1e-9 {
+		t.Errorf("Expected cost with cached text input %f, got %f", expected, cost)
+	}
+
+	if cost := pricing.Cost(nil); cost != 0 {
+		t.Errorf("Expected zero cost for nil usage, got %f", cost)
+	}
+}
+
+func TestSessionBudgetTokenCeiling(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewBudgetTracker(BudgetConfig{SessionTokens: 100})
+	session := tracker.NewSession("1.2.3.4")
+
+	session.RecordRealtime(ctx, &openairt.TokenUsage{InputTokens: 40, OutputTokens: 40})
+	if scope := session.Exceeded(ctx); scope != "" {
+		t.Errorf("Expected budget not exceeded, got scope %q", scope)
+	}
+
+	session.RecordRealtime(ctx, &openairt.TokenUsage{InputTokens: 10, OutputTokens: 10})
+	if scope := session.Exceeded(ctx); scope != BudgetScopeSession {
+		t.Errorf("Expected session scope, got %q", scope)
+	}
+
+	// A new session from another IP is unaffected
+	if scope := tracker.NewSession("5.6.7.8").Exceeded(ctx); scope != "" {
+		t.Errorf("Expected fresh session within budget, got %q", scope)
+	}
+}
+
+func TestSessionBudgetIPAndGlobalCeilings(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewBudgetTracker(BudgetConfig{
+		IPDailyCostUSD:    0.01,
+		GlobalDailyTokens: 1000,
+		Pricing:           TokenPricing{TextOutputPerMTok: 100},
+	})
+
+	// $0.01 spent across two sessions from the same IP
+	tracker.NewSession("1.2.3.4").RecordRealtime(ctx, &openairt.TokenUsage{OutputTokens: 50})
+	second := tracker.NewSession("1.2.3.4")
+	second.RecordRealtime(ctx, &openairt.TokenUsage{OutputTokens: 50})
+	if scope := second.Exceeded(ctx); scope != BudgetScopeIP {
+		t.Errorf("Expected ip scope, got %q", scope)
+	}
+
+	other := tracker.NewSession("5.6.7.8")
+	if scope := other.Exceeded(ctx); scope != "" {
+		t.Errorf("Expected other IP within budget, got %q", scope)
+	}
+
+	other.RecordRealtime(ctx, &openairt.TokenUsage{InputTokens: 900})
+	if scope := other.Exceeded(ctx); scope != BudgetScopeGlobal {
+		t.Errorf("Expected global scope, got %q", scope)
+	}
+}
+
+func TestBudgetTrackerDailyReset(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewBudgetTracker(BudgetConfig{GlobalDailyTokens: 10})
+	day := time.Date(2025, 1, 1, 23, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return day }
+
+	session := tracker.NewSession("1.2.3.4")
+	session.RecordRealtime(ctx, &openairt.TokenUsage{InputTokens: 10})
+	if scope := session.Exceeded(ctx); scope != BudgetScopeGlobal {
+		t.Fatalf("Expected global scope, got %q", scope)
+	}
+
+	day = day.Add(2 * time.Hour)
+	if scope := session.Exceeded(ctx); scope != "" {
+		t.Errorf("Expected daily budget to reset, got %q", scope)
+	}
+}
+
+func TestNilSessionBudget(t *testing.T) {
+	ctx := context.Background()
+	var tracker *BudgetTracker
+	session := tracker.NewSession("1.2.3.4")
+
+	session.RecordRealtime(ctx, &openairt.TokenUsage{InputTokens: 10})
+	session.RecordLocal(Usage{InputTokens: 10})
+	if scope := session.Exceeded(ctx); scope != "" {
+		t.Errorf("Nil session should never exceed, got %q", scope)
+	}
+	if tracker.FallbackToLocal() {
+		t.Error("Nil tracker should not fall back to local")
+	}
+}
+
+func TestBudgetTrackersShareLimitStore(t *testing.T) {
+	ctx := context.Background()
+	store := limits.NewMemoryStore()
+	config := BudgetConfig{IPDailyTokens: 100}
+
+	// Two replicas sharing a store see each other's spend
+	first := NewBudgetTracker(config)
+	first.SetLimitStore(store)
+	second := NewBudgetTracker(config)
+	second.SetLimitStore(store)
+
+	first.NewSession("1.2.3.4").RecordRealtime(ctx, &openairt.TokenUsage{InputTokens: 60, OutputTokens: 40})
+	if scope := second.NewSession("1.2.3.4").Exceeded(ctx); scope != BudgetScopeIP {
+		t.Errorf("Expected ip scope on the other replica, got %q", scope)
+	}
+}
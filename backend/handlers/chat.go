@@ -1,112 +1,296 @@
 package handlers
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"christianmoore.me/avatar-backend/breaker"
+	"christianmoore.me/avatar-backend/cache"
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/embeddings"
+	"christianmoore.me/avatar-backend/guardrails"
+	"christianmoore.me/avatar-backend/health"
+	"christianmoore.me/avatar-backend/limits"
+	"christianmoore.me/avatar-backend/metrics"
+	"christianmoore.me/avatar-backend/moderation"
+	"christianmoore.me/avatar-backend/origins"
+	"christianmoore.me/avatar-backend/persona"
+	"christianmoore.me/avatar-backend/recording"
 	openairt "github.com/WqyJh/go-openai-realtime/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"golang.org/x/time/rate"
 )
 
 // Security and validation constants
+// Message length, rate, connection limits and timeouts are configurable (see config.Config)
 const (
 	// Message validation
-	MaxMessageLength = 4000 // Maximum characters per message (reasonable for GPT-4)
-	MinMessageLength = 1    // Minimum characters per message
-
-	// Rate limiting
-	MessageRateLimit = time.Second * 5 // 1 message per 5 seconds
-	MessageBurst     = 3               // Allow burst of 3 messages
+	MinMessageLength = 1 // Minimum characters per message
 
 	// Connection limits
-	MaxConnectionsPerIP = 10               // Maximum concurrent connections per IP address (shared by Traefik proxy)
-	ConnectionTimeout   = 10 * time.Minute // WebSocket connection timeout
-	PingInterval        = 1 * time.Minute  // Ping interval for keepalive
-)
-
-// Connection tracking for per-IP limits
-var (
-	connectionsMutex sync.Mutex
-	connectionsPerIP = make(map[string]int)
-)
+	PingInterval       = 1 * time.Minute // Ping interval for keepalive
+	ConnectionLeaseTTL = 2 * time.Minute // Minimum connection slot lease, refreshed with each heartbeat
 
-var upgrader = websocket.Upgrader{
-	// Buffer sizes optimized for real-time audio streaming
-	ReadBufferSize:  8192, // 8KB for incoming audio chunks
-	WriteBufferSize: 8192, // 8KB for outgoing audio chunks
+	// Heartbeats a connection slot lease outlives, so a late tick doesn't release a live connection
+	ConnectionLeaseHeartbeats = 3
 
-	// Disable compression for real-time audio (compression adds latency)
-	EnableCompression: false,
-
-	// Handshake timeout
-	HandshakeTimeout: 10 * time.Second,
+	// Time for force-closed sessions to clean up after the drain deadline
+	DrainForceCloseGrace = 2 * time.Second
+)
 
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from localhost and christianmoore.me
-		origin := r.Header.Get("Origin")
-		return origin == "http://localhost:5173" ||
-			origin == "http://localhost:3000" ||
-			origin == "https://christianmoore.me"
-	},
-}
+// errClientGone marks a turn that failed on the client's side (a write to the client
+// failed or the session ended), which says nothing about the backend's health
+var errClientGone = errors.New("client gone")
 
 type ChatHandler struct {
-	apiKey              string
-	model               string
-	systemPrompt        string
-	authHandler         *AuthHandler
-	useLocalPipeline    bool
+	cfg                  *config.Config
+	upgrader             websocket.Upgrader
+	authHandler          *AuthHandler
 	localPipelineHandler *LocalPipelineHandler
+	budget               *BudgetTracker
+	limits               limits.LimitStore
+	personas             *persona.Store
+	health               *health.Checker
+	breakers             map[string]*breaker.Breaker // Per backend, shared by all sessions
+	realtimeDialer       openairt.WebSocketDialer    // nil uses the library's default dialer
+	responses            *cache.Responses            // Answers to first-turn questions, nil when disabled
+	semantic             *cache.Semantic             // Cached questions by embedding, nil when disabled
+	embedder             *embeddings.Client          // nil when the semantic cache is off
+	moderator            moderation.Checker          // Screens visitor messages, nil allows everything
+	guard                *guardrails.Guard           // Shared with the local pipeline, nil when guardrails are off
+
+	// Drain state for graceful shutdown (see Drain)
+	sessionsMu sync.Mutex
+	sessions   int
+	draining   bool
+	drainCh    chan struct{} // Closed when draining starts
+	forceCh    chan struct{} // Closed when the drain deadline passes
+	idleCh     chan struct{} // Closed when the last session ends during a drain
 }
 
-func NewChatHandler(apiKey, model, systemPrompt string, authHandler *AuthHandler, useLocalPipeline bool, localLLMURL string, ttsURL string, ttsVoice string, ttsSpeed string) (*ChatHandler, error) {
+func NewChatHandler(cfg *config.Config, authHandler *AuthHandler, budget *BudgetTracker, personas *persona.Store, originPolicy *origins.Policy) (*ChatHandler, error) {
 	handler := &ChatHandler{
-		apiKey:           apiKey,
-		model:            model,
-		systemPrompt:     systemPrompt,
-		authHandler:      authHandler,
-		useLocalPipeline: useLocalPipeline,
+		cfg:         cfg,
+		authHandler: authHandler,
+		budget:      budget,
+		limits:      limits.NewMemoryStore(),
+		personas:    personas,
+		breakers: map[string]*breaker.Breaker{
+			BackendRealtime: breaker.New(BackendRealtime, cfg.BreakerFailureThreshold, cfg.BreakerCooldown),
+			BackendLocal:    breaker.New(BackendLocal, cfg.BreakerFailureThreshold, cfg.BreakerCooldown),
+		},
+		drainCh: make(chan struct{}),
+		forceCh: make(chan struct{}),
+		idleCh:  make(chan struct{}),
+		upgrader: websocket.Upgrader{
+			// Buffer sizes optimized for real-time audio streaming
+			ReadBufferSize:  8192, // 8KB for incoming audio chunks
+			WriteBufferSize: 8192, // 8KB for outgoing audio chunks
+
+			// Disable compression for real-time audio (compression adds latency)
+			EnableCompression: false,
+
+			// Handshake timeout
+			HandshakeTimeout: 10 * time.Second,
+
+			CheckOrigin: func(r *http.Request) bool {
+				// Browsers always send Origin; non-browser clients (API key integrations)
+				// don't and aren't subject to cross-site WebSocket hijacking
+				origin := r.Header.Get("Origin")
+				if origin == "" {
+					return true
+				}
+				// Allow connections from the same origins as CORS
+				return originPolicy.Allow(origin, "websocket")
+			},
+		},
 	}
 
-	// Initialize local pipeline if enabled
-	if useLocalPipeline {
+	// One guard checks answers from every backend
+	handler.guard = guardrails.New(cfg)
+
+	// Initialize local pipeline if it's a failover backend (or the fallback once the paid budget runs out)
+	if cfg.UsesBackend(BackendLocal) || budget.FallbackToLocal() {
 		log.Printf("Initializing local pipeline (LLM + TTS) mode")
-		localHandler, err := NewLocalPipelineHandler(localLLMURL, systemPrompt, ttsURL, ttsVoice, ttsSpeed)
+		localHandler, err := NewLocalPipelineHandler(cfg, personas, handler.guard)
 		if err != nil {
 			return nil, fmt.Errorf("failed to initialize local pipeline: %w", err)
 		}
 		handler.localPipelineHandler = localHandler
 		log.Printf("Local pipeline initialized successfully")
-	} else {
-		log.Printf("Using OpenAI Realtime API mode")
+	}
+	log.Printf("Backend order: %s", strings.Join(cfg.Backends, " -> "))
+
+	if cfg.ResponseCacheTTL > 0 {
+		handler.responses = cache.NewResponses(cfg.ResponseCacheTTL, cfg.ResponseCacheMaxEntries, cfg.ResponseCacheMaxBytes)
+		log.Printf("Response cache enabled: TTL %v, up to %d answers / %d bytes", cfg.ResponseCacheTTL, cfg.ResponseCacheMaxEntries, cfg.ResponseCacheMaxBytes)
+
+		if cfg.SemanticCacheMode == "shadow" || cfg.SemanticCacheMode == "on" {
+			embedder, err := embeddings.New(cfg.EmbeddingsURL, cfg.EmbeddingsModel, cfg.EmbeddingsUpstream)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize embeddings client: %w", err)
+			}
+			handler.embedder = embedder
+			handler.semantic = cache.NewSemantic(cfg.SemanticCacheMaxEntries)
+			log.Printf("Semantic cache enabled (%s mode): similarity threshold %g, model %s", cfg.SemanticCacheMode, cfg.SemanticCacheThreshold, cfg.EmbeddingsModel)
+		}
+	}
+
+	moderator, err := moderation.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize moderation: %w", err)
+	}
+	if len(moderator) > 0 {
+		handler.moderator = moderator
 	}
 
 	return handler, nil
 }
 
+// SetLimitStore replaces the default in-memory limit store (e.g. with a shared Redis store)
+func (h *ChatHandler) SetLimitStore(store limits.LimitStore) {
+	h.limits = store
+}
+
+// SetRealtimeDialer replaces how Realtime API connections are opened (e.g. with a
+// recording's replay dialer in tests)
+func (h *ChatHandler) SetRealtimeDialer(dialer openairt.WebSocketDialer) {
+	h.realtimeDialer = dialer
+}
+
+// SetModerator replaces the moderation checks built from the config, e.g. with a
+// Chain that adds checks of its own. nil disables moderation.
+func (h *ChatHandler) SetModerator(moderator moderation.Checker) {
+	h.moderator = moderator
+}
+
+// ResponseCache returns the cache of first-turn answers (nil when disabled)
+func (h *ChatHandler) ResponseCache() *cache.Responses {
+	return h.responses
+}
+
+// leaseTTL returns how long a connection slot lease lasts. Leases are only refreshed by
+// the heartbeat ticker, so the TTL grows with HeartbeatInterval.
+func (h *ChatHandler) leaseTTL() time.Duration {
+	return max(ConnectionLeaseTTL, ConnectionLeaseHeartbeats*h.cfg.HeartbeatInterval)
+}
+
+// beginSession registers a new WebSocket session, refusing it once draining has started
+func (h *ChatHandler) beginSession() bool {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	if h.draining {
+		return false
+	}
+	h.sessions++
+	return true
+}
+
+// endSession unregisters a session, signalling Drain when it was the last one
+func (h *ChatHandler) endSession() {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	h.sessions--
+	if h.draining && h.sessions == 0 {
+		close(h.idleCh)
+	}
+}
+
+// Draining reports whether the handler has stopped accepting new sessions
+func (h *ChatHandler) Draining() bool {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	return h.draining
+}
+
+// Drain stops accepting new WebSocket connections, tells connected clients to reconnect
+// elsewhere and waits for their in-flight responses to finish. Sessions still open when
+// ctx expires are closed immediately and ctx's error is returned.
+func (h *ChatHandler) Drain(ctx context.Context) error {
+	h.sessionsMu.Lock()
+	if h.draining {
+		h.sessionsMu.Unlock()
+		return nil
+	}
+	h.draining = true
+	close(h.drainCh)
+	active := h.sessions
+	if active == 0 {
+		close(h.idleCh)
+	}
+	h.sessionsMu.Unlock()
+
+	log.Printf("Draining %d WebSocket sessions", active)
+	select {
+	case <-h.idleCh:
+		log.Printf("All WebSocket sessions drained")
+		return nil
+	case <-ctx.Done():
+		log.Printf("Drain deadline reached, closing remaining sessions")
+		close(h.forceCh)
+		// Give closed sessions a moment to release their connection slots
+		select {
+		case <-h.idleCh:
+		case <-time.After(DrainForceCloseGrace):
+		}
+		return ctx.Err()
+	}
+}
+
+// Backend names reported in logs, metrics and server events
+const (
+	BackendRealtime = "realtime"
+	BackendLocal    = "local"
+)
+
 // ClientMessage represents messages from the frontend
 type ClientMessage struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
+
+	// Output modality for this message ("audio" or "text"); defaults to the session's
+	Modality string `json:"modality,omitempty"`
 }
 
+// ErrorCodeRateLimited marks an error sent because the message rate limit was hit; the
+// message can be sent again after a pause
+const ErrorCodeRateLimited = "rate_limited"
+
 // ServerMessage represents messages to the frontend
 type ServerMessage struct {
 	Type  string `json:"type"`
 	Text  string `json:"text,omitempty"`
 	Audio string `json:"audio,omitempty"` // base64 encoded audio
 	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"` // Machine-readable reason for some errors, e.g. ErrorCodeRateLimited
+
+	// Backend answering from now on (backend_switched)
+	Backend string `json:"backend,omitempty"`
+
+	// Effective session settings (session_created)
+	Session *SessionOptions `json:"session,omitempty"`
 }
 
 func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
+	// Refuse new sessions while shutting down so clients reconnect to another replica
+	if !h.beginSession() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Server is shutting down",
+		})
+		return
+	}
+	defer h.endSession()
+
 	// Verify JWT token from Authorization header, Sec-WebSocket-Protocol, or query param
 	var tokenString string
 	var wsProtocol string // Store protocol to echo back in response
@@ -124,48 +308,97 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		tokenString = queryToken
 	}
 
-	// Verify JWT token
+	// Verify API key (trusted integrations) or JWT token
+	var apiKeyName string // Set when the session is attributed to an API key
+	var sessionID string  // JWT ID, keys the message rate limit across reconnects
 	if h.authHandler != nil {
-		_, err := h.authHandler.VerifyJWT(tokenString)
-		if err != nil {
-			log.Printf("JWT verification failed for IP %s: %v", c.ClientIP(), err)
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Authentication required",
-			})
-			return
+		rawKey := apiKeyFromRequest(c)
+		if rawKey == "" && strings.HasPrefix(tokenString, APIKeyPrefix) {
+			rawKey = tokenString
 		}
-		log.Printf("JWT verified for IP %s", c.ClientIP())
+
+		if rawKey != "" {
+			// Only messages count against the key's limits, not the connection
+			key, err := h.authHandler.apiKeys.Verify(rawKey, ScopeChat)
+			if err != nil {
+				log.Printf("API key rejected for IP %s: %v", c.ClientIP(), err)
+				c.JSON(apiKeyErrorStatus(err), gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			apiKeyName = key.Name
+			log.Printf("API key %s verified for IP %s", apiKeyName, c.ClientIP())
+		} else {
+			claims, err := h.authHandler.VerifyJWT(tokenString)
+			if err != nil {
+				log.Printf("JWT verification failed for IP %s: %v", c.ClientIP(), err)
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Authentication required",
+				})
+				return
+			}
+			apiKeyName = claims.APIKey
+			sessionID = claims.ID
+			if apiKeyName != "" {
+				// The key's limits apply to the session, so it must still be valid
+				if _, err := h.authHandler.apiKeys.Lookup(apiKeyName); err != nil {
+					log.Printf("API key %s of JWT rejected for IP %s: %v", apiKeyName, c.ClientIP(), err)
+					c.JSON(apiKeyErrorStatus(err), gin.H{
+						"error": err.Error(),
+					})
+					return
+				}
+				log.Printf("JWT verified for API key %s (IP %s)", apiKeyName, c.ClientIP())
+			} else {
+				log.Printf("JWT verified for IP %s", c.ClientIP())
+			}
+		}
+	}
+
+	// Snapshot the persona so a reload mid-conversation doesn't change it
+	sessionPersona := h.personas.Current()
+
+	// Apply the client's session overrides, refusing any outside the allowlists
+	sessionOptions, err := newSessionOptions(h.cfg, sessionPersona, c.Request.URL.Query())
+	if err != nil {
+		log.Printf("Rejected session options from IP %s: %v", c.ClientIP(), err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
 	// Get client IP (respects X-Forwarded-For from trusted proxies)
 	clientIP := c.ClientIP()
 
-	// Check concurrent connection limit per IP
-	connectionsMutex.Lock()
-	currentConnections := connectionsPerIP[clientIP]
-	if currentConnections >= MaxConnectionsPerIP {
-		connectionsMutex.Unlock()
-		log.Printf("Connection limit exceeded for IP %s (%d/%d)", clientIP, currentConnections, MaxConnectionsPerIP)
+	// Check concurrent connection limit per IP (shared across replicas when using Redis)
+	// Store errors fail open so a Redis outage doesn't block all visitors
+	connKey := "conn:ip:" + clientIP
+	lease, currentConnections, err := h.limits.AcquireConnection(c.Request.Context(), connKey, h.cfg.MaxConnectionsPerIP, h.leaseTTL())
+	if errors.Is(err, limits.ErrLimitExceeded) {
+		log.Printf("Connection limit exceeded for IP %s (%d/%d)", clientIP, currentConnections, h.cfg.MaxConnectionsPerIP)
 		c.JSON(http.StatusTooManyRequests, gin.H{
 			"error": "Too many concurrent connections from your IP address",
 		})
 		return
+	} else if err != nil {
+		log.Printf("Warning: limit store error, allowing connection: %v", err)
 	}
-	connectionsPerIP[clientIP]++
-	connectionsMutex.Unlock()
 
-	// Ensure connection count is decremented on exit
+	// Ensure connection slot is released on exit
 	defer func() {
-		connectionsMutex.Lock()
-		connectionsPerIP[clientIP]--
-		if connectionsPerIP[clientIP] <= 0 {
-			delete(connectionsPerIP, clientIP) // Clean up map entry
+		if lease == "" {
+			return
+		}
+		remaining, err := h.limits.ReleaseConnection(context.Background(), connKey, lease)
+		if err != nil {
+			log.Printf("Warning: failed to release connection slot for IP %s: %v", clientIP, err)
 		}
-		connectionsMutex.Unlock()
-		log.Printf("Connection closed for IP %s (remaining: %d)", clientIP, connectionsPerIP[clientIP])
+		log.Printf("Connection closed for IP %s (remaining: %d)", clientIP, remaining)
 	}()
 
-	log.Printf("New WebSocket connection from IP %s (%d/%d concurrent)", clientIP, currentConnections+1, MaxConnectionsPerIP)
+	log.Printf("New WebSocket connection from IP %s (%d/%d concurrent)", clientIP, currentConnections, h.cfg.MaxConnectionsPerIP)
 
 	// Upgrade connection to WebSocket
 	// Must echo back Sec-WebSocket-Protocol if client sent it, or browser closes with 1006
@@ -174,7 +407,7 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		responseHeader = http.Header{}
 		responseHeader.Set("Sec-WebSocket-Protocol", wsProtocol)
 	}
-	clientWS, err := upgrader.Upgrade(c.Writer, c.Request, responseHeader)
+	clientWS, err := h.upgrader.Upgrade(c.Writer, c.Request, responseHeader)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
 		return
@@ -193,34 +426,72 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		}
 	}
 
-	// Create per-connection rate limiter (1 message per 5 seconds, burst of 3)
-	rateLimiter := rate.NewLimiter(rate.Every(MessageRateLimit), MessageBurst)
-	log.Printf("Rate limiter initialized: 1 message per %v, burst %d", MessageRateLimit, MessageBurst)
+	log.Printf("Session using prompt version %s, voice %s, %s output", sessionPersona.Version, sessionOptions.Voice, sessionOptions.OutputModality)
+
+	// Record the session for replay when RECORD_DIR is set. A nil recorder records nothing.
+	var recorder *recording.Recorder
+	if h.cfg.RecordDir != "" {
+		query := c.Request.URL.Query()
+		query.Del("token")
+		recorder, err = recording.Create(h.cfg.RecordDir, recording.SessionInfo{
+			StartedAt:     time.Now(),
+			PromptVersion: sessionPersona.Version,
+			Backends:      h.cfg.Backends,
+			Query:         query.Encode(),
+		})
+		if err != nil {
+			log.Printf("Warning: session will not be recorded: %v", err)
+		} else {
+			log.Printf("Recording session to %s", recorder.Path())
+		}
+		defer recorder.Close()
+	}
+
+	// Track token usage and spend for this connection
+	budget := h.budget.NewSession(clientIP)
+	budgetFallbackNotified := false
+
+	// Message rate limit (1 message per 5 seconds, burst of 3), keyed by JWT session so
+	// reconnecting doesn't reset it; falls back to the client IP when tokens carry no ID
+	rateKey := "msg:ip:" + clientIP
+	if sessionID != "" {
+		rateKey = "msg:session:" + sessionID
+	}
+	messageLimit := limits.Limit{Every: h.cfg.MessageRateInterval, Burst: h.cfg.MessageBurst}
+	log.Printf("Rate limiter initialized: 1 message per %v, burst %d", h.cfg.MessageRateInterval, h.cfg.MessageBurst)
 
 	// Set connection timeout and deadlines
-	clientWS.SetReadDeadline(time.Now().Add(ConnectionTimeout))
-	clientWS.SetWriteDeadline(time.Now().Add(ConnectionTimeout))
-	log.Printf("Connection timeout set to %v", ConnectionTimeout)
+	clientWS.SetReadDeadline(time.Now().Add(h.cfg.ConnectionTimeout))
+	clientWS.SetWriteDeadline(time.Now().Add(h.cfg.ConnectionTimeout))
+	log.Printf("Connection timeout set to %v", h.cfg.ConnectionTimeout)
 
 	// Configure ping/pong for keepalive and detecting dead connections
 	clientWS.SetPingHandler(func(appData string) error {
 		// Reset read deadline on ping
-		clientWS.SetReadDeadline(time.Now().Add(ConnectionTimeout))
+		clientWS.SetReadDeadline(time.Now().Add(h.cfg.ConnectionTimeout))
 		return clientWS.WriteControl(websocket.PongMessage, []byte{}, time.Now().Add(10*time.Second))
 	})
 
 	clientWS.SetPongHandler(func(appData string) error {
 		// Reset read deadline on pong
-		clientWS.SetReadDeadline(time.Now().Add(ConnectionTimeout))
+		clientWS.SetReadDeadline(time.Now().Add(h.cfg.ConnectionTimeout))
 		return nil
 	})
 
-	ctx := context.Background()
+	// Cancelled when the connection closes or a drain runs out of time
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Whether a response is being generated, so draining waits for it to finish
+	var realtimeResponding, localResponding atomic.Bool
 
 	// OpenAI Realtime connection - lazy initialized on first message
 	var realtimeConn *openairt.Conn
 	var realtimeConnMutex sync.Mutex
-	var openaiReaderRunning bool
+
+	// Items for a first turn answered from the response cache, added to the Realtime
+	// conversation before the next Realtime turn
+	var cachedTurn []openairt.ConversationItemCreateEvent
 
 	// Helper function to connect/reconnect to OpenAI Realtime API
 	connectToOpenAI := func() error {
@@ -234,11 +505,17 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		}
 
 		// Create OpenAI Realtime client
-		client := openairt.NewClient(h.apiKey)
+		client := h.realtimeClient()
 
 		// Connect to OpenAI Realtime API
-		log.Printf("Connecting to OpenAI Realtime API with model: %s", h.model)
-		conn, err := client.Connect(ctx, openairt.WithModel(h.model))
+		log.Printf("Connecting to OpenAI Realtime API with model: %s", h.cfg.OpenAIModel)
+		connectCtx, cancelConnect := context.WithTimeout(ctx, h.cfg.RealtimeResponseTimeout)
+		defer cancelConnect()
+		dialer := h.realtimeDialer
+		if dialer == nil {
+			dialer = openairt.DefaultDialer()
+		}
+		conn, err := client.Connect(connectCtx, openairt.WithModel(h.cfg.OpenAIModel), openairt.WithDialer(recorder.Dialer(dialer)))
 		if err != nil {
 			log.Printf("Failed to connect to OpenAI Realtime API: %v", err)
 			return err
@@ -246,22 +523,11 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		realtimeConn = conn
 		log.Printf("Successfully connected to OpenAI Realtime API")
 
-		// Configure session with audio modality (includes text transcript)
+		// Configure session with the prompt and this connection's session options
 		// Audio streams through Cloudflare Tunnel which handles bandwidth better than HTTP proxy
 		sessionUpdate := openairt.SessionUpdateEvent{
 			Session: openairt.SessionUnion{
-				Realtime: &openairt.RealtimeSession{
-					Instructions: h.systemPrompt,
-					Audio: &openairt.RealtimeSessionAudio{
-						Output: &openairt.SessionAudioOutput{
-							Voice: openairt.VoiceCedar, // Masculine voice
-							// Note: Do NOT set Format field - causes audio distortion
-						},
-					},
-					OutputModalities: []openairt.Modality{
-						openairt.ModalityAudio, // Includes both audio and text transcript
-					},
-				},
+				Realtime: sessionOptions.realtimeSession(sessionPersona.Prompt),
 			},
 		}
 
@@ -276,6 +542,19 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		return nil
 	}
 
+	// closeRealtime drops the Realtime connection so the next Realtime turn reconnects
+	closeRealtime := func() {
+		realtimeConnMutex.Lock()
+		if realtimeConn != nil {
+			realtimeConn.Close()
+			realtimeConn = nil
+		}
+		realtimeConnMutex.Unlock()
+	}
+
+	// Receives a value when the Realtime API starts answering the pending response
+	responseStarted := make(chan struct{}, 1)
+
 	// Cleanup OpenAI connection on exit
 	defer func() {
 		realtimeConnMutex.Lock()
@@ -290,25 +569,37 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 	var doneOnce sync.Once
 	var wsMutex sync.Mutex // Protect WebSocket writes
 
+	// Collects the answer to a cacheable question as it's sent (see answerCapture)
+	var capture atomic.Pointer[answerCapture]
+
 	// Periodic heartbeat to keep connection alive through Cloudflare
 	// Note: Using application-level JSON heartbeats instead of WebSocket ping frames
 	// because Cloudflare may not properly forward WebSocket control frames
 	go func() {
-		ticker := time.NewTicker(30 * time.Second) // More frequent for Cloudflare's 100s timeout
+		ticker := time.NewTicker(h.cfg.HeartbeatInterval) // Default 30s, more frequent than Cloudflare's 100s timeout
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				wsMutex.Lock()
 				err := clientWS.WriteJSON(ServerMessage{Type: "heartbeat"})
+				if err == nil {
+					// Reset write deadline after successful heartbeat (under the lock, as
+					// the deadline is shared with concurrent response writes)
+					clientWS.SetWriteDeadline(time.Now().Add(h.cfg.ConnectionTimeout))
+				}
 				wsMutex.Unlock()
 				if err != nil {
 					log.Printf("Failed to send heartbeat: %v", err)
 					doneOnce.Do(func() { close(done) })
 					return
 				}
-				// Reset write deadline after successful heartbeat
-				clientWS.SetWriteDeadline(time.Now().Add(ConnectionTimeout))
+				// Keep this connection's slot alive in the limit store
+				if lease != "" {
+					if err := h.limits.RefreshConnection(ctx, connKey, lease, h.leaseTTL()); err != nil {
+						log.Printf("Warning: failed to refresh connection slot: %v", err)
+					}
+				}
 			case <-done:
 				return
 			}
@@ -319,6 +610,12 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 	sendJSON := func(msg ServerMessage) error {
 		wsMutex.Lock()
 		err := clientWS.WriteJSON(msg)
+		if err == nil {
+			recorder.Record(recording.SourceServer, msg) // Under the lock to keep the sent order
+			if cp := capture.Load(); cp != nil {
+				cp.add(msg)
+			}
+		}
 		wsMutex.Unlock()
 		if err != nil {
 			log.Printf("Error sending %s to client: %v", msg.Type, err)
@@ -326,19 +623,49 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		return err
 	}
 
-	// Function to start OpenAI reader goroutine
-	startOpenAIReader := func() {
-		if openaiReaderRunning {
+	// Tell the client which session settings are in effect
+	sendJSON(ServerMessage{
+		Type:    "session_created",
+		Session: &sessionOptions,
+	})
+
+	// speakPhrase follows a canned server message with its audio, when the phrase is
+	// spoken and was synthesized at startup. Audio is only sent for the audio modality.
+	speakPhrase := func(name, modality string) {
+		if modality != "audio" || h.localPipelineHandler == nil || !slices.Contains(h.cfg.SpokenPhrases, name) {
+			return
+		}
+		pcm, ok := h.localPipelineHandler.PhraseAudio(sessionPersona, h.cfg.Phrase(name))
+		if !ok {
+			log.Printf("Phrase %s has no synthesized audio yet, sending text only", name)
 			return
 		}
-		openaiReaderRunning = true
+		if streamPCM(pcm, sendJSON) == nil {
+			sendJSON(ServerMessage{Type: "audio_done"})
+		}
+	}
 
+	if greeting := h.cfg.Phrase(config.PhraseGreeting); greeting != "" {
+		sendJSON(ServerMessage{
+			Type: "greeting",
+			Text: greeting,
+		})
+		speakPhrase(config.PhraseGreeting, sessionOptions.OutputModality)
+	}
+
+	// Function to start the reader goroutine for a new OpenAI connection. Each connection
+	// gets its own reader, so a reader of a dropped connection can't touch its replacement.
+	startOpenAIReader := func(conn *openairt.Conn) {
 		go func() {
 			defer func() {
 				if r := recover(); r != nil {
 					log.Printf("Recovered from panic in OpenAI handler: %v", r)
 				}
-				openaiReaderRunning = false
+				realtimeConnMutex.Lock()
+				if realtimeConn == conn {
+					realtimeResponding.Store(false)
+				}
+				realtimeConnMutex.Unlock()
 			}()
 
 			for {
@@ -346,31 +673,37 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 				case <-done:
 					return
 				default:
-					realtimeConnMutex.Lock()
-					conn := realtimeConn
-					realtimeConnMutex.Unlock()
-
-					if conn == nil {
-						log.Printf("OpenAI connection is nil, reader exiting")
-						return
-					}
-
 					event, err := conn.ReadMessage(ctx)
 					if err != nil {
 						log.Printf("Error receiving from OpenAI: %v", err)
 						// Mark connection as closed so next message will reconnect
 						realtimeConnMutex.Lock()
-						if realtimeConn != nil {
+						if realtimeConn == conn {
 							realtimeConn.Close()
 							realtimeConn = nil
+							realtimeResponding.Store(false)
 						}
 						realtimeConnMutex.Unlock()
+						capture.Store(nil) // The answer is incomplete
 						// Don't send error to client - they'll reconnect on next message
 						return
 					}
 
+					// Signal that the pending response has started (see runRealtimeTurn)
+					switch event.(type) {
+					case openairt.ResponseCreatedEvent, openairt.ResponseOutputTextDeltaEvent,
+						openairt.ResponseOutputAudioTranscriptDeltaEvent, openairt.ResponseOutputAudioDeltaEvent:
+						select {
+						case responseStarted <- struct{}{}:
+						default:
+						}
+					}
+
 					// Handle different event types
 					switch e := event.(type) {
+					case openairt.ResponseCreatedEvent:
+						// Response accepted; output deltas follow
+
 					case openairt.ResponseOutputTextDeltaEvent:
 						// Send text delta to client (text-only mode)
 						log.Printf("Assistant response delta: %s", e.Delta)
@@ -384,6 +717,8 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 					case openairt.ResponseOutputTextDoneEvent:
 						// Text is complete
 						log.Printf("Assistant response completed")
+						// Realtime answers have already been sent, so guardrails only monitor them
+						reportViolations(BackendRealtime, h.guard.Check(e.Text, sessionPersona), false)
 						if err := sendJSON(ServerMessage{
 							Type: "text_done",
 						}); err != nil {
@@ -412,6 +747,7 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 					case openairt.ResponseOutputAudioTranscriptDoneEvent:
 						// Text is complete (audio mode)
 						log.Printf("Assistant response completed (audio mode)")
+						reportViolations(BackendRealtime, h.guard.Check(e.Transcript, sessionPersona), false)
 						if err := sendJSON(ServerMessage{
 							Type: "text_done",
 						}); err != nil {
@@ -444,7 +780,12 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 						}
 
 					case openairt.ResponseDoneEvent:
-						// Response complete
+						// Response complete - account usage against budgets
+						budget.RecordRealtime(ctx, e.Response.Usage)
+						realtimeResponding.Store(false)
+						if cp := capture.Swap(nil); cp != nil && e.Response.Status == openairt.ResponseStatusCompleted {
+							h.storeAnswer(cp)
+						}
 						if err := sendJSON(ServerMessage{
 							Type: "response_done",
 						}); err != nil {
@@ -464,6 +805,156 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		}()
 	}
 
+	// Backend that answered the previous turn, starting with the preferred one
+	currentBackend := h.cfg.Backends[0]
+
+	// Only the first question is answered from (and stored in) the response cache, as
+	// later answers depend on the conversation so far
+	firstTurn := true
+
+	// runLocalTurn answers through the local LLM + TTS pipeline. started reports whether
+	// any output reached the client (after which the turn can't fail over).
+	runLocalTurn := func(message, modality string) (started bool, err error) {
+		if h.localPipelineHandler == nil {
+			return false, errors.New("local pipeline not configured")
+		}
+		log.Printf("Routing to local pipeline")
+		send := func(msg ServerMessage) error {
+			started = true
+			if err := sendJSON(msg); err != nil {
+				return fmt.Errorf("%w: %v", errClientGone, err)
+			}
+			return nil
+		}
+		localResponding.Store(true)
+		defer localResponding.Store(false)
+		err = h.localPipelineHandler.HandleLocalPipeline(ctx, sessionPersona, message, modality, clientWS, nil, send, budget)
+		if err != nil && ctx.Err() != nil {
+			err = fmt.Errorf("%w: %v", errClientGone, err)
+		}
+		return started, err
+	}
+
+	// runRealtimeTurn sends the message to the Realtime API and waits (up to
+	// RealtimeResponseTimeout) for the response to start; the reader streams the rest
+	runRealtimeTurn := func(message, modality string) (started bool, err error) {
+		// Lazy connect: establish connection on first message or reconnect if lost
+		realtimeConnMutex.Lock()
+		needsConnect := realtimeConn == nil
+		realtimeConnMutex.Unlock()
+
+		if needsConnect {
+			log.Printf("Establishing OpenAI connection for message...")
+			if err := connectToOpenAI(); err != nil {
+				return false, fmt.Errorf("connect: %w", err)
+			}
+		}
+
+		realtimeConnMutex.Lock()
+		conn := realtimeConn
+		realtimeConnMutex.Unlock()
+		if conn == nil {
+			return false, errors.New("connection lost")
+		}
+		if needsConnect {
+			// Start the reader goroutine for this new connection
+			startOpenAIReader(conn)
+		}
+
+		// Bring the conversation up to date with a turn served from the cache
+		for _, item := range cachedTurn {
+			if err := conn.SendMessage(ctx, item); err != nil {
+				closeRealtime()
+				return false, fmt.Errorf("send cached turn: %w", err)
+			}
+		}
+		cachedTurn = nil
+
+		// Create conversation item with user message (use sanitized input)
+		item := openairt.ConversationItemCreateEvent{
+			Item: openairt.MessageItemUnion{
+				User: &openairt.MessageItemUser{
+					Content: []openairt.MessageContentInput{
+						{
+							Type: openairt.MessageContentTypeInputText,
+							Text: message,
+						},
+					},
+				},
+			},
+		}
+		if err := conn.SendMessage(ctx, item); err != nil {
+			// Connection might be dead, clear it so next message reconnects
+			closeRealtime()
+			return false, fmt.Errorf("send message: %w", err)
+		}
+
+		// Discard a stale start signal from an earlier response, then request a response
+		select {
+		case <-responseStarted:
+		default:
+		}
+		realtimeResponding.Store(true)
+		// The modality is set per response so the client can switch without reconnecting
+		response := openairt.ResponseCreateEvent{
+			Response: openairt.ResponseCreateParams{
+				OutputModalities: []openairt.Modality{openairt.Modality(modality)},
+			},
+		}
+		if err := conn.SendMessage(ctx, response); err != nil {
+			realtimeResponding.Store(false)
+			closeRealtime()
+			return false, fmt.Errorf("request response: %w", err)
+		}
+
+		select {
+		case <-responseStarted:
+			return true, nil
+		case <-time.After(h.cfg.RealtimeResponseTimeout):
+			// Drop the connection so a late response can't interleave with the fallback
+			realtimeResponding.Store(false)
+			closeRealtime()
+			return false, fmt.Errorf("no response within %v", h.cfg.RealtimeResponseTimeout)
+		case <-ctx.Done():
+			return false, fmt.Errorf("%w: %v", errClientGone, ctx.Err())
+		}
+	}
+
+	// On drain, ask the client to reconnect elsewhere, let any in-flight response finish,
+	// then close with 1012 (service restart). Closing the socket ends the read loop below.
+	go func() {
+		select {
+		case <-h.drainCh:
+		case <-done:
+			return
+		}
+		sendJSON(ServerMessage{
+			Type: "server_draining",
+			Text: "Server is restarting, reconnecting shortly.",
+		})
+
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for realtimeResponding.Load() || localResponding.Load() {
+			select {
+			case <-ticker.C:
+			case <-h.forceCh:
+				log.Printf("Drain deadline reached with a response in flight for IP %s", clientIP)
+				cancel()
+			case <-done:
+				return
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		clientWS.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server draining"),
+			time.Now().Add(time.Second))
+		clientWS.Close()
+	}()
+
 	// Handle messages from client
 	for {
 		var msg ClientMessage
@@ -478,39 +969,64 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 		}
 
 		log.Printf("Received message from client: type=%s", msg.Type)
+		// Heartbeat acks depend on timing, so they're left out of recordings
+		if msg.Type != "heartbeat_ack" {
+			recorder.Record(recording.SourceClient, msg)
+		}
 
 		// Validate message type
 		switch msg.Type {
 		case "message":
+			// Don't start new responses while draining; the client reconnects elsewhere
+			if h.Draining() {
+				sendJSON(ServerMessage{
+					Type:  "server_draining",
+					Error: "Server is restarting, please send your message again in a moment.",
+				})
+				continue
+			}
+
 			// Check rate limit BEFORE processing
-			if !rateLimiter.Allow() {
+			// API key sessions are limited by the key's own rate and daily quota instead
+			if apiKeyName != "" {
+				if err := h.authHandler.apiKeys.Use(apiKeyName); err != nil {
+					log.Printf("API key %s message rejected: %v", apiKeyName, err)
+					refusal := ServerMessage{
+						Type:  "error",
+						Error: err.Error(),
+					}
+					if errors.Is(err, ErrAPIKeyRateLimited) {
+						refusal.Code = ErrorCodeRateLimited
+					}
+					sendJSON(refusal)
+					continue
+				}
+			} else if allowed, err := h.limits.Allow(ctx, rateKey, messageLimit); err != nil {
+				log.Printf("Warning: limit store error, allowing message: %v", err)
+			} else if !allowed {
 				log.Printf("Rate limit exceeded for client")
 				sendJSON(ServerMessage{
 					Type:  "error",
-					Error: "Rate limit exceeded. Please wait before sending another message.",
+					Error: h.cfg.Phrase(config.PhraseRateLimit),
+					Code:  ErrorCodeRateLimited,
 				})
+				speakPhrase(config.PhraseRateLimit, cmp.Or(msg.Modality, sessionOptions.OutputModality))
 				continue
 			}
 
 			// Validate message length
 			messageLen := len(msg.Message)
-			if messageLen < MinMessageLength || messageLen > MaxMessageLength {
-				log.Printf("Invalid message length: %d (min: %d, max: %d)", messageLen, MinMessageLength, MaxMessageLength)
+			if messageLen < MinMessageLength || messageLen > h.cfg.MaxMessageLength {
+				log.Printf("Invalid message length: %d (min: %d, max: %d)", messageLen, MinMessageLength, h.cfg.MaxMessageLength)
 				sendJSON(ServerMessage{
 					Type:  "error",
-					Error: fmt.Sprintf("Message must be between %d and %d characters", MinMessageLength, MaxMessageLength),
+					Error: fmt.Sprintf("Message must be between %d and %d characters", MinMessageLength, h.cfg.MaxMessageLength),
 				})
 				continue
 			}
 
 			// Sanitize input - trim whitespace and remove control characters
-			sanitized := strings.TrimSpace(msg.Message)
-			sanitized = strings.Map(func(r rune) rune {
-				if r < 32 && r != '\n' && r != '\t' {
-					return -1 // Remove control characters
-				}
-				return r
-			}, sanitized)
+			sanitized := sanitizeMessage(msg.Message)
 
 			// Validate sanitized message is not empty
 			if len(sanitized) < MinMessageLength {
@@ -523,105 +1039,170 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 			}
 
 			log.Printf("Message validated: length=%d, rate_limit_ok=true", len(sanitized))
-			log.Printf("User message: %s", sanitized)
-
-			// Route to local pipeline or OpenAI based on config
-			if h.useLocalPipeline {
-				// Use local LLM + TTS pipeline
-				log.Printf("Routing to local pipeline")
-				if err := h.localPipelineHandler.HandleLocalPipeline(ctx, sanitized, clientWS, nil, sendJSON); err != nil {
-					log.Printf("Local pipeline error: %v", err)
+			if apiKeyName != "" {
+				log.Printf("User message (API key %s): %s", apiKeyName, sanitized)
+			} else {
+				log.Printf("User message: %s", sanitized)
+			}
+
+			// Screen the message before it reaches a model or the response cache
+			verdict := h.moderate(ctx, sanitized)
+			if verdict.Action != moderation.ActionNone {
+				recorder.Record(recording.SourceModeration, verdict)
+			}
+			if verdict.Action == moderation.ActionBlock {
+				sendJSON(ServerMessage{
+					Type: "moderation_blocked",
+					Text: h.cfg.Phrase(config.PhraseRefusal),
+				})
+				speakPhrase(config.PhraseRefusal, cmp.Or(msg.Modality, sessionOptions.OutputModality))
+				continue
+			}
+			message := sanitized
+			if verdict.Action == moderation.ActionWarn {
+				message = moderationWarning + sanitized
+			}
+
+			// Per-message modality overrides must be in the session allowlist
+			modality := sessionOptions.OutputModality
+			if msg.Modality != "" && msg.Modality != modality {
+				if !slices.Contains(h.cfg.SessionModalities, msg.Modality) {
 					sendJSON(ServerMessage{
 						Type:  "error",
-						Error: "Failed to process message",
+						Error: fmt.Sprintf("Modality %q is not available", msg.Modality),
 					})
+					continue
 				}
-			} else {
-				// Use OpenAI Realtime API
-				// Lazy connect: establish connection on first message or reconnect if lost
-				realtimeConnMutex.Lock()
-				needsConnect := realtimeConn == nil
-				realtimeConnMutex.Unlock()
+				modality = msg.Modality
+			}
 
-				if needsConnect {
-					log.Printf("Establishing OpenAI connection for message...")
-					if err := connectToOpenAI(); err != nil {
+			// Try backends in order, skipping Realtime once the paid budget is spent
+			candidates := h.cfg.Backends
+			if h.cfg.UsesBackend(BackendRealtime) {
+				if scope := budget.Exceeded(ctx); scope != "" {
+					metrics.BudgetExceeded.Add(scope, 1)
+					if h.localPipelineHandler == nil {
+						log.Printf("Paid budget exceeded (%s scope) for IP %s, refusing message", scope, clientIP)
 						sendJSON(ServerMessage{
-							Type:  "error",
-							Error: "Failed to connect to AI service",
+							Type:  "budget_exceeded",
+							Error: "Usage limit reached. Please try again later.",
 						})
 						continue
 					}
-					// Start the reader goroutine for this new connection
-					startOpenAIReader()
+					if !budgetFallbackNotified {
+						log.Printf("Paid budget exceeded (%s scope) for IP %s, falling back to local pipeline", scope, clientIP)
+						sendJSON(ServerMessage{
+							Type: "budget_exceeded",
+							Text: "Usage limit reached, switching to the local model.",
+						})
+						budgetFallbackNotified = true
+					}
+					candidates = []string{BackendLocal}
 				}
+			}
 
-				// Create conversation item with user message (use sanitized input)
-				item := openairt.ConversationItemCreateEvent{
-					Item: openairt.MessageItemUnion{
-						User: &openairt.MessageItemUser{
-							Content: []openairt.MessageContentInput{
-								{
-									Type: openairt.MessageContentTypeInputText,
-									Text: sanitized,
-								},
-							},
-						},
-					},
+			capture.Store(nil)
+			// Answers to moderated messages could carry what the message tried to get
+			// out of the model, so they're neither served from nor stored in the cache
+			cacheable := firstTurn && h.responses != nil && verdict.Action == moderation.ActionNone
+			firstTurn = false
+			var embedding []float32
+			if cacheable {
+				variant := answerVariant(candidates[0], sessionPersona, sessionOptions)
+				key := cache.ResponseKey(sessionPersona.Version, variant, sanitized)
+				answer, ok := h.cachedAnswer(key, modality)
+				if !ok {
+					embedding, answer, ok = h.similarAnswer(ctx, answerScope(sessionPersona, variant), sanitized, modality)
 				}
+				if ok {
+					log.Printf("Answering from the response cache (%s answer from %s)", answer.Backend, answer.CreatedAt.Format(time.RFC3339))
+					streamCachedAnswer(ctx, answer, modality, sendJSON)
+					cachedTurn = cachedTurnItems(sanitized, answer)
+					continue
+				}
+			}
 
-				realtimeConnMutex.Lock()
-				conn := realtimeConn
-				realtimeConnMutex.Unlock()
-
-				if conn == nil {
-					sendJSON(ServerMessage{
-						Type:  "error",
-						Error: "AI service connection lost, please try again",
-					})
+			served := false
+			for _, backend := range candidates {
+				br := h.breakers[backend]
+				if !br.Allow() {
+					log.Printf("Skipping %s backend: circuit breaker open", backend)
 					continue
 				}
 
-				if err := conn.SendMessage(ctx, item); err != nil {
-					log.Printf("Failed to send message: %v", err)
-					// Connection might be dead, clear it so next message reconnects
-					realtimeConnMutex.Lock()
-					if realtimeConn != nil {
-						realtimeConn.Close()
-						realtimeConn = nil
-					}
-					realtimeConnMutex.Unlock()
+				// Tell the client when this turn is answered by a different backend than the last
+				if backend != currentBackend {
+					log.Printf("Switching backend from %s to %s for IP %s", currentBackend, backend, clientIP)
+					metrics.BackendFailovers.Add(backend, 1)
 					sendJSON(ServerMessage{
-						Type:  "error",
-						Error: "Failed to send message, please try again",
+						Type:    "backend_switched",
+						Backend: backend,
 					})
-					continue
+					currentBackend = backend
 				}
 
-				// Request response
-				responseCreate := openairt.ResponseCreateEvent{}
+				if cacheable {
+					variant := answerVariant(backend, sessionPersona, sessionOptions)
+					capture.Store(&answerCapture{
+						key:       cache.ResponseKey(sessionPersona.Version, variant, sanitized),
+						backend:   backend,
+						modality:  modality,
+						scope:     answerScope(sessionPersona, variant),
+						question:  sanitized,
+						embedding: embedding,
+					})
+				}
 
-				if err := conn.SendMessage(ctx, responseCreate); err != nil {
-					log.Printf("Failed to request response: %v", err)
-					// Connection might be dead, clear it so next message reconnects
-					realtimeConnMutex.Lock()
-					if realtimeConn != nil {
-						realtimeConn.Close()
-						realtimeConn = nil
+				var started bool
+				var err error
+				if backend == BackendLocal {
+					started, err = runLocalTurn(message, modality)
+				} else {
+					started, err = runRealtimeTurn(message, modality)
+				}
+				if err == nil {
+					br.Success()
+					served = true
+					// Realtime answers are stored by the reader once the response is done
+					if backend == BackendLocal {
+						if cp := capture.Swap(nil); cp != nil {
+							h.storeAnswer(cp)
+						}
 					}
-					realtimeConnMutex.Unlock()
+					break
+				}
+
+				capture.Store(nil)
+				// The client going away is no reason to distrust the backend or to try another
+				if errors.Is(err, errClientGone) {
+					br.Release()
+					log.Printf("%s turn abandoned: %v", backend, err)
+					served = true
+					break
+				}
+				br.Failure()
+				log.Printf("%s backend failed: %v", backend, err)
+				// Once output has reached the client, retrying elsewhere would repeat the answer
+				if started {
 					sendJSON(ServerMessage{
 						Type:  "error",
-						Error: "Failed to request response, please try again",
+						Error: "Failed to process message",
 					})
-					continue
+					served = true
+					break
 				}
 			}
+			if !served {
+				sendJSON(ServerMessage{
+					Type:  "error",
+					Error: "Failed to connect to AI service",
+				})
+			}
 
 		case "heartbeat_ack":
 			// Client acknowledging heartbeat - connection is alive
 			// Reset read deadline to keep connection open
-			clientWS.SetReadDeadline(time.Now().Add(ConnectionTimeout))
+			clientWS.SetReadDeadline(time.Now().Add(h.cfg.ConnectionTimeout))
 			continue
 
 		default:
@@ -638,6 +1219,21 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 	doneOnce.Do(func() { close(done) })
 }
 
+// sanitizeMessage trims whitespace and removes control characters other than newlines and tabs
+func sanitizeMessage(message string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 32 && r != '\n' && r != '\t' {
+			return -1 // Remove control characters
+		}
+		return r
+	}, strings.TrimSpace(message))
+}
+
 func (h *ChatHandler) HandleHealth(c *gin.Context) {
+	// Report not-ready while draining so the load balancer stops sending new clients
+	if h.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 }
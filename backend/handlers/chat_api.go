@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/metrics"
+	"christianmoore.me/avatar-backend/moderation"
+	"christianmoore.me/avatar-backend/persona"
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// ChatRequest is the body of POST /api/chat
+type ChatRequest struct {
+	Message string `json:"message"`
+}
+
+// ChatResponse is the answer to a ChatRequest
+type ChatResponse struct {
+	Text    string `json:"text"`
+	Backend string `json:"backend"` // Backend that generated the answer
+}
+
+// ErrorCodeModerationBlocked marks a message refused by moderation (HTTP chat only;
+// the WebSocket sends a moderation_blocked event instead)
+const ErrorCodeModerationBlocked = "moderation_blocked"
+
+// HandleChat answers a single message over HTTP, for API key integrations that don't
+// keep a WebSocket open. Each request counts as one message against the key's rate
+// limit and daily quota, and goes through the same moderation, budget, backend
+// failover and guardrails as a WebSocket message. The answer is text only and returned
+// whole, so guardrails are enforced on Realtime answers too. Every request is a new
+// conversation, and the response cache isn't used.
+func (h *ChatHandler) HandleChat(c *gin.Context) {
+	if h.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Server is shutting down",
+		})
+		return
+	}
+
+	// Only API keys are accepted; browser visitors use the WebSocket
+	rawKey := apiKeyFromRequest(c)
+	if rawKey == "" || h.authHandler == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "API key required",
+		})
+		return
+	}
+	key, err := h.authHandler.apiKeys.Verify(rawKey, ScopeChat)
+	if err != nil {
+		log.Printf("API key rejected for IP %s: %v", c.ClientIP(), err)
+		c.JSON(apiKeyErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := h.authHandler.apiKeys.Use(key.Name); err != nil {
+		log.Printf("API key %s message rejected: %v", key.Name, err)
+		refusal := gin.H{"error": err.Error()}
+		if errors.Is(err, ErrAPIKeyRateLimited) {
+			refusal["code"] = ErrorCodeRateLimited
+		}
+		c.JSON(apiKeyErrorStatus(err), refusal)
+		return
+	}
+
+	var req ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+	if n := len(req.Message); n < MinMessageLength || n > h.cfg.MaxMessageLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Message must be between %d and %d characters", MinMessageLength, h.cfg.MaxMessageLength),
+		})
+		return
+	}
+	sanitized := sanitizeMessage(req.Message)
+	if len(sanitized) < MinMessageLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Message cannot be empty",
+		})
+		return
+	}
+	log.Printf("User message (API key %s, HTTP): %s", key.Name, sanitized)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.ConnectionTimeout)
+	defer cancel()
+
+	verdict := h.moderate(ctx, sanitized)
+	if verdict.Action == moderation.ActionBlock {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": h.cfg.Phrase(config.PhraseRefusal),
+			"code":  ErrorCodeModerationBlocked,
+		})
+		return
+	}
+	message := sanitized
+	if verdict.Action == moderation.ActionWarn {
+		message = moderationWarning + sanitized
+	}
+
+	// Skip Realtime once the paid budget is spent, as on the WebSocket
+	p := h.personas.Current()
+	budget := h.budget.NewSession(c.ClientIP())
+	candidates := h.cfg.Backends
+	if h.cfg.UsesBackend(BackendRealtime) {
+		if scope := budget.Exceeded(ctx); scope != "" {
+			metrics.BudgetExceeded.Add(scope, 1)
+			if h.localPipelineHandler == nil {
+				log.Printf("Paid budget exceeded (%s scope) for API key %s, refusing message", scope, key.Name)
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error": "Usage limit reached. Please try again later.",
+				})
+				return
+			}
+			candidates = []string{BackendLocal}
+		}
+	}
+
+	for _, backend := range candidates {
+		br := h.breakers[backend]
+		if !br.Allow() {
+			log.Printf("Skipping %s backend: circuit breaker open", backend)
+			continue
+		}
+
+		var text string
+		if backend == BackendLocal {
+			text, err = h.localAnswer(ctx, p, message, budget)
+		} else {
+			text, err = h.realtimeAnswer(ctx, p, message, budget)
+		}
+		if err == nil {
+			br.Success()
+			c.JSON(http.StatusOK, ChatResponse{Text: text, Backend: backend})
+			return
+		}
+		if c.Request.Context().Err() != nil {
+			br.Release()
+			log.Printf("%s answer abandoned: %v", backend, err)
+			return
+		}
+		br.Failure()
+		log.Printf("%s backend failed: %v", backend, err)
+	}
+
+	c.JSON(http.StatusBadGateway, gin.H{
+		"error": "Failed to connect to AI service",
+	})
+}
+
+// localAnswer generates a text answer through the local pipeline, which applies the
+// guardrails itself
+func (h *ChatHandler) localAnswer(ctx context.Context, p *persona.Persona, message string, budget *SessionBudget) (string, error) {
+	if h.localPipelineHandler == nil {
+		return "", errors.New("local pipeline not configured")
+	}
+	var text strings.Builder
+	collect := func(msg ServerMessage) error {
+		if msg.Type == "text_delta" {
+			text.WriteString(msg.Text)
+		}
+		return nil
+	}
+	if err := h.localPipelineHandler.HandleLocalPipeline(ctx, p, message, "text", nil, nil, collect, budget); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// realtimeAnswer generates a text answer on a Realtime connection of its own and
+// applies the guardrails to it
+func (h *ChatHandler) realtimeAnswer(ctx context.Context, p *persona.Persona, message string, budget *SessionBudget) (string, error) {
+	opts, err := newSessionOptions(h.cfg, p, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	opts.OutputModality = "text"
+
+	connectCtx, cancelConnect := context.WithTimeout(ctx, h.cfg.RealtimeResponseTimeout)
+	defer cancelConnect()
+	dialer := h.realtimeDialer
+	if dialer == nil {
+		dialer = openairt.DefaultDialer()
+	}
+	conn, err := h.realtimeClient().Connect(connectCtx, openairt.WithModel(h.cfg.OpenAIModel), openairt.WithDialer(dialer))
+	if err != nil {
+		return "", fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	events := []openairt.ClientEvent{
+		openairt.SessionUpdateEvent{
+			Session: openairt.SessionUnion{Realtime: opts.realtimeSession(p.Prompt)},
+		},
+		openairt.ConversationItemCreateEvent{
+			Item: openairt.MessageItemUnion{
+				User: &openairt.MessageItemUser{
+					Content: []openairt.MessageContentInput{
+						{Type: openairt.MessageContentTypeInputText, Text: message},
+					},
+				},
+			},
+		},
+		openairt.ResponseCreateEvent{},
+	}
+	for _, event := range events {
+		if err := conn.SendMessage(ctx, event); err != nil {
+			return "", fmt.Errorf("send %T: %w", event, err)
+		}
+	}
+
+	var text strings.Builder
+	for {
+		event, err := conn.ReadMessage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("read: %w", err)
+		}
+		switch e := event.(type) {
+		case openairt.ResponseOutputTextDeltaEvent:
+			text.WriteString(e.Delta)
+		case openairt.ResponseDoneEvent:
+			budget.RecordRealtime(ctx, e.Response.Usage)
+			if e.Response.Status != openairt.ResponseStatusCompleted {
+				return "", fmt.Errorf("response %s", e.Response.Status)
+			}
+			answer := text.String()
+			if h.guard.Enforcing() {
+				fixed, violations := h.guard.Apply(answer, p)
+				reportViolations(BackendRealtime, violations, fixed != answer)
+				return fixed, nil
+			}
+			reportViolations(BackendRealtime, h.guard.Check(answer, p), false)
+			return answer, nil
+		case openairt.ErrorEvent:
+			log.Printf("OpenAI ErrorEvent received (ignoring): %+v", e)
+		}
+	}
+}
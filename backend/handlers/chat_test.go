@@ -0,0 +1,585 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/breaker"
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/health"
+	"christianmoore.me/avatar-backend/limits"
+	"christianmoore.me/avatar-backend/origins"
+	"christianmoore.me/avatar-backend/persona"
+	"christianmoore.me/avatar-backend/testing/fakes"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// newTestChatServer serves a ChatHandler without authentication or upstreams;
+// configure adjusts the config before the handler is built
+func newTestChatServer(t *testing.T, configure ...func(*config.Config)) (*ChatHandler, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		MaxMessageLength:    4000,
+		MessageRateInterval: 5 * time.Second,
+		MessageBurst:        3,
+		MaxConnectionsPerIP: 10,
+		ConnectionTimeout:   time.Minute,
+		HeartbeatInterval:   30 * time.Second,
+
+		Backends:                []string{BackendRealtime},
+		BreakerFailureThreshold: 3,
+		BreakerCooldown:         30 * time.Second,
+		RealtimeResponseTimeout: time.Second,
+		TTSResponseFormat:       "wav",
+
+		LLMUpstream: config.UpstreamConfig{ConnectTimeout: time.Second, ResponseHeaderTimeout: 5 * time.Second, BreakerThreshold: 3, BreakerCooldown: 30 * time.Second},
+		TTSUpstream: config.UpstreamConfig{ConnectTimeout: time.Second, ResponseHeaderTimeout: 5 * time.Second, BreakerThreshold: 3, BreakerCooldown: 30 * time.Second},
+	}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	personas := persona.NewStore(persona.New("You are Christian.", "test", "cedar", "onyx", 1))
+	policy, err := origins.NewPolicy([]string{"http://localhost:5173"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewChatHandler(cfg, nil, nil, personas, policy)
+	if err != nil {
+		t.Fatalf("NewChatHandler failed: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/health", handler.HandleHealth)
+	router.GET("/livez", handler.HandleLivez)
+	router.GET("/readyz", handler.HandleReadyz)
+	router.GET("/ws/chat", handler.HandleWebSocket)
+	router.POST("/api/chat", handler.HandleChat)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return handler, server
+}
+
+// dialTestSession opens a chat WebSocket with the given query string and returns it
+// with the session options from the session_created greeting
+func dialTestSession(t *testing.T, server *httptest.Server, query string) (*websocket.Conn, SessionOptions) {
+	t.Helper()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/chat" + query
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var msg ServerMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "session_created" || msg.Session == nil {
+		t.Fatalf("Expected session_created, got %+v", msg)
+	}
+	return conn, *msg.Session
+}
+
+func TestDrainClosesIdleSessions(t *testing.T) {
+	handler, server := newTestChatServer(t)
+	conn, _ := dialTestSession(t, server, "")
+
+	// Wait until the session is registered before draining
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		handler.sessionsMu.Lock()
+		active := handler.sessions
+		handler.sessionsMu.Unlock()
+		if active == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Session was never registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		drained <- handler.Drain(ctx)
+	}()
+
+	// The client is told to reconnect, then closed with 1012 (service restart)
+	var msg ServerMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("Expected server_draining event, got error: %v", err)
+	}
+	if msg.Type != "server_draining" {
+		t.Errorf("Expected server_draining event, got %q", msg.Type)
+	}
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("Expected close code 1012, got %v", err)
+	}
+
+	if err := <-drained; err != nil {
+		t.Errorf("Expected drain to complete, got %v", err)
+	}
+}
+
+func TestDrainRejectsNewSessions(t *testing.T) {
+	handler, server := newTestChatServer(t)
+
+	if err := handler.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain with no sessions failed: %v", err)
+	}
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected health to report 503 while draining, got %d", resp.StatusCode)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/chat"
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("Expected new WebSocket connections to be refused while draining")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for new connection while draining, got %v", resp)
+	}
+
+	// Draining twice is a no-op
+	if err := handler.Drain(context.Background()); err != nil {
+		t.Errorf("Second drain failed: %v", err)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	handler, server := newTestChatServer(t)
+	handler.cfg.Backends = []string{BackendLocal} // No Realtime check against the real API
+	handler.EnableHealthChecks(health.NewChecker(), time.Minute, time.Minute)
+
+	resp, err := http.Get(server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Status string                   `json:"status"`
+		Checks map[string]health.Result `json:"checks"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || body.Status != "ready" {
+		t.Errorf("Expected ready, got %d %+v", resp.StatusCode, body)
+	}
+	if body.Checks["drain"].Status != health.StatusOK {
+		t.Errorf("Expected drain check ok, got %+v", body.Checks["drain"])
+	}
+
+	handler.Drain(context.Background())
+	resp, err = http.Get(server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while draining, got %d", resp.StatusCode)
+	}
+
+	// Liveness doesn't depend on drain state or dependencies
+	resp, err = http.Get(server.URL + "/livez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected livez 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestLocalPipelineHealthChecks(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"qwen2.5-7b-instruct"}]}`))
+	}))
+	defer llm.Close()
+	tts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer tts.Close()
+
+	local := newTestLocalPipeline(t, llm.URL, tts.URL)
+	if err := local.CheckLLM(context.Background()); err != nil {
+		t.Errorf("Expected LLM check to pass, got %v", err)
+	}
+	if err := local.CheckTTS(context.Background()); err == nil {
+		t.Error("Expected TTS check to fail on 502")
+	}
+
+	local.llmModel = "llama-3.1-8b"
+	if err := local.CheckLLM(context.Background()); err == nil {
+		t.Error("Expected LLM check to fail when the model isn't served")
+	}
+}
+
+func TestFailedBackendOpensBreaker(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+	}))
+	defer llm.Close()
+
+	handler, server := newTestChatServer(t, func(cfg *config.Config) {
+		cfg.Backends = []string{BackendLocal}
+		cfg.BreakerFailureThreshold = 2
+		cfg.LocalLLMURL = llm.URL
+		cfg.TTSURL = llm.URL
+	})
+	conn, _ := dialTestSession(t, server, "")
+
+	// Each failed turn reports an error without partial output; the breaker opens at the threshold
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteJSON(ClientMessage{Type: "message", Message: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+		var msg ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != "error" || msg.Error != "Failed to connect to AI service" {
+			t.Errorf("Turn %d: expected connect error, got %+v", i+1, msg)
+		}
+	}
+
+	if state := handler.breakers[BackendLocal].State(); state != breaker.StateOpen {
+		t.Errorf("Expected local breaker to be open, got %s", state)
+	}
+}
+
+func TestClientDisconnectKeepsBreakerClosed(t *testing.T) {
+	llm := fakes.NewLLM("qwen2.5-7b-instruct")
+	llm.Enqueue(fakes.LLMReply{Chunks: fakes.Words(strings.Repeat("Christian builds platforms. ", 40)), Delay: 20 * time.Millisecond})
+	tts := fakes.NewTTS()
+	rt := fakes.NewRealtime()
+	llmServer, ttsServer, rtServer := fakes.Serve(t, llm), fakes.Serve(t, tts), fakes.Serve(t, rt)
+
+	handler, server := newTestChatServer(t, func(cfg *config.Config) {
+		cfg.Backends = []string{BackendLocal, BackendRealtime}
+		cfg.BreakerFailureThreshold = 1
+		cfg.LocalLLMURL = llmServer.URL
+		cfg.LocalLLMModel = "qwen2.5-7b-instruct"
+		cfg.TTSURL = ttsServer.URL
+		cfg.OpenAIRealtimeURL = fakes.RealtimeURL(rtServer.URL)
+	})
+	conn, _ := dialTestSession(t, server, "")
+	if err := conn.WriteJSON(ClientMessage{Type: "message", Message: "What does Christian do?"}); err != nil {
+		t.Fatal(err)
+	}
+	var msg ServerMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "text_delta" {
+		t.Fatalf("Expected the answer to start streaming, got %+v (%v)", msg, err)
+	}
+	conn.Close()
+
+	// Wait for the session to wind down after the failed writes
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		handler.sessionsMu.Lock()
+		sessions := handler.sessions
+		handler.sessionsMu.Unlock()
+		if sessions == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Session still running after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if state := handler.breakers[BackendLocal].State(); state != breaker.StateClosed {
+		t.Errorf("Expected a client disconnect to leave the local breaker closed, got %s", state)
+	}
+	if got := rt.Messages(); len(got) != 0 {
+		t.Errorf("Expected no failover after a client disconnect, Realtime got %v", got)
+	}
+}
+
+// leaseRecorder records the TTLs connection slot leases are taken and refreshed with
+type leaseRecorder struct {
+	*limits.MemoryStore
+	mu   sync.Mutex
+	ttls []time.Duration
+}
+
+func (r *leaseRecorder) AcquireConnection(ctx context.Context, key string, max int, ttl time.Duration) (string, int, error) {
+	r.record(ttl)
+	return r.MemoryStore.AcquireConnection(ctx, key, max, ttl)
+}
+
+func (r *leaseRecorder) RefreshConnection(ctx context.Context, key, lease string, ttl time.Duration) error {
+	r.record(ttl)
+	return r.MemoryStore.RefreshConnection(ctx, key, lease, ttl)
+}
+
+func (r *leaseRecorder) record(ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ttls = append(r.ttls, ttl)
+}
+
+func TestConnectionLeaseOutlivesHeartbeats(t *testing.T) {
+	for _, interval := range []time.Duration{30 * time.Second, 3 * time.Minute} {
+		handler, server := newTestChatServer(t, func(cfg *config.Config) {
+			cfg.HeartbeatInterval = interval
+			cfg.ConnectionTimeout = 10 * time.Minute
+		})
+		store := &leaseRecorder{MemoryStore: limits.NewMemoryStore()}
+		handler.SetLimitStore(store)
+		dialTestSession(t, server, "")
+
+		store.mu.Lock()
+		ttls := store.ttls
+		store.mu.Unlock()
+		if len(ttls) != 1 {
+			t.Fatalf("Expected one lease, got TTLs %v", ttls)
+		}
+		// A lease must survive a couple of heartbeats, as only heartbeats refresh it
+		if ttls[0] < 2*interval || ttls[0] < ConnectionLeaseTTL {
+			t.Errorf("Heartbeat interval %v: lease TTL %v would expire on a live connection", interval, ttls[0])
+		}
+	}
+}
+
+func TestSessionOptionsAllowlist(t *testing.T) {
+	_, server := newTestChatServer(t, func(cfg *config.Config) {
+		cfg.RealtimeOutputModality = "audio"
+		cfg.RealtimeMaxOutputTokens = 1024
+		cfg.SessionVoices = []string{"marin", "cedar"}
+		cfg.SessionModalities = []string{"text"}
+	})
+
+	_, opts := dialTestSession(t, server, "")
+	if opts != (SessionOptions{Voice: "cedar", OutputModality: "audio", MaxOutputTokens: 1024}) {
+		t.Errorf("Unexpected default options: %+v", opts)
+	}
+
+	// Allowed overrides are applied and echoed back; the token cap can only be lowered
+	_, opts = dialTestSession(t, server, "?voice=Marin&modality=text&max_output_tokens=4000")
+	if opts != (SessionOptions{Voice: "marin", OutputModality: "text", MaxOutputTokens: 1024}) {
+		t.Errorf("Unexpected overridden options: %+v", opts)
+	}
+
+	for _, query := range []string{"?voice=alloy", "?modality=video", "?max_output_tokens=-1"} {
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/chat" + query
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %v", query, err)
+		}
+	}
+}
+
+func TestTextOnlyMessageSkipsTTS(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hi there.\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer llm.Close()
+	var ttsCalls atomic.Int32
+	tts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/audio/speech" {
+			ttsCalls.Add(1)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer tts.Close()
+
+	_, server := newTestChatServer(t, func(cfg *config.Config) {
+		cfg.Backends = []string{BackendLocal}
+		cfg.LocalLLMURL = llm.URL
+		cfg.TTSURL = tts.URL
+		cfg.SessionModalities = []string{"text"}
+	})
+	conn, _ := dialTestSession(t, server, "")
+
+	if err := conn.WriteJSON(ClientMessage{Type: "message", Message: "Hello", Modality: "text"}); err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for len(types) == 0 || types[len(types)-1] != "response_done" {
+		var msg ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, msg.Type)
+	}
+	if !reflect.DeepEqual(types, []string{"text_delta", "text_done", "response_done"}) {
+		t.Errorf("Expected a text-only response, got %v", types)
+	}
+	if n := ttsCalls.Load(); n != 0 {
+		t.Errorf("Expected no TTS calls, got %d", n)
+	}
+
+	// Modalities outside the allowlist are refused
+	if err := conn.WriteJSON(ClientMessage{Type: "message", Message: "Hello", Modality: "video"}); err != nil {
+		t.Fatal(err)
+	}
+	var msg ServerMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "error" || !strings.Contains(msg.Error, "video") {
+		t.Errorf("Expected modality error, got %+v", msg)
+	}
+}
+
+// readResponse collects server message types (consecutive repeats merged) and text
+// until the first response_done
+func readResponse(t *testing.T, conn *websocket.Conn) (types []string, text string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read failed after %v: %v", types, err)
+		}
+		if len(types) == 0 || types[len(types)-1] != msg.Type {
+			types = append(types, msg.Type)
+		}
+		text += msg.Text
+		if msg.Type == "response_done" {
+			return types, text
+		}
+	}
+}
+
+// readUntil reads events through the first one of type last, returning the event
+// types with runs of the same type collapsed
+func readUntil(t *testing.T, conn *websocket.Conn, last string) (types []string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(types) == 0 || types[len(types)-1] != last {
+		var msg ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read failed after %v: %v", types, err)
+		}
+		if len(types) == 0 || types[len(types)-1] != msg.Type {
+			types = append(types, msg.Type)
+		}
+	}
+	return types
+}
+
+func TestRealtimeBackendWithFake(t *testing.T) {
+	rt := fakes.NewRealtime()
+	rt.Enqueue(fakes.RealtimeReply{Chunks: fakes.Words("Christian works on Kubernetes.")})
+	upstream := fakes.Serve(t, rt)
+
+	_, server := newTestChatServer(t, func(cfg *config.Config) {
+		cfg.OpenAIRealtimeURL = fakes.RealtimeURL(upstream.URL)
+		cfg.RealtimeOutputModality = "text"
+	})
+	conn, _ := dialTestSession(t, server, "")
+	if err := conn.WriteJSON(ClientMessage{Type: "message", Message: "What does Christian do?"}); err != nil {
+		t.Fatal(err)
+	}
+
+	types, text := readResponse(t, conn)
+	if text != "Christian works on Kubernetes." {
+		t.Errorf("Unexpected text %q (events %v)", text, types)
+	}
+	if rt.Instructions() != "You are Christian." {
+		t.Errorf("Expected the persona prompt as instructions, got %q", rt.Instructions())
+	}
+	if got := rt.Messages(); len(got) != 1 || got[0] != "What does Christian do?" {
+		t.Errorf("Unexpected messages sent upstream: %v", got)
+	}
+}
+
+func TestLocalBackendWithFakes(t *testing.T) {
+	llm := fakes.NewLLM("qwen2.5-7b-instruct")
+	llm.Enqueue(fakes.LLMReply{Chunks: fakes.Words("Hello from the pipeline.")})
+	tts := fakes.NewTTS()
+	llmServer, ttsServer := fakes.Serve(t, llm), fakes.Serve(t, tts)
+
+	_, server := newTestChatServer(t, func(cfg *config.Config) {
+		cfg.Backends = []string{BackendLocal}
+		cfg.LocalLLMURL = llmServer.URL
+		cfg.LocalLLMModel = "qwen2.5-7b-instruct"
+		cfg.TTSURL = ttsServer.URL
+	})
+	conn, _ := dialTestSession(t, server, "")
+	if err := conn.WriteJSON(ClientMessage{Type: "message", Message: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	types, text := readResponse(t, conn)
+	if !reflect.DeepEqual(types, []string{"text_delta", "text_done", "audio_delta", "audio_done", "response_done"}) {
+		t.Errorf("Unexpected event sequence %v", types)
+	}
+	if text != "Hello from the pipeline." {
+		t.Errorf("Unexpected text %q", text)
+	}
+	if reqs := tts.Requests(); len(reqs) == 0 || reqs[len(reqs)-1].Input != "Hello from the pipeline." {
+		t.Errorf("Expected the reply to be spoken, got %+v", reqs)
+	}
+}
+
+func TestSpokenPhrases(t *testing.T) {
+	llm := fakes.NewLLM("qwen2.5-7b-instruct")
+	tts := fakes.NewTTS()
+	llmServer, ttsServer := fakes.Serve(t, llm), fakes.Serve(t, tts)
+
+	handler, server := newTestChatServer(t, func(cfg *config.Config) {
+		cfg.Backends = []string{BackendLocal}
+		cfg.LocalLLMURL = llmServer.URL
+		cfg.LocalLLMModel = "qwen2.5-7b-instruct"
+		cfg.TTSURL = ttsServer.URL
+		cfg.RealtimeOutputModality = "audio"
+		cfg.TTSCacheMemoryBytes = 1 << 20
+		cfg.MessageBurst = 1
+		cfg.Phrases = map[string]string{config.PhraseGreeting: "Hi, ask me anything."}
+		cfg.SpokenPhrases = []string{config.PhraseGreeting, config.PhraseRateLimit}
+	})
+	// Done by the TTS warmup at startup, which waits for the TTS server first
+	handler.localPipelineHandler.synthesizePhrases()
+	synthesized := len(tts.Requests())
+	if synthesized != 2 {
+		t.Fatalf("Expected both phrases to be synthesized, got %d TTS requests", synthesized)
+	}
+
+	// The greeting is spoken right after session_created
+	conn, _ := dialTestSession(t, server, "")
+	if types := readUntil(t, conn, "audio_done"); !reflect.DeepEqual(types, []string{"greeting", "audio_delta", "audio_done"}) {
+		t.Errorf("Expected the spoken greeting, got %v", types)
+	}
+
+	// Repeated text is synthesized once
+	llm.Enqueue(fakes.LLMReply{Chunks: fakes.Words("Hi, ask me anything.")})
+	conn.WriteJSON(ClientMessage{Type: "message", Message: "Hello"})
+	readResponse(t, conn)
+	if n := len(tts.Requests()); n != synthesized {
+		t.Errorf("Expected the cached greeting audio to be reused, got %d TTS requests", n)
+	}
+
+	// The rate limit notice is followed by its audio without calling TTS
+	conn.WriteJSON(ClientMessage{Type: "message", Message: "Hello again"})
+	if types := readUntil(t, conn, "audio_done"); !reflect.DeepEqual(types, []string{"error", "audio_delta", "audio_done"}) {
+		t.Errorf("Expected the spoken rate limit notice, got %v", types)
+	}
+	if n := len(tts.Requests()); n != synthesized {
+		t.Errorf("Expected no TTS request for the rate limit notice, got %d", n)
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"log"
+
+	"christianmoore.me/avatar-backend/guardrails"
+	"christianmoore.me/avatar-backend/metrics"
+)
+
+// reportViolations logs and counts the guardrail violations of an answer from
+// backend, for tuning the prompt. fixed reports whether the answer was changed.
+func reportViolations(backend string, violations []guardrails.Violation, fixed bool) {
+	if len(violations) == 0 {
+		return
+	}
+	for _, v := range violations {
+		metrics.Guardrails.Add(v.Check, 1)
+		log.Printf("Guardrail violation in %s answer: %s", backend, v)
+	}
+	if fixed {
+		metrics.Guardrails.Add("fixed", 1)
+		log.Printf("Guardrails changed the %s answer before sending it", backend)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"christianmoore.me/avatar-backend/health"
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// Timeouts for individual readiness checks
+const (
+	HTTPCheckTimeout     = 5 * time.Second
+	RealtimeCheckTimeout = 10 * time.Second
+)
+
+// EnableHealthChecks registers checks for the configured backends with checker and serves
+// them on /readyz. Dependencies of a sole backend are critical; failover and budget
+// fallback dependencies are reported but don't affect readiness.
+func (h *ChatHandler) EnableHealthChecks(checker *health.Checker, interval, realtimeInterval time.Duration) {
+	h.health = checker
+
+	checker.Add("drain", true, 0, 0, func(ctx context.Context) error {
+		if h.Draining() {
+			return errors.New("server is draining")
+		}
+		return nil
+	})
+
+	// With several backends a single outage fails over rather than taking the replica
+	// out of service, so only a sole backend's dependencies are critical
+	sole := len(h.cfg.Backends) == 1
+	if h.cfg.UsesBackend(BackendRealtime) {
+		checker.Add("realtime", sole, realtimeInterval, RealtimeCheckTimeout, h.CheckRealtime)
+	}
+
+	if local := h.localPipelineHandler; local != nil {
+		critical := sole && h.cfg.UsesBackend(BackendLocal)
+		checker.Add("llm", critical, interval, HTTPCheckTimeout, local.CheckLLM)
+		checker.Add("tts", critical, interval, HTTPCheckTimeout, local.CheckTTS)
+		checker.Add("tts_warmup", critical, 0, 0, local.CheckWarmup)
+	}
+}
+
+// CheckRealtime verifies the OpenAI key and model by opening (and immediately closing)
+// a Realtime connection. No response is requested, so no tokens are billed.
+func (h *ChatHandler) CheckRealtime(ctx context.Context) error {
+	conn, err := h.realtimeClient().Connect(ctx, openairt.WithModel(h.cfg.OpenAIModel))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// realtimeClient creates a Realtime API client for the configured endpoint
+func (h *ChatHandler) realtimeClient() *openairt.Client {
+	clientCfg := openairt.DefaultConfig(h.cfg.OpenAIAPIKey)
+	clientCfg.BaseURL = h.cfg.OpenAIRealtimeURL
+	return openairt.NewClientWithConfig(clientCfg)
+}
+
+// HandleLivez reports that the process is up; it never checks dependencies so an
+// upstream outage doesn't get the pod restarted
+func (h *ChatHandler) HandleLivez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// HandleReadyz reports whether this replica should receive traffic, with the cached
+// result of each dependency check
+func (h *ChatHandler) HandleReadyz(c *gin.Context) {
+	if h.health == nil {
+		h.HandleHealth(c)
+		return
+	}
+
+	ready, checks := h.health.Report(c.Request.Context())
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": checks,
+	})
+}
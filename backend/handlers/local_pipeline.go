@@ -7,21 +7,39 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"christianmoore.me/avatar-backend/cache"
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/guardrails"
+	"christianmoore.me/avatar-backend/persona"
+	"christianmoore.me/avatar-backend/upstream"
 	"github.com/gorilla/websocket"
 )
 
 // OpenAI-compatible chat completion request
 type ChatCompletionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	TopP          *float64       `json:"top_p,omitempty"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+}
+
+// StreamOptions requests a final usage chunk in streaming responses
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type Message struct {
@@ -31,11 +49,19 @@ type Message struct {
 
 // OpenAI-compatible streaming response
 type ChatCompletionChunk struct {
-	ID      string   `json:"id"`
-	Object  string   `json:"object"`
-	Created int64    `json:"created"`
-	Model   string   `json:"model"`
-	Choices []Choice `json:"choices"`
+	ID      string           `json:"id"`
+	Object  string           `json:"object"`
+	Created int64            `json:"created"`
+	Model   string           `json:"model"`
+	Choices []Choice         `json:"choices"`
+	Usage   *CompletionUsage `json:"usage,omitempty"`
+}
+
+// CompletionUsage is sent in the final chunk when include_usage is requested
+type CompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type Choice struct {
@@ -49,6 +75,13 @@ type MessageDelta struct {
 	Content string `json:"content,omitempty"`
 }
 
+// OpenAI-compatible model list returned by /v1/models
+type ModelList struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
 // TTS API request (OpenAI-compatible)
 type TTSRequest struct {
 	Model          string  `json:"model"`
@@ -58,33 +91,95 @@ type TTSRequest struct {
 	Speed          float64 `json:"speed,omitempty"`
 }
 
-// LocalPipelineHandler manages LLM + TTS pipeline
+// LocalPipelineHandler manages LLM + TTS pipeline.
+// The system prompt and voice come from the session's persona snapshot.
 type LocalPipelineHandler struct {
-	llmURL       string
-	systemPrompt string
-	ttsURL       string
-	ttsVoice     string
-	ttsSpeed     float64
+	llmURL    string
+	llmModel  string
+	ttsURL    string
+	ttsModel  string
+	ttsFormat string
+	personas  *persona.Store
+
+	// Sampling parameters; unset values are left to the LLM server
+	temperature *float64
+	topP        *float64
+	maxTokens   int
+	stop        []string
+
+	// Shared pooled clients with timeouts, retries and circuit breakers
+	llm *upstream.Client
+	tts *upstream.Client
+
+	// Synthesized speech by text, voice, speed and model (nil when disabled), and the
+	// canned phrases synthesized into it during warmup
+	audio   *cache.Audio
+	phrases []string
+
+	// Checks answers before they're sent and spoken (nil when guardrails are off)
+	guard *guardrails.Guard
+
+	// Outcome of the most recent TTS warmup, reported by the readiness probe
+	warmupMu      sync.Mutex
+	warmupRunning bool
+	warmupErr     error
 }
 
-func NewLocalPipelineHandler(llmURL, systemPrompt, ttsURL, ttsVoice, ttsSpeed string) (*LocalPipelineHandler, error) {
-	log.Printf("Local pipeline initialized: LLM=%s, TTS=%s, Voice=%s, Speed=%s", llmURL, ttsURL, ttsVoice, ttsSpeed)
+// errWarmupPending is the warmup state until the first warmup finishes
+var errWarmupPending = errors.New("TTS warmup in progress")
 
-	// Parse speed string to float64
-	speedFloat := 0.95 // default
-	if ttsSpeed != "" {
-		if _, err := fmt.Sscanf(ttsSpeed, "%f", &speedFloat); err != nil {
-			log.Printf("Warning: failed to parse TTS_SPEED=%s, using default 0.95: %v", ttsSpeed, err)
-			speedFloat = 0.95
-		}
+func NewLocalPipelineHandler(cfg *config.Config, personas *persona.Store, guard *guardrails.Guard) (*LocalPipelineHandler, error) {
+	p := personas.Current()
+	log.Printf("Local pipeline initialized: LLM=%s (%s), TTS=%s (%s, %s), Voice=%s, Speed=%g",
+		cfg.LocalLLMURL, cfg.LocalLLMModel, cfg.TTSURL, cfg.TTSModel, cfg.TTSResponseFormat, p.TTSVoice, p.TTSSpeed)
+
+	llm, err := upstream.New("llm", cfg.LLMUpstream)
+	if err != nil {
+		return nil, err
+	}
+	tts, err := upstream.New("tts", cfg.TTSUpstream)
+	if err != nil {
+		return nil, err
 	}
 
 	handler := &LocalPipelineHandler{
-		llmURL:       llmURL,
-		systemPrompt: systemPrompt,
-		ttsURL:       ttsURL,
-		ttsVoice:     ttsVoice,
-		ttsSpeed:     speedFloat,
+		llmURL:      cfg.LocalLLMURL,
+		llmModel:    cfg.LocalLLMModel,
+		ttsURL:      cfg.TTSURL,
+		ttsModel:    cfg.TTSModel,
+		ttsFormat:   cfg.TTSResponseFormat,
+		personas:    personas,
+		temperature: cfg.LLMTemperature,
+		topP:        cfg.LLMTopP,
+		maxTokens:   cfg.LLMMaxTokens,
+		stop:        cfg.LLMStop,
+		llm:         llm,
+		tts:         tts,
+		guard:       guard,
+
+		warmupRunning: true,
+		warmupErr:     errWarmupPending,
+	}
+
+	if cfg.TTSCacheMemoryBytes > 0 {
+		handler.audio, err = cache.NewAudio(cfg.TTSCacheDir, cfg.TTSCacheMemoryBytes, int64(cfg.TTSCacheDiskBytes))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range cfg.SpokenPhrases {
+		if text := cfg.Phrase(name); text != "" {
+			handler.phrases = append(handler.phrases, text)
+		}
+	}
+
+	if cfg.ValidateModels {
+		ctx, cancel := context.WithTimeout(context.Background(), HTTPCheckTimeout)
+		err := handler.ValidateModels(ctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Warm up the TTS model to avoid garbled first request
@@ -122,103 +217,221 @@ func (h *LocalPipelineHandler) warmupTTS() {
 		}
 	}
 
+	var warmupErr error
 	if successCount == len(testPhrases) {
 		log.Printf("TTS model fully warmed up successfully (%d/%d requests)", successCount, len(testPhrases))
-	} else {
+	} else if successCount > 0 {
 		log.Printf("Warning: TTS warmup partially successful (%d/%d requests)", successCount, len(testPhrases))
+	} else {
+		log.Printf("Warning: TTS warmup failed (0/%d requests)", len(testPhrases))
+		warmupErr = fmt.Errorf("TTS warmup failed (0/%d requests)", len(testPhrases))
+	}
+
+	// Synthesize the canned phrases so they can be spoken without waiting for TTS
+	if warmupErr == nil {
+		h.synthesizePhrases()
 	}
+
+	h.warmupMu.Lock()
+	h.warmupRunning = false
+	h.warmupErr = warmupErr
+	h.warmupMu.Unlock()
 }
 
-// sendWarmupRequest sends a single warmup request to the TTS service
-func (h *LocalPipelineHandler) sendWarmupRequest(text string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// synthesizePhrases puts the canned phrases in the audio cache in the current voice
+func (h *LocalPipelineHandler) synthesizePhrases() {
+	p := h.personas.Current()
+	for _, text := range h.phrases {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err := h.synthesize(ctx, p, text)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: failed to synthesize phrase %q: %v", text, err)
+		}
+	}
+	if len(h.phrases) > 0 {
+		log.Printf("Synthesized %d canned phrases", len(h.phrases))
+	}
+}
 
-	ttsReq := TTSRequest{
-		Model:          "neutss-air-4b",
-		Input:          text,
-		Voice:          h.ttsVoice,
-		ResponseFormat: "wav",
-		Speed:          h.ttsSpeed,
+// PhraseAudio returns the audio of a canned phrase in p's voice if it has been
+// synthesized, without calling the TTS server
+func (h *LocalPipelineHandler) PhraseAudio(p *persona.Persona, text string) ([]byte, bool) {
+	return h.audio.Get(cache.AudioKey(h.ttsModel, p.TTSVoice, p.TTSSpeed, text))
+}
+
+// CheckWarmup reports the TTS warmup result. A failed warmup is retried in the
+// background so the service becomes ready once the TTS server recovers.
+func (h *LocalPipelineHandler) CheckWarmup(ctx context.Context) error {
+	h.warmupMu.Lock()
+	defer h.warmupMu.Unlock()
+	if h.warmupErr != nil && !h.warmupRunning {
+		h.warmupRunning = true
+		go h.warmupTTS()
 	}
+	return h.warmupErr
+}
 
-	jsonData, err := json.Marshal(ttsReq)
+// CheckLLM verifies the local LLM server is up and still serves the configured model
+func (h *LocalPipelineHandler) CheckLLM(ctx context.Context) error {
+	models, err := listModels(ctx, h.llm.HTTPClient(), h.llmURL)
 	if err != nil {
-		log.Printf("Warning: TTS warmup request failed to marshal: %v", err)
-		return false
+		return err
 	}
+	if !slices.Contains(models, h.llmModel) {
+		return fmt.Errorf("model %q is not served by %s", h.llmModel, h.llmURL)
+	}
+	return nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", h.ttsURL+"/v1/audio/speech", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Warning: TTS warmup request failed to create: %v", err)
-		return false
+// CheckTTS verifies the TTS server is reachable (any non-5xx response)
+func (h *LocalPipelineHandler) CheckTTS(ctx context.Context) error {
+	return checkHTTP(ctx, h.tts.HTTPClient(), h.ttsURL+"/v1/models", 0)
+}
+
+// ValidateModels checks that the LLM and TTS servers list the configured models.
+// A server that can't be reached is only logged (readiness reports it until it's up),
+// but a reachable server without the model is a misconfiguration and returns an error.
+func (h *LocalPipelineHandler) ValidateModels(ctx context.Context) error {
+	var problems []error
+	for _, u := range []struct {
+		name, key, url, model string
+		client                *http.Client
+	}{
+		{"LLM", "LOCAL_LLM_MODEL", h.llmURL, h.llmModel, h.llm.HTTPClient()},
+		{"TTS", "TTS_MODEL", h.ttsURL, h.ttsModel, h.tts.HTTPClient()},
+	} {
+		models, err := listModels(ctx, u.client, u.url)
+		if errors.Is(err, errModelsUnsupported) {
+			log.Printf("%s server at %s doesn't list models, skipping validation of %q", u.name, u.url, u.model)
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: could not validate %s model %q: %v", u.name, u.model, err)
+			continue
+		}
+		if !slices.Contains(models, u.model) {
+			problems = append(problems, fmt.Errorf("%s: model %q is not served by %s (available: %s)",
+				u.key, u.model, u.url, strings.Join(models, ", ")))
+			continue
+		}
+		log.Printf("%s model %q is available at %s", u.name, u.model, u.url)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return errors.Join(problems...)
+}
+
+// errModelsUnsupported means the server has no /v1/models endpoint (common for TTS servers)
+var errModelsUnsupported = errors.New("model listing not supported")
 
-	client := &http.Client{}
+// listModels returns the model IDs served by an OpenAI-compatible server
+func listModels(ctx context.Context, client *http.Client, baseURL string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/v1/models", nil)
+	if err != nil {
+		return nil, err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Warning: TTS warmup request failed: %v", err)
-		return false
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("Warning: TTS warmup request returned status %d: %s", resp.StatusCode, string(body))
-		return false
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		return nil, errModelsUnsupported
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("GET %s/v1/models returned status %d", baseURL, resp.StatusCode)
+	}
+
+	var list ModelList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to parse model list: %w", err)
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, model := range list.Data {
+		models = append(models, model.ID)
 	}
+	return models, nil
+}
+
+// sendWarmupRequest sends a single warmup request to the TTS service. It bypasses
+// the audio cache, as the point is to load the model.
+func (h *LocalPipelineHandler) sendWarmupRequest(text string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	// Read and discard the response body
-	bytesRead, err := io.Copy(io.Discard, resp.Body)
+	pcm, err := h.requestSpeech(ctx, h.personas.Current(), text)
 	if err != nil {
-		log.Printf("Warning: TTS warmup failed to read response: %v", err)
+		log.Printf("Warning: TTS warmup request failed: %v", err)
 		return false
 	}
-
-	log.Printf("TTS warmup request succeeded: %d bytes", bytesRead)
+	log.Printf("TTS warmup request succeeded: %d bytes", len(pcm))
 	return true
 }
 
+// checkHTTP issues a GET to url, requiring wantStatus (or any status below 500 when 0)
+func checkHTTP(ctx context.Context, client *http.Client, url string, wantStatus int) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if (wantStatus != 0 && resp.StatusCode != wantStatus) || resp.StatusCode >= 500 {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
 // StreamLLMResponse calls the local LLM and streams text deltas back to the client
-func (h *LocalPipelineHandler) StreamLLMResponse(ctx context.Context, userMessage string, sendJSON func(ServerMessage) error) (string, error) {
+func (h *LocalPipelineHandler) StreamLLMResponse(ctx context.Context, p *persona.Persona, userMessage string, sendJSON func(ServerMessage) error) (string, Usage, error) {
 	// Prepare chat completion request
 	reqBody := ChatCompletionRequest{
-		Model: "qwen2.5-7b-instruct",
+		Model: h.llmModel,
 		Messages: []Message{
-			{Role: "system", Content: h.systemPrompt},
+			{Role: "system", Content: p.Prompt},
 			{Role: "user", Content: userMessage},
 		},
-		Stream: true,
+		Stream:        true,
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+		Temperature:   h.temperature,
+		TopP:          h.topP,
+		MaxTokens:     h.maxTokens,
+		Stop:          h.stop,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return "", Usage{}, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Make streaming request to local LLM
 	req, err := http.NewRequestWithContext(ctx, "POST", h.llmURL+"/v1/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", Usage{}, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	log.Printf("Calling local LLM at %s", h.llmURL)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := h.llm.Do(req, false) // Streamed, so never retried
+
 	if err != nil {
-		return "", fmt.Errorf("failed to call LLM: %w", err)
+		return "", Usage{}, fmt.Errorf("failed to call LLM: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("LLM returned status %d: %s", resp.StatusCode, string(body))
+		return "", Usage{}, fmt.Errorf("LLM returned status %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Stream response back to client
 	var fullResponse strings.Builder
+	var usage Usage
 	reader := bufio.NewReader(resp.Body)
 
 	for {
@@ -227,7 +440,7 @@ func (h *LocalPipelineHandler) StreamLLMResponse(ctx context.Context, userMessag
 			if err == io.EOF {
 				break
 			}
-			return "", fmt.Errorf("error reading stream: %w", err)
+			return "", Usage{}, fmt.Errorf("error reading stream: %w", err)
 		}
 
 		// Skip empty lines
@@ -252,6 +465,12 @@ func (h *LocalPipelineHandler) StreamLLMResponse(ctx context.Context, userMessag
 				continue
 			}
 
+			// Usage arrives in a final chunk with no choices
+			if chunk.Usage != nil {
+				usage.InputTokens = chunk.Usage.PromptTokens
+				usage.OutputTokens = chunk.Usage.CompletionTokens
+			}
+
 			// Extract content delta
 			if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
 				content := chunk.Choices[0].Delta.Content
@@ -262,7 +481,7 @@ func (h *LocalPipelineHandler) StreamLLMResponse(ctx context.Context, userMessag
 					Type: "text_delta",
 					Text: content,
 				}); err != nil {
-					return "", fmt.Errorf("failed to send text delta: %w", err)
+					return "", Usage{}, fmt.Errorf("failed to send text delta: %w", err)
 				}
 			}
 		}
@@ -270,83 +489,107 @@ func (h *LocalPipelineHandler) StreamLLMResponse(ctx context.Context, userMessag
 
 	response := fullResponse.String()
 	log.Printf("LLM response complete: %d characters", len(response))
-	return response, nil
+	return response, usage, nil
 }
 
 // GenerateAndStreamAudio converts text to speech and streams audio chunks
-func (h *LocalPipelineHandler) GenerateAndStreamAudio(ctx context.Context, text string, sendJSON func(ServerMessage) error) error {
+func (h *LocalPipelineHandler) GenerateAndStreamAudio(ctx context.Context, p *persona.Persona, text string, sendJSON func(ServerMessage) error) error {
 	log.Printf("Generating audio for %d characters of text", len(text))
+	pcmData, err := h.synthesize(ctx, p, text)
+	if err != nil {
+		return err
+	}
+	if err := streamPCM(pcmData, sendJSON); err != nil {
+		return err
+	}
+
+	log.Printf("Audio streaming complete")
+	return nil
+}
+
+// synthesize returns text spoken in p's voice as PCM16 24kHz, from the audio cache
+// when the same text has been synthesized before
+func (h *LocalPipelineHandler) synthesize(ctx context.Context, p *persona.Persona, text string) ([]byte, error) {
+	key := cache.AudioKey(h.ttsModel, p.TTSVoice, p.TTSSpeed, text)
+	if pcm, ok := h.audio.Get(key); ok {
+		log.Printf("Using cached audio: %d bytes", len(pcm))
+		return pcm, nil
+	}
+	pcm, err := h.requestSpeech(ctx, p, text)
+	if err != nil {
+		return nil, err
+	}
+	h.audio.Put(key, pcm)
+	return pcm, nil
+}
 
+// requestSpeech calls the TTS server and returns the audio as PCM16 24kHz
+func (h *LocalPipelineHandler) requestSpeech(ctx context.Context, p *persona.Persona, text string) ([]byte, error) {
 	// Call TTS API (OpenAI-compatible)
 	ttsReq := TTSRequest{
-		Model:          "neutss-air-4b",
+		Model:          h.ttsModel,
 		Input:          text,
-		Voice:          h.ttsVoice,
-		ResponseFormat: "wav",
-		Speed:          h.ttsSpeed,
+		Voice:          p.TTSVoice,
+		ResponseFormat: h.ttsFormat,
+		Speed:          p.TTSSpeed,
 	}
 
 	jsonData, err := json.Marshal(ttsReq)
 	if err != nil {
-		return fmt.Errorf("failed to marshal TTS request: %w", err)
+		return nil, fmt.Errorf("failed to marshal TTS request: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", h.ttsURL+"/v1/audio/speech", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create TTS request: %w", err)
+		return nil, fmt.Errorf("failed to create TTS request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	log.Printf("Calling TTS API at %s", h.ttsURL)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := h.tts.Do(req, true) // Speech synthesis is idempotent, so safe to retry
 	if err != nil {
-		return fmt.Errorf("failed to call TTS API: %w", err)
+		return nil, fmt.Errorf("failed to call TTS API: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("TTS API returned status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("TTS API returned status %d: %s", resp.StatusCode, string(body))
 	}
 
-	// Read WAV data from response
-	wavData, err := io.ReadAll(resp.Body)
+	audio, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read TTS response: %w", err)
+		return nil, fmt.Errorf("failed to read TTS response: %w", err)
 	}
 
-	log.Printf("Generated WAV audio: %d bytes", len(wavData))
+	log.Printf("Generated %s audio: %d bytes", h.ttsFormat, len(audio))
 
-	// Convert WAV to PCM16 24kHz (compatible with frontend)
-	pcmData, err := convertWAVToPCM16(wavData)
+	// Raw "pcm" is already PCM16 24kHz; WAV is converted to match (compatible with frontend)
+	if h.ttsFormat == "pcm" {
+		return audio, nil
+	}
+	pcmData, err := convertWAVToPCM16(audio)
 	if err != nil {
-		return fmt.Errorf("failed to convert WAV to PCM16: %w", err)
+		return nil, fmt.Errorf("failed to convert WAV to PCM16: %w", err)
 	}
-
 	log.Printf("Converted to PCM16: %d bytes", len(pcmData))
+	return pcmData, nil
+}
 
-	// Stream audio in chunks (base64 encoded)
-	// Use 4KB chunks to match OpenAI's chunk size
-	chunkSize := 4096
-	for i := 0; i < len(pcmData); i += chunkSize {
-		end := i + chunkSize
-		if end > len(pcmData) {
-			end = len(pcmData)
-		}
-
-		chunk := pcmData[i:end]
-		base64Chunk := base64.StdEncoding.EncodeToString(chunk)
+// Audio is streamed in 4KB chunks to match OpenAI's chunk size
+const AudioChunkSize = 4096
 
+// streamPCM sends PCM16 audio to the client as base64 audio_delta chunks
+func streamPCM(pcmData []byte, sendJSON func(ServerMessage) error) error {
+	for i := 0; i < len(pcmData); i += AudioChunkSize {
+		end := min(i+AudioChunkSize, len(pcmData))
 		if err := sendJSON(ServerMessage{
 			Type:  "audio_delta",
-			Audio: base64Chunk,
+			Audio: base64.StdEncoding.EncodeToString(pcmData[i:end]),
 		}); err != nil {
 			return fmt.Errorf("failed to send audio delta: %w", err)
 		}
 	}
-
-	log.Printf("Audio streaming complete")
 	return nil
 }
 
@@ -437,13 +680,41 @@ func convertWAVToPCM16(wavData []byte) ([]byte, error) {
 	return pcmData, nil
 }
 
-// HandleLocalPipeline processes a message through the local LLM + TTS pipeline
-func (h *LocalPipelineHandler) HandleLocalPipeline(ctx context.Context, userMessage string, clientWS *websocket.Conn, wsMutex *websocket.Conn, sendJSON func(ServerMessage) error) error {
-	// Step 1: Stream LLM response (sends text_delta messages)
-	fullText, err := h.StreamLLMResponse(ctx, userMessage, sendJSON)
+// HandleLocalPipeline processes a message through the local LLM + TTS pipeline.
+// With the "text" modality the TTS step is skipped.
+func (h *LocalPipelineHandler) HandleLocalPipeline(ctx context.Context, p *persona.Persona, userMessage, modality string, clientWS *websocket.Conn, wsMutex *websocket.Conn, sendJSON func(ServerMessage) error, budget *SessionBudget) error {
+	// Step 1: Stream LLM response (sends text_delta messages). When guardrails are
+	// enforced the text is held back until the whole answer has been checked.
+	send := sendJSON
+	var held []ServerMessage
+	if h.guard.Enforcing() {
+		send = func(msg ServerMessage) error {
+			held = append(held, msg)
+			return nil
+		}
+	}
+	fullText, usage, err := h.StreamLLMResponse(ctx, p, userMessage, send)
 	if err != nil {
 		return fmt.Errorf("LLM streaming failed: %w", err)
 	}
+	budget.RecordLocal(usage)
+
+	if h.guard.Enforcing() {
+		fixed, violations := h.guard.Apply(fullText, p)
+		changed := fixed != fullText
+		reportViolations(BackendLocal, violations, changed)
+		if changed {
+			fullText = fixed
+			held = []ServerMessage{{Type: "text_delta", Text: fixed}}
+		}
+		for _, msg := range held {
+			if err := sendJSON(msg); err != nil {
+				return err
+			}
+		}
+	} else {
+		reportViolations(BackendLocal, h.guard.Check(fullText, p), false)
+	}
 
 	// Send text_done message
 	if err := sendJSON(ServerMessage{Type: "text_done"}); err != nil {
@@ -451,13 +722,15 @@ func (h *LocalPipelineHandler) HandleLocalPipeline(ctx context.Context, userMess
 	}
 
 	// Step 2: Generate and stream audio (sends audio_delta messages)
-	if err := h.GenerateAndStreamAudio(ctx, fullText, sendJSON); err != nil {
-		return fmt.Errorf("audio generation failed: %w", err)
-	}
+	if modality != "text" {
+		if err := h.GenerateAndStreamAudio(ctx, p, fullText, sendJSON); err != nil {
+			return fmt.Errorf("audio generation failed: %w", err)
+		}
 
-	// Send audio_done message
-	if err := sendJSON(ServerMessage{Type: "audio_done"}); err != nil {
-		return err
+		// Send audio_done message
+		if err := sendJSON(ServerMessage{Type: "audio_done"}); err != nil {
+			return err
+		}
 	}
 
 	// Send response_done message
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/persona"
+	"christianmoore.me/avatar-backend/upstream"
+)
+
+// newTestLocalPipeline returns a pipeline pointed at the given LLM and TTS servers
+func newTestLocalPipeline(t *testing.T, llmURL, ttsURL string) *LocalPipelineHandler {
+	t.Helper()
+	upstreamCfg := config.UpstreamConfig{ConnectTimeout: time.Second, ResponseHeaderTimeout: time.Second, BreakerThreshold: 3}
+	llm, err := upstream.New("llm", upstreamCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tts, err := upstream.New("tts", upstreamCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &LocalPipelineHandler{
+		llmURL:    llmURL,
+		llmModel:  "qwen2.5-7b-instruct",
+		ttsURL:    ttsURL,
+		ttsModel:  "neutss-air-4b",
+		ttsFormat: "wav",
+		llm:       llm,
+		tts:       tts,
+	}
+}
+
+func TestValidateModels(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[{"id":"qwen2.5-7b-instruct"},{"id":"llama-3.1-8b"}]}`))
+	}))
+	defer llm.Close()
+	// TTS servers often have no model listing; that's skipped rather than an error
+	tts := httptest.NewServer(http.NotFoundHandler())
+	defer tts.Close()
+
+	h := newTestLocalPipeline(t, llm.URL, tts.URL)
+	if err := h.ValidateModels(context.Background()); err != nil {
+		t.Errorf("Expected models to validate, got %v", err)
+	}
+
+	h.llmModel = "mistral-7b"
+	err := h.ValidateModels(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "LOCAL_LLM_MODEL") {
+		t.Errorf("Expected missing model error, got %v", err)
+	}
+
+	// An unreachable server is left to readiness instead of failing startup
+	h.llmURL = "http://127.0.0.1:1"
+	if err := h.ValidateModels(context.Background()); err != nil {
+		t.Errorf("Expected unreachable server to be skipped, got %v", err)
+	}
+}
+
+func TestStreamLLMResponseSendsSamplingParams(t *testing.T) {
+	var got ChatCompletionRequest
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer llm.Close()
+
+	h := newTestLocalPipeline(t, llm.URL, "")
+	temperature, topP := 0.0, 0.9
+	h.temperature = &temperature
+	h.topP = &topP
+	h.maxTokens = 256
+	h.stop = []string{"\nUser:"}
+
+	p := persona.New("You are Christian.", "test", "cedar", "onyx", 1)
+	text, _, err := h.StreamLLMResponse(context.Background(), p, "Hello", func(ServerMessage) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Hi" {
+		t.Errorf("Expected streamed text, got %q", text)
+	}
+	if got.Temperature == nil || *got.Temperature != 0 || got.TopP == nil || *got.TopP != 0.9 {
+		t.Errorf("Expected temperature 0 and top_p 0.9 to be sent, got %v / %v", got.Temperature, got.TopP)
+	}
+	if got.MaxTokens != 256 || !reflect.DeepEqual(got.Stop, []string{"\nUser:"}) {
+		t.Errorf("Unexpected max_tokens/stop: %d %v", got.MaxTokens, got.Stop)
+	}
+}
+
+func TestGenerateAudioPCMFormat(t *testing.T) {
+	pcm := []byte{1, 2, 3, 4}
+	var got TTSRequest
+	tts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write(pcm)
+	}))
+	defer tts.Close()
+
+	h := newTestLocalPipeline(t, "", tts.URL)
+	h.ttsFormat = "pcm"
+
+	var audio []string
+	p := persona.New("You are Christian.", "test", "cedar", "echo", 1.2)
+	err := h.GenerateAndStreamAudio(context.Background(), p, "Hello.", func(msg ServerMessage) error {
+		audio = append(audio, msg.Audio)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ResponseFormat != "pcm" || got.Voice != "echo" || got.Model != "neutss-air-4b" {
+		t.Errorf("Unexpected TTS request: %+v", got)
+	}
+	// Raw PCM is streamed without WAV conversion
+	if len(audio) != 1 || audio[0] != base64.StdEncoding.EncodeToString(pcm) {
+		t.Errorf("Expected PCM to be passed through, got %v", audio)
+	}
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"christianmoore.me/avatar-backend/cache"
+	"christianmoore.me/avatar-backend/metrics"
+	"christianmoore.me/avatar-backend/persona"
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+)
+
+// answerVariant describes the settings besides the prompt that shape an answer from
+// backend, so answers are only reused where they would sound the same
+func answerVariant(backend string, p *persona.Persona, opts SessionOptions) string {
+	if backend == BackendLocal {
+		return fmt.Sprintf("%s/%s/%g", backend, p.TTSVoice, p.TTSSpeed)
+	}
+	return fmt.Sprintf("%s/%s/%d", backend, opts.Voice, opts.MaxOutputTokens)
+}
+
+// answerScope groups the cached answers that may be reused for each other's questions:
+// those generated with the same prompt and variant
+func answerScope(p *persona.Persona, variant string) string {
+	return p.Version + "/" + variant
+}
+
+// answerCapture collects the text and audio streamed for one answer, with when each
+// chunk was sent, so it can be cached and replayed at the same pace
+type answerCapture struct {
+	key      string
+	backend  string
+	modality string
+	chunks   []cache.Chunk
+	audio    []byte
+	started  time.Time // When the first chunk was sent
+	invalid  bool      // Audio that couldn't be decoded was sent
+
+	// Indexed by the semantic cache along with the answer; embedding is nil when the
+	// semantic cache is off or the question couldn't be embedded
+	scope     string
+	question  string
+	embedding []float32
+}
+
+// add records an outgoing message that is part of the answer. Calls must not overlap.
+func (c *answerCapture) add(msg ServerMessage) {
+	chunk := cache.Chunk{}
+	switch msg.Type {
+	case "text_delta":
+		chunk = cache.Chunk{Kind: cache.ChunkText, Text: msg.Text}
+	case "text_done":
+		chunk.Kind = cache.ChunkTextDone
+	case "audio_delta":
+		pcm, err := base64.StdEncoding.DecodeString(msg.Audio)
+		if err != nil {
+			c.invalid = true
+			return
+		}
+		c.audio = append(c.audio, pcm...)
+		chunk = cache.Chunk{Kind: cache.ChunkAudio, Audio: len(pcm)}
+	case "audio_done":
+		chunk.Kind = cache.ChunkAudioDone
+	default:
+		return
+	}
+
+	now := time.Now()
+	if c.started.IsZero() {
+		c.started = now
+	}
+	chunk.Offset = now.Sub(c.started)
+	c.chunks = append(c.chunks, chunk)
+}
+
+// storeAnswer caches the captured answer, unless it's incomplete
+func (h *ChatHandler) storeAnswer(c *answerCapture) {
+	var chunks []cache.Chunk
+	text := 0
+	for _, chunk := range c.chunks {
+		if c.modality == "text" && isAudioChunk(chunk) {
+			continue
+		}
+		if chunk.Kind == cache.ChunkText {
+			text++
+		}
+		chunks = append(chunks, chunk)
+	}
+	if c.invalid || text == 0 || (c.modality != "text" && len(c.audio) == 0) {
+		return
+	}
+	var audio []byte
+	if c.modality != "text" {
+		audio = c.audio
+	}
+	h.responses.Put(c.key, &cache.Answer{Chunks: chunks, Audio: audio, Backend: c.backend})
+	h.semantic.Add(c.key, c.scope, c.question, c.embedding)
+	log.Printf("Cached %s answer (%d text chunks, %d audio bytes)", c.backend, text, len(audio))
+}
+
+// isAudioChunk reports whether chunk is only sent for the audio modality
+func isAudioChunk(chunk cache.Chunk) bool {
+	return chunk.Kind == cache.ChunkAudio || chunk.Kind == cache.ChunkAudioDone
+}
+
+// cachedAnswer returns the cached answer for key if it can serve the modality
+func (h *ChatHandler) cachedAnswer(key, modality string) (*cache.Answer, bool) {
+	answer, ok := h.responses.Get(key)
+	if !ok || (modality != "text" && answer.Audio == nil) {
+		metrics.ResponseCache.Add("misses", 1)
+		return nil, false
+	}
+	metrics.ResponseCache.Add("hits", 1)
+	return answer, true
+}
+
+// similarAnswer looks for a cached answer to a question similar to question, for
+// when there's no answer to the exact question. It also returns the question's
+// embedding (nil if it couldn't be embedded) so the answer generated on a miss can be
+// indexed. In shadow mode matches are only logged, to tune the threshold.
+func (h *ChatHandler) similarAnswer(ctx context.Context, scope, question, modality string) ([]float32, *cache.Answer, bool) {
+	if h.embedder == nil {
+		return nil, nil, false
+	}
+	embedding, err := h.embedder.Embed(ctx, question)
+	if err != nil {
+		// The cache is an optimization; answer normally without it
+		metrics.SemanticCache.Add("errors", 1)
+		log.Printf("Failed to embed question for the semantic cache: %v", err)
+		return nil, nil, false
+	}
+
+	match, ok := h.semantic.Nearest(scope, embedding)
+	if !ok || match.Similarity < h.cfg.SemanticCacheThreshold {
+		metrics.SemanticCache.Add("misses", 1)
+		return embedding, nil, false
+	}
+	answer, ok := h.responses.Get(match.Key)
+	if !ok {
+		h.semantic.Remove(match.Key) // Expired or evicted
+	}
+	if !ok || (modality != "text" && answer.Audio == nil) {
+		metrics.SemanticCache.Add("misses", 1)
+		return embedding, nil, false
+	}
+	if h.cfg.SemanticCacheMode != "on" {
+		metrics.SemanticCache.Add("shadow_hits", 1)
+		log.Printf("Semantic cache (shadow): %q would be answered as %q (similarity %.3f)", question, match.Question, match.Similarity)
+		return embedding, nil, false
+	}
+	metrics.SemanticCache.Add("hits", 1)
+	log.Printf("Semantic cache: answering %q as %q (similarity %.3f)", question, match.Question, match.Similarity)
+	return embedding, answer, true
+}
+
+// streamCachedAnswer replays a cached answer with the events, order and pacing it was
+// originally streamed with, so text and audio arrive interleaved like a live turn.
+// Audio is left out for the text modality.
+func streamCachedAnswer(ctx context.Context, a *cache.Answer, modality string, sendJSON func(ServerMessage) error) error {
+	start := time.Now()
+	audio := a.Audio
+	for _, chunk := range a.Chunks {
+		if modality == "text" && isAudioChunk(chunk) {
+			continue
+		}
+		if wait := time.Until(start.Add(chunk.Offset)); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		var msg ServerMessage
+		switch chunk.Kind {
+		case cache.ChunkText:
+			msg = ServerMessage{Type: "text_delta", Text: chunk.Text}
+		case cache.ChunkTextDone:
+			msg = ServerMessage{Type: "text_done"}
+		case cache.ChunkAudio:
+			n := min(chunk.Audio, len(audio))
+			msg = ServerMessage{Type: "audio_delta", Audio: base64.StdEncoding.EncodeToString(audio[:n])}
+			audio = audio[n:]
+		case cache.ChunkAudioDone:
+			msg = ServerMessage{Type: "audio_done"}
+		}
+		if err := sendJSON(msg); err != nil {
+			return err
+		}
+	}
+	return sendJSON(ServerMessage{Type: "response_done"})
+}
+
+// cachedTurnItems returns the conversation items for a turn answered from the cache,
+// so a Realtime conversation continuing after it knows what was asked and answered
+func cachedTurnItems(question string, a *cache.Answer) []openairt.ConversationItemCreateEvent {
+	return []openairt.ConversationItemCreateEvent{
+		{
+			Item: openairt.MessageItemUnion{
+				User: &openairt.MessageItemUser{
+					Content: []openairt.MessageContentInput{
+						{Type: openairt.MessageContentTypeInputText, Text: question},
+					},
+				},
+			},
+		},
+		{
+			Item: openairt.MessageItemUnion{
+				Assistant: &openairt.MessageItemAssistant{
+					Content: []openairt.MessageContentOutput{
+						{Type: openairt.MessageContentTypeOutputText, Text: a.Text()},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"christianmoore.me/avatar-backend/metrics"
+	"christianmoore.me/avatar-backend/origins"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// NewRouter builds the HTTP router with middleware and every route
+func NewRouter(authHandler *AuthHandler, chatHandler *ChatHandler, adminHandler *AdminHandler, originPolicy *origins.Policy) *gin.Engine {
+	router := gin.Default()
+
+	// Configure trusted proxies (Kubernetes service mesh)
+	// Trust k3s default pod (10.42.0.0/16) and service (10.43.0.0/16) CIDRs
+	router.SetTrustedProxies([]string{"10.42.0.0/16", "10.43.0.0/16"})
+
+	// Configure CORS
+	router.Use(cors.New(cors.Config{
+		AllowOriginFunc: func(origin string) bool {
+			return originPolicy.Allow(origin, "cors")
+		},
+		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Upgrade", "Connection", "Sec-WebSocket-Protocol", "X-API-Key"},
+		ExposeHeaders:    []string{"Content-Length"},
+		AllowCredentials: true,
+	}))
+
+	// API Routes
+	api := router.Group("/api")
+	{
+		api.POST("/verify-turnstile", authHandler.HandleVerifyTurnstile)
+		api.GET("/turnstile-sitekey", authHandler.HandleGetSiteKey)
+		api.POST("/token", authHandler.HandleGetToken) // Simple JWT issuance (rate-limited by Traefik)
+		api.POST("/chat", chatHandler.HandleChat)      // Single HTTP message (requires an API key with the chat scope)
+	}
+
+	// Routes
+	router.GET("/health", chatHandler.HandleHealth)
+	router.GET("/livez", chatHandler.HandleLivez)        // Process is up (liveness probe)
+	router.GET("/readyz", chatHandler.HandleReadyz)      // Dependencies and drain state (readiness probe)
+	router.GET("/metrics", gin.WrapH(metrics.Handler())) // expvar counters (not routed publicly by the ingress)
+	router.GET("/ws/chat", chatHandler.HandleWebSocket)  // WebSocket endpoint (requires JWT)
+
+	// Admin routes (require ADMIN_TOKEN; not exposed on the public frontend host)
+	admin := router.Group("/admin", adminHandler.RequireAdmin)
+	{
+		admin.GET("/persona", adminHandler.HandleGetPersona)
+		admin.POST("/persona/reload", adminHandler.HandleReloadPersona)
+		admin.GET("/cache/responses", adminHandler.HandleGetResponseCache)
+		admin.POST("/cache/responses/purge", adminHandler.HandlePurgeResponseCache)
+	}
+
+	return router
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/persona"
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+)
+
+// SessionOptions are the Realtime settings for one connection. They start from config
+// and the persona, may be overridden by the client within the configured allowlists,
+// and are echoed back in the session_created event.
+type SessionOptions struct {
+	Voice           string `json:"voice"`
+	OutputModality  string `json:"output_modality"`             // "audio" (with transcript) or "text"
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"` // 0 = model maximum
+	TurnDetection   string `json:"turn_detection,omitempty"`
+}
+
+// newSessionOptions applies the client's connect-time overrides (query parameters
+// voice, modality and max_output_tokens) to the configured defaults
+func newSessionOptions(cfg *config.Config, p *persona.Persona, query url.Values) (SessionOptions, error) {
+	opts := SessionOptions{
+		Voice:           p.RealtimeVoice,
+		OutputModality:  cfg.RealtimeOutputModality,
+		MaxOutputTokens: cfg.RealtimeMaxOutputTokens,
+		TurnDetection:   cfg.RealtimeTurnDetection,
+	}
+
+	if voice := strings.ToLower(query.Get("voice")); voice != "" && voice != opts.Voice {
+		if !slices.ContainsFunc(cfg.SessionVoices, func(v string) bool { return strings.EqualFold(v, voice) }) {
+			return opts, fmt.Errorf("voice %q is not available", voice)
+		}
+		opts.Voice = voice
+	}
+
+	if modality := strings.ToLower(query.Get("modality")); modality != "" && modality != opts.OutputModality {
+		if !slices.Contains(cfg.SessionModalities, modality) {
+			return opts, fmt.Errorf("modality %q is not available", modality)
+		}
+		opts.OutputModality = modality
+	}
+
+	// Clients may only lower the output cap, never raise it. Config validation keeps the
+	// default within the API's limit; a client cap under the "model maximum" default
+	// is clamped to it.
+	if raw := query.Get("max_output_tokens"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return opts, fmt.Errorf("max_output_tokens must be a positive integer")
+		}
+		if opts.MaxOutputTokens == 0 || limit < opts.MaxOutputTokens {
+			opts.MaxOutputTokens = min(limit, config.MaxRealtimeOutputTokens)
+		}
+	}
+	return opts, nil
+}
+
+// realtimeSession builds the Realtime session configuration for these options
+func (o SessionOptions) realtimeSession(prompt string) *openairt.RealtimeSession {
+	session := &openairt.RealtimeSession{
+		Instructions: prompt,
+		Audio: &openairt.RealtimeSessionAudio{
+			Output: &openairt.SessionAudioOutput{
+				Voice: openairt.Voice(o.Voice),
+				// Note: Do NOT set Format field - causes audio distortion
+			},
+		},
+		// Audio includes a text transcript; text skips audio generation entirely
+		OutputModalities: []openairt.Modality{openairt.Modality(o.OutputModality)},
+	}
+	if o.MaxOutputTokens > 0 {
+		session.MaxOutputTokens = openairt.IntOrInf(o.MaxOutputTokens)
+	}
+
+	switch o.TurnDetection {
+	case "server_vad":
+		session.Audio.Input = &openairt.SessionAudioInput{
+			TurnDetection: &openairt.TurnDetectionUnion{ServerVad: &openairt.ServerVad{CreateResponse: true}},
+		}
+	case "semantic_vad":
+		session.Audio.Input = &openairt.SessionAudioInput{
+			TurnDetection: &openairt.TurnDetectionUnion{SemanticVad: &openairt.RealtimeSessionSemanticVad{CreateResponse: true}},
+		}
+	}
+	return session
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/persona"
+)
+
+func TestRealtimeSession(t *testing.T) {
+	opts := SessionOptions{Voice: "marin", OutputModality: "text", MaxOutputTokens: 512, TurnDetection: "semantic_vad"}
+	data, err := json.Marshal(opts.realtimeSession("You are Christian."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"voice":"marin"`, `"output_modalities":["text"]`, `"max_output_tokens":512`, `"type":"semantic_vad"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected session to contain %s, got %s", want, data)
+		}
+	}
+
+	// Unset options are left to the API defaults
+	data, _ = json.Marshal(SessionOptions{Voice: "cedar", OutputModality: "audio"}.realtimeSession("prompt"))
+	if strings.Contains(string(data), "max_output_tokens") || strings.Contains(string(data), "turn_detection") {
+		t.Errorf("Expected defaults to be omitted, got %s", data)
+	}
+}
+
+func TestSessionOutputCapClamped(t *testing.T) {
+	p := persona.New("You are Christian.", "test", "cedar", "onyx", 1)
+	cfg := &config.Config{RealtimeOutputModality: "audio"}
+
+	// With the model maximum as the default, a client cap above the API limit is clamped
+	opts, err := newSessionOptions(cfg, p, url.Values{"max_output_tokens": {"100000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MaxOutputTokens != config.MaxRealtimeOutputTokens {
+		t.Errorf("Expected the cap to be clamped to %d, got %d", config.MaxRealtimeOutputTokens, opts.MaxOutputTokens)
+	}
+
+	// A configured default above the limit is refused at startup
+	cfg.RealtimeMaxOutputTokens = config.MaxRealtimeOutputTokens + 1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "REALTIME_MAX_OUTPUT_TOKENS") {
+		t.Errorf("Expected REALTIME_MAX_OUTPUT_TOKENS to be rejected, got %v", err)
+	}
+}
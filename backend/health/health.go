@@ -0,0 +1,155 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check statuses reported by Result.Status
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+	StatusPending = "pending" // Not checked yet
+)
+
+// Result is the cached outcome of a dependency check
+type Result struct {
+	Status    string     `json:"status"`
+	Critical  bool       `json:"critical"` // Failing critical checks make the service not ready
+	Error     string     `json:"error,omitempty"`
+	LatencyMS int64      `json:"latency_ms"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+type check struct {
+	name     string
+	critical bool
+	every    time.Duration // 0 = evaluated on every report (cheap in-process state)
+	timeout  time.Duration
+	fn       func(ctx context.Context) error
+
+	mu     sync.Mutex
+	result Result
+	next   time.Time
+}
+
+// Checker runs dependency checks in the background and caches their results,
+// so probes never wait on (or hammer) upstream services
+type Checker struct {
+	mu     sync.Mutex
+	checks []*check
+	now    func() time.Time
+}
+
+func NewChecker() *Checker {
+	return &Checker{now: time.Now}
+}
+
+// Add registers a check run every interval (0 = on every Report) with the given timeout
+func (c *Checker) Add(name string, critical bool, every, timeout time.Duration, fn func(ctx context.Context) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, &check{
+		name:     name,
+		critical: critical,
+		every:    every,
+		timeout:  timeout,
+		fn:       fn,
+		result:   Result{Status: StatusPending, Critical: critical},
+	})
+}
+
+// Run refreshes due checks until ctx is cancelled
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		c.Refresh(ctx, false)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh runs every periodic check that is due (or all of them when force is set) in parallel
+func (c *Checker) Refresh(ctx context.Context, force bool) {
+	c.mu.Lock()
+	checks := append([]*check{}, c.checks...)
+	c.mu.Unlock()
+
+	now := c.now()
+	var wg sync.WaitGroup
+	for _, chk := range checks {
+		chk.mu.Lock()
+		due := chk.every > 0 && (force || !now.Before(chk.next))
+		if due {
+			chk.next = now.Add(chk.every)
+		}
+		chk.mu.Unlock()
+
+		if due {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.run(ctx, chk)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// run executes a check and stores its result
+func (c *Checker) run(ctx context.Context, chk *check) Result {
+	if chk.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, chk.timeout)
+		defer cancel()
+	}
+
+	start := c.now()
+	err := chk.fn(ctx)
+	result := Result{
+		Status:    StatusOK,
+		Critical:  chk.critical,
+		LatencyMS: c.now().Sub(start).Milliseconds(),
+		CheckedAt: &start,
+	}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+
+	chk.mu.Lock()
+	chk.result = result
+	chk.mu.Unlock()
+	return result
+}
+
+// Report returns whether every critical check passes, with per-check results.
+// Periodic checks report their cached result; pending critical checks count as not ready.
+func (c *Checker) Report(ctx context.Context) (bool, map[string]Result) {
+	c.mu.Lock()
+	checks := append([]*check{}, c.checks...)
+	c.mu.Unlock()
+
+	ready := true
+	results := make(map[string]Result, len(checks))
+	for _, chk := range checks {
+		var result Result
+		if chk.every == 0 {
+			result = c.run(ctx, chk)
+		} else {
+			chk.mu.Lock()
+			result = chk.result
+			chk.mu.Unlock()
+		}
+		if chk.critical && result.Status != StatusOK {
+			ready = false
+		}
+		results[chk.name] = result
+	}
+	return ready, results
+}
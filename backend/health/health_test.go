@@ -0,0 +1,101 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReportPendingCriticalIsNotReady(t *testing.T) {
+	c := NewChecker()
+	c.Add("llm", true, time.Minute, time.Second, func(ctx context.Context) error { return nil })
+
+	ready, results := c.Report(context.Background())
+	if ready {
+		t.Error("Expected unchecked critical dependency to be not ready")
+	}
+	if results["llm"].Status != StatusPending {
+		t.Errorf("Expected pending status, got %q", results["llm"].Status)
+	}
+
+	c.Refresh(context.Background(), true)
+	ready, results = c.Report(context.Background())
+	if !ready || results["llm"].Status != StatusOK {
+		t.Errorf("Expected ready after a passing check, got ready=%v %+v", ready, results["llm"])
+	}
+}
+
+func TestReportOptionalFailureStaysReady(t *testing.T) {
+	c := NewChecker()
+	c.Add("realtime", true, time.Minute, time.Second, func(ctx context.Context) error { return nil })
+	c.Add("tts", false, time.Minute, time.Second, func(ctx context.Context) error { return errors.New("connection refused") })
+	c.Refresh(context.Background(), true)
+
+	ready, results := c.Report(context.Background())
+	if !ready {
+		t.Error("Expected failing optional check not to affect readiness")
+	}
+	if results["tts"].Status != StatusFailing || results["tts"].Error != "connection refused" {
+		t.Errorf("Expected failing tts result with error, got %+v", results["tts"])
+	}
+}
+
+func TestRefreshCachesResults(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewChecker()
+	c.now = func() time.Time { return now }
+
+	var calls atomic.Int32
+	c.Add("llm", true, 30*time.Second, time.Second, func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	})
+
+	c.Refresh(context.Background(), false)
+	c.Refresh(context.Background(), false)
+	c.Report(context.Background())
+	if calls.Load() != 1 {
+		t.Errorf("Expected 1 check within the interval, got %d", calls.Load())
+	}
+
+	now = now.Add(30 * time.Second)
+	c.Refresh(context.Background(), false)
+	if calls.Load() != 2 {
+		t.Errorf("Expected check to rerun after the interval, got %d calls", calls.Load())
+	}
+}
+
+func TestInstantChecksRunOnReport(t *testing.T) {
+	c := NewChecker()
+	var draining atomic.Bool
+	c.Add("drain", true, 0, 0, func(ctx context.Context) error {
+		if draining.Load() {
+			return errors.New("draining")
+		}
+		return nil
+	})
+
+	if ready, _ := c.Report(context.Background()); !ready {
+		t.Error("Expected ready before draining")
+	}
+	draining.Store(true)
+	if ready, _ := c.Report(context.Background()); ready {
+		t.Error("Expected not ready immediately after draining starts")
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	c := NewChecker()
+	c.Add("slow", true, time.Minute, 10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c.Refresh(context.Background(), true)
+
+	_, results := c.Report(context.Background())
+	if results["slow"].Status != StatusFailing {
+		t.Errorf("Expected timed out check to fail, got %+v", results["slow"])
+	}
+}
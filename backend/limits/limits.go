@@ -0,0 +1,52 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLimitExceeded is returned when a connection limit has been reached
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Limit is a rate of one event per Every, allowing bursts of up to Burst events
+type Limit struct {
+	Every time.Duration
+	Burst int
+}
+
+// LimitStore tracks connection counts and rate limits.
+// Implementations backed by shared storage enforce limits across all replicas.
+type LimitStore interface {
+	// AcquireConnection takes one of max connection slots for key and returns a lease ID.
+	// Leases expire after ttl unless refreshed, so crashed replicas don't leak slots.
+	// Returns ErrLimitExceeded (and the current count) when all slots are taken.
+	AcquireConnection(ctx context.Context, key string, max int, ttl time.Duration) (lease string, count int, err error)
+
+	// RefreshConnection extends a lease for another ttl
+	RefreshConnection(ctx context.Context, key, lease string, ttl time.Duration) error
+
+	// ReleaseConnection frees a lease and returns the remaining connection count
+	ReleaseConnection(ctx context.Context, key, lease string) (int, error)
+
+	// Allow reports whether one more event for key is permitted under limit
+	Allow(ctx context.Context, key string, limit Limit) (bool, error)
+
+	// AddCounters increments key's named counters by deltas and returns all of its counters.
+	// Empty deltas just read them. Each add keeps the counters for another ttl.
+	AddCounters(ctx context.Context, key string, deltas map[string]int64, ttl time.Duration) (map[string]int64, error)
+}
+
+// gcra applies the generic cell rate algorithm: tat is the stored theoretical arrival time
+// (zero if none). Returns whether the event is allowed and the new tat to store.
+func gcra(now, tat time.Time, limit Limit) (bool, time.Time) {
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(limit.Every)
+	allowAt := newTAT.Add(-time.Duration(limit.Burst) * limit.Every)
+	if now.Before(allowAt) {
+		return false, tat
+	}
+	return true, newTAT
+}
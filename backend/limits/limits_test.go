@@ -0,0 +1,161 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// storeFactory creates a store whose clock is controlled by the returned pointer
+type storeFactory func(t *testing.T) (LimitStore, *time.Time)
+
+func memoryFactory(t *testing.T) (LimitStore, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func redisFactory(t *testing.T) (LimitStore, *time.Time) {
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore("redis://"+server.Addr(), "test:")
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func forEachStore(t *testing.T, test func(t *testing.T, factory storeFactory)) {
+	t.Run("memory", func(t *testing.T) { test(t, memoryFactory) })
+	t.Run("redis", func(t *testing.T) { test(t, redisFactory) })
+}
+
+func TestConnectionLimit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, factory storeFactory) {
+		store, _ := factory(t)
+		ctx := context.Background()
+
+		first, count, err := store.AcquireConnection(ctx, "conn:1.2.3.4", 2, time.Minute)
+		if err != nil || count != 1 {
+			t.Fatalf("First acquire: count=%d err=%v", count, err)
+		}
+		if _, count, err = store.AcquireConnection(ctx, "conn:1.2.3.4", 2, time.Minute); err != nil || count != 2 {
+			t.Fatalf("Second acquire: count=%d err=%v", count, err)
+		}
+
+		if _, count, err = store.AcquireConnection(ctx, "conn:1.2.3.4", 2, time.Minute); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("Expected ErrLimitExceeded, got count=%d err=%v", count, err)
+		}
+
+		// Other keys are independent
+		if _, _, err := store.AcquireConnection(ctx, "conn:5.6.7.8", 2, time.Minute); err != nil {
+			t.Errorf("Acquire for other key failed: %v", err)
+		}
+
+		remaining, err := store.ReleaseConnection(ctx, "conn:1.2.3.4", first)
+		if err != nil || remaining != 1 {
+			t.Fatalf("Release: remaining=%d err=%v", remaining, err)
+		}
+		if _, _, err := store.AcquireConnection(ctx, "conn:1.2.3.4", 2, time.Minute); err != nil {
+			t.Errorf("Acquire after release failed: %v", err)
+		}
+	})
+}
+
+func TestConnectionLeaseExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, factory storeFactory) {
+		store, now := factory(t)
+		ctx := context.Background()
+
+		if _, _, err := store.AcquireConnection(ctx, "conn", 2, time.Minute); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		live, _, err := store.AcquireConnection(ctx, "conn", 2, time.Minute)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+
+		// Only the live lease is refreshed; the stale one (crashed replica) expires
+		*now = now.Add(45 * time.Second)
+		if err := store.RefreshConnection(ctx, "conn", live, time.Minute); err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+		*now = now.Add(30 * time.Second)
+
+		if _, count, err := store.AcquireConnection(ctx, "conn", 2, time.Minute); err != nil || count != 2 {
+			t.Errorf("Expected stale lease to expire: count=%d err=%v", count, err)
+		}
+	})
+}
+
+func TestAllow(t *testing.T) {
+	forEachStore(t, func(t *testing.T, factory storeFactory) {
+		store, now := factory(t)
+		ctx := context.Background()
+		limit := Limit{Every: 5 * time.Second, Burst: 3}
+
+		for i := 0; i < 3; i++ {
+			if allowed, err := store.Allow(ctx, "msg:session", limit); err != nil || !allowed {
+				t.Fatalf("Burst request %d should be allowed (err=%v)", i+1, err)
+			}
+		}
+		if allowed, _ := store.Allow(ctx, "msg:session", limit); allowed {
+			t.Error("Request beyond burst should be denied")
+		}
+
+		// Other keys have their own bucket
+		if allowed, _ := store.Allow(ctx, "msg:other", limit); !allowed {
+			t.Error("Request for other key should be allowed")
+		}
+
+		// One token is replenished after the interval
+		*now = now.Add(5 * time.Second)
+		if allowed, _ := store.Allow(ctx, "msg:session", limit); !allowed {
+			t.Error("Request after interval should be allowed")
+		}
+		if allowed, _ := store.Allow(ctx, "msg:session", limit); allowed {
+			t.Error("Second request after one interval should be denied")
+		}
+	})
+}
+
+func TestAddCounters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, factory storeFactory) {
+		store, _ := factory(t)
+		ctx := context.Background()
+
+		values, err := store.AddCounters(ctx, "budget:global", nil, time.Hour)
+		if err != nil || len(values) != 0 {
+			t.Fatalf("Expected no counters before any add: values=%v err=%v", values, err)
+		}
+
+		store.AddCounters(ctx, "budget:global", map[string]int64{"tokens": 10, "cost": 5}, time.Hour)
+		values, err = store.AddCounters(ctx, "budget:global", map[string]int64{"tokens": 15}, time.Hour)
+		if err != nil || values["tokens"] != 25 || values["cost"] != 5 {
+			t.Fatalf("Expected tokens=25 cost=5, got %v (err=%v)", values, err)
+		}
+
+		// Other keys are independent
+		if values, _ := store.AddCounters(ctx, "budget:other", nil, time.Hour); len(values) != 0 {
+			t.Errorf("Expected other key to be empty, got %v", values)
+		}
+	})
+}
+
+func TestAddCountersExpiry(t *testing.T) {
+	store, now := memoryFactory(t)
+	ctx := context.Background()
+
+	store.AddCounters(ctx, "budget:global", map[string]int64{"tokens": 10}, time.Hour)
+	*now = now.Add(time.Hour)
+	if values, _ := store.AddCounters(ctx, "budget:global", nil, time.Hour); len(values) != 0 {
+		t.Errorf("Expected counters to expire, got %v", values)
+	}
+}
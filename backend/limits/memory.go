@@ -0,0 +1,158 @@
+package limits
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the bucket count above which idle rate limit buckets are swept
+const maxIdleBuckets = 1024
+
+// MemoryStore is a process-local LimitStore (limits apply per replica)
+type MemoryStore struct {
+	mu       sync.Mutex
+	leases   map[string]map[string]time.Time // key -> lease -> expiry
+	tats     map[string]time.Time            // key -> theoretical arrival time
+	counters map[string]*counterSet          // key -> named counters
+	now      func() time.Time
+}
+
+// counterSet is one key's counters and when they expire
+type counterSet struct {
+	values    map[string]int64
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates an empty in-process store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		leases:   make(map[string]map[string]time.Time),
+		tats:     make(map[string]time.Time),
+		counters: make(map[string]*counterSet),
+		now:      time.Now,
+	}
+}
+
+// pruneLeases drops expired leases for key; caller must hold s.mu
+func (s *MemoryStore) pruneLeases(key string, now time.Time) map[string]time.Time {
+	leases := s.leases[key]
+	for lease, expiry := range leases {
+		if !expiry.After(now) {
+			delete(leases, lease)
+		}
+	}
+	if len(leases) == 0 {
+		delete(s.leases, key)
+		return nil
+	}
+	return leases
+}
+
+func (s *MemoryStore) AcquireConnection(ctx context.Context, key string, max int, ttl time.Duration) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	leases := s.pruneLeases(key, now)
+	if len(leases) >= max {
+		return "", len(leases), ErrLimitExceeded
+	}
+	if leases == nil {
+		leases = make(map[string]time.Time)
+		s.leases[key] = leases
+	}
+
+	lease := newLeaseID()
+	leases[lease] = now.Add(ttl)
+	return lease, len(leases), nil
+}
+
+func (s *MemoryStore) RefreshConnection(ctx context.Context, key, lease string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if leases := s.leases[key]; leases != nil {
+		if _, ok := leases[lease]; ok {
+			leases[lease] = s.now().Add(ttl)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReleaseConnection(ctx context.Context, key, lease string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if leases := s.leases[key]; leases != nil {
+		delete(leases, lease)
+	}
+	return len(s.pruneLeases(key, s.now())), nil
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	allowed, tat := gcra(now, s.tats[key], limit)
+	s.tats[key] = tat
+
+	// Drop idle buckets so the map doesn't grow with every visitor
+	if len(s.tats) > maxIdleBuckets {
+		for k, t := range s.tats {
+			if t.Before(now) {
+				delete(s.tats, k)
+			}
+		}
+	}
+	return allowed, nil
+}
+
+func (s *MemoryStore) AddCounters(ctx context.Context, key string, deltas map[string]int64, ttl time.Duration) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	set := s.counters[key]
+	if set != nil && !set.expiresAt.After(now) {
+		delete(s.counters, key)
+		set = nil
+	}
+	if len(deltas) > 0 {
+		if set == nil {
+			set = &counterSet{values: make(map[string]int64)}
+			s.counters[key] = set
+		}
+		for name, delta := range deltas {
+			set.values[name] += delta
+		}
+		set.expiresAt = now.Add(ttl)
+	}
+
+	// Drop expired counters so the map doesn't grow with every visitor
+	if len(s.counters) > maxIdleBuckets {
+		for k, c := range s.counters {
+			if !c.expiresAt.After(now) {
+				delete(s.counters, k)
+			}
+		}
+	}
+
+	values := make(map[string]int64)
+	if set != nil {
+		for name, value := range set.values {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// newLeaseID returns a random identifier for a connection lease
+func newLeaseID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
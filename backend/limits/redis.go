@@ -0,0 +1,151 @@
+package limits
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Connection slots are a sorted set of lease IDs scored by expiry (unix ms)
+var acquireScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local count = redis.call('ZCARD', KEYS[1])
+if count >= tonumber(ARGV[3]) then
+	return {0, count}
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return {1, count + 1}
+`)
+
+var refreshScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+var releaseScript = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+return redis.call('ZCARD', KEYS[1])
+`)
+
+// Rate limits store the GCRA theoretical arrival time (unix ms)
+var allowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local every = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local tat = now
+local stored = redis.call('GET', KEYS[1])
+if stored then
+	tat = math.max(tonumber(stored), now)
+end
+local new_tat = tat + every
+if now < new_tat - burst * every then
+	return 0
+end
+redis.call('SET', KEYS[1], new_tat, 'PX', new_tat - now)
+return 1
+`)
+
+// Counters are a hash of named integers; ARGV is the TTL (ms) then name/delta pairs
+var addCountersScript = redis.NewScript(`
+for i = 2, #ARGV, 2 do
+	redis.call('HINCRBY', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+if #ARGV > 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return redis.call('HGETALL', KEYS[1])
+`)
+
+// RedisStore is a LimitStore shared by all replicas through Redis
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	now    func() time.Time
+}
+
+// NewRedisStore connects to Redis at url (redis://[user:pass@]host:port/db) and namespaces keys with prefix
+func NewRedisStore(url, prefix string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &RedisStore{client: client, prefix: prefix, now: time.Now}, nil
+}
+
+// Close closes the underlying Redis client
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisStore) AcquireConnection(ctx context.Context, key string, max int, ttl time.Duration) (string, int, error) {
+	now := s.now()
+	lease := newLeaseID()
+
+	result, err := acquireScript.Run(ctx, s.client, []string{s.prefix + key},
+		now.UnixMilli(), now.Add(ttl).UnixMilli(), max, lease, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return "", 0, err
+	}
+	if result[0] == 0 {
+		return "", int(result[1]), ErrLimitExceeded
+	}
+	return lease, int(result[1]), nil
+}
+
+func (s *RedisStore) RefreshConnection(ctx context.Context, key, lease string, ttl time.Duration) error {
+	return refreshScript.Run(ctx, s.client, []string{s.prefix + key},
+		s.now().Add(ttl).UnixMilli(), lease, ttl.Milliseconds()).Err()
+}
+
+func (s *RedisStore) ReleaseConnection(ctx context.Context, key, lease string) (int, error) {
+	count, err := releaseScript.Run(ctx, s.client, []string{s.prefix + key},
+		s.now().UnixMilli(), lease).Int()
+	return count, err
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit) (bool, error) {
+	allowed, err := allowScript.Run(ctx, s.client, []string{s.prefix + key},
+		s.now().UnixMilli(), limit.Every.Milliseconds(), limit.Burst).Int()
+	if err != nil {
+		return false, err
+	}
+	return allowed == 1, nil
+}
+
+func (s *RedisStore) AddCounters(ctx context.Context, key string, deltas map[string]int64, ttl time.Duration) (map[string]int64, error) {
+	args := []any{ttl.Milliseconds()}
+	for name, delta := range deltas {
+		args = append(args, name, delta)
+	}
+
+	fields, err := addCountersScript.Run(ctx, s.client, []string{s.prefix + key}, args...).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid counter %s: %w", fields[i], err)
+		}
+		values[fields[i]] = value
+	}
+	return values, nil
+}
@@ -1,63 +1,175 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"christianmoore.me/avatar-backend/config"
 	"christianmoore.me/avatar-backend/handlers"
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
+	"christianmoore.me/avatar-backend/health"
+	"christianmoore.me/avatar-backend/limits"
+	"christianmoore.me/avatar-backend/origins"
+	"christianmoore.me/avatar-backend/persona"
 )
 
 func main() {
+	// Cancelled on SIGTERM (Kubernetes rollout) or SIGINT (Ctrl+C) to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// Load configuration
 	cfg := config.Load()
 
-	// Validate required configuration
-	if !cfg.UseLocalPipeline && cfg.OpenAIAPIKey == "" {
-		log.Fatal("OPENAI_API_KEY is required when not using local pipeline")
+	// Validate configuration, listing every problem before exiting
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
 	// Initialize auth handler
 	authHandler := handlers.NewAuthHandler(cfg.JWTSecret, cfg.TurnstileSecret, cfg.TurnstileSiteKey)
+	authHandler.SetJWTExpiration(cfg.JWTExpiration)
+
+	// Load API keys for trusted server-to-server integrations (optional)
+	if cfg.APIKeysFile != "" {
+		apiKeys, err := handlers.LoadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			log.Fatalf("Failed to load API keys: %v", err)
+		}
+		authHandler.SetAPIKeyStore(apiKeys)
+		log.Printf("Loaded %d API keys from %s", apiKeys.Len(), cfg.APIKeysFile)
+	}
+
+	// Initialize usage budgets for the paid Realtime API
+	budget := handlers.NewBudgetTracker(handlers.BudgetConfig{
+		SessionTokens:      cfg.BudgetSessionTokens,
+		SessionCostUSD:     cfg.BudgetSessionCostUSD,
+		IPDailyTokens:      cfg.BudgetIPDailyTokens,
+		IPDailyCostUSD:     cfg.BudgetIPDailyCostUSD,
+		GlobalDailyTokens:  cfg.BudgetGlobalDailyTokens,
+		GlobalDailyCostUSD: cfg.BudgetGlobalDailyCostUSD,
+		FallbackToLocal:    cfg.BudgetFallbackToLocal,
+		Pricing: handlers.TokenPricing{
+			TextInputPerMTok:   cfg.PriceTextInputPerMTok,
+			AudioInputPerMTok:  cfg.PriceAudioInputPerMTok,
+			TextOutputPerMTok:  cfg.PriceTextOutputPerMTok,
+			AudioOutputPerMTok: cfg.PriceAudioOutputPerMTok,
+
+			CachedTextInputPerMTok:  cfg.PriceCachedTextInputPerMTok,
+			CachedAudioInputPerMTok: cfg.PriceCachedAudioInputPerMTok,
+		},
+	})
+
+	// Serve the system prompt and voices from a store that can be swapped at runtime
+	personas := persona.NewStore(personaFromConfig(cfg))
+	if err := personas.Current().Validate(); err != nil {
+		log.Fatalf("Invalid persona: %v", err)
+	}
+	log.Printf("Serving prompt version %s", personas.Current().Version)
+
+	// Reload the persona when the prompt or config file changes, or on SIGHUP. The
+	// configured prompt path is watched even when it's missing and the fallback file
+	// was loaded, so creating it on the data volume takes effect.
+	watched := []string{cfg.SystemPromptPath, cfg.ConfigFile}
+	if cfg.SystemPromptFile != cfg.SystemPromptPath {
+		watched = append(watched, cfg.SystemPromptFile)
+	}
+	watcher := persona.NewWatcher(personas, reloadPersona, watched, cfg.PersonaReloadInterval)
+	go watcher.Run(ctx)
+
+	// One origin policy shared by CORS and the WebSocket origin check (validated above)
+	originPolicy, err := origins.NewPolicy(cfg.CORSOrigins)
+	if err != nil {
+		log.Fatalf("Invalid CORS_ORIGINS: %v", err)
+	}
 
 	// Initialize chat handler
-	chatHandler, err := handlers.NewChatHandler(cfg.OpenAIAPIKey, cfg.OpenAIModel, cfg.SystemPrompt, authHandler, cfg.UseLocalPipeline, cfg.LocalLLMURL, cfg.TTSURL, cfg.TTSVoice, cfg.TTSSpeed)
+	chatHandler, err := handlers.NewChatHandler(cfg, authHandler, budget, personas, originPolicy)
 	if err != nil {
 		log.Fatalf("Failed to initialize chat handler: %v", err)
 	}
 
-	// Setup Gin router
-	router := gin.Default()
-
-	// Configure trusted proxies (Kubernetes service mesh)
-	// Trust k3s default pod (10.42.0.0/16) and service (10.43.0.0/16) CIDRs
-	router.SetTrustedProxies([]string{"10.42.0.0/16", "10.43.0.0/16"})
-
-	// Configure CORS
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000", "https://christianmoore.me"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Upgrade", "Connection", "Sec-WebSocket-Protocol"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-	}))
+	// Cache dependency checks for the readiness probe
+	checker := health.NewChecker()
+	chatHandler.EnableHealthChecks(checker, cfg.ReadinessCheckInterval, cfg.RealtimeCheckInterval)
+	go checker.Run(ctx)
 
-	// API Routes
-	api := router.Group("/api")
-	{
-		api.POST("/verify-turnstile", authHandler.HandleVerifyTurnstile)
-		api.GET("/turnstile-sitekey", authHandler.HandleGetSiteKey)
-		api.POST("/token", authHandler.HandleGetToken) // Simple JWT issuance (rate-limited by Traefik)
+	// Share connection limits, rate limits and daily budgets across replicas via Redis (optional)
+	if cfg.RedisURL != "" {
+		limitStore, err := limits.NewRedisStore(cfg.RedisURL, cfg.RedisKeyPrefix)
+		if err != nil {
+			log.Fatalf("Failed to initialize Redis limit store: %v", err)
+		}
+		defer limitStore.Close()
+		authHandler.SetLimitStore(limitStore)
+		chatHandler.SetLimitStore(limitStore)
+		budget.SetLimitStore(limitStore)
+		log.Printf("Using Redis limit store (cluster-wide limits)")
+	} else {
+		limitStore := limits.NewMemoryStore()
+		authHandler.SetLimitStore(limitStore)
+		chatHandler.SetLimitStore(limitStore)
+		budget.SetLimitStore(limitStore)
+		log.Printf("Using in-memory limit store (per-replica limits)")
 	}
 
-	// Routes
-	router.GET("/health", chatHandler.HandleHealth)
-	router.GET("/ws/chat", chatHandler.HandleWebSocket) // WebSocket endpoint (requires JWT)
+	// Setup Gin router with all routes
+	adminHandler := handlers.NewAdminHandler(cfg.AdminToken, personas, watcher)
+	adminHandler.SetResponseCache(chatHandler.ResponseCache())
+	router := handlers.NewRouter(authHandler, chatHandler, adminHandler, originPolicy)
 
 	// Start server
-	log.Printf("Server starting on port %s", cfg.Port)
-	if err := router.Run(":" + cfg.Port); err != nil {
+	srv := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: router,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Server starting on port %s", cfg.Port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatal(err)
+	case <-ctx.Done():
+		stop() // A second signal kills the process immediately
+	}
+
+	// Drain WebSocket sessions first (the server keeps answering health checks with
+	// "draining" meanwhile), then stop the HTTP server. Hijacked WebSocket connections
+	// aren't tracked by Shutdown, hence the separate drain.
+	log.Printf("Shutdown signal received, draining connections (timeout %v)", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := chatHandler.Drain(shutdownCtx); err != nil {
+		log.Printf("Warning: drain incomplete: %v", err)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: HTTP server shutdown incomplete: %v", err)
+	}
+	log.Printf("Server stopped")
+}
+
+// personaFromConfig builds the reloadable persona settings from the configuration
+func personaFromConfig(cfg *config.Config) *persona.Persona {
+	return persona.New(cfg.SystemPrompt, cfg.SystemPromptFile, cfg.RealtimeVoice, cfg.TTSVoice, cfg.TTSSpeed)
+}
+
+// reloadPersona re-reads the configuration and prompt file. Unlike startup, a missing
+// prompt file is an error so a broken volume doesn't replace the persona with the fallback text.
+func reloadPersona() (*persona.Persona, error) {
+	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.SystemPromptFile == "" {
+		return nil, fmt.Errorf("system prompt file %s could not be read", cfg.SystemPromptPath)
 	}
+	return personaFromConfig(cfg), nil
 }
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// Counters published via expvar (served as JSON on /metrics)
+var (
+	// API key usage, keyed by key name
+	APIKeyRequests   = expvar.NewMap("api_key_requests")
+	APIKeyRejections = expvar.NewMap("api_key_rejections")
+
+	// Token usage keyed by backend, paid Realtime spend, and budget ceilings hit keyed by scope
+	TokensUsed     = expvar.NewMap("tokens_used")
+	CostUSD        = expvar.NewFloat("realtime_cost_usd")
+	BudgetExceeded = expvar.NewMap("budget_exceeded")
+
+	// Persona hot reloads keyed by outcome (succeeded/failed)
+	PersonaReloads = expvar.NewMap("persona_reloads")
+
+	// Requests from origins outside the origin policy, keyed by caller (cors/websocket)
+	OriginsRejected = expvar.NewMap("origins_rejected")
+
+	// Circuit breakers opened keyed by upstream, and per-turn backend failovers keyed by target backend
+	BreakerTrips     = expvar.NewMap("breaker_trips")
+	BackendFailovers = expvar.NewMap("backend_failovers")
+
+	// HTTP retries keyed by upstream (llm/tts)
+	UpstreamRetries = expvar.NewMap("upstream_retries")
+
+	// Upstream TLS file reloads, keyed by "<upstream>_succeeded" or "<upstream>_failed"
+	TLSReloads = expvar.NewMap("tls_reloads")
+
+	// Answer cache lookups and changes, keyed by hits/misses/stores/evictions
+	ResponseCache = expvar.NewMap("response_cache")
+
+	// Synthesized speech cache lookups and changes, keyed by memory_hits/disk_hits/misses/stores/disk_evictions
+	TTSCache = expvar.NewMap("tts_cache")
+
+	// Similar-question lookups after exact cache misses, keyed by hits/shadow_hits/misses/errors
+	SemanticCache = expvar.NewMap("semantic_cache")
+
+	// Moderated messages keyed by "<check>_<action>" (e.g. pattern_block), and checks that failed under "errors"
+	Moderation = expvar.NewMap("moderation")
+
+	// Answer guardrail violations keyed by check (length/prompt_leak/contact), and answers changed under "fixed"
+	Guardrails = expvar.NewMap("guardrails")
+)
+
+// Handler returns an HTTP handler that serves all published metrics as JSON
+func Handler() http.Handler {
+	return expvar.Handler()
+}
@@ -0,0 +1,88 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/upstream"
+)
+
+// APIRequest is a moderation request for one input
+type APIRequest struct {
+	Model string `json:"model,omitempty"`
+	Input string `json:"input"`
+}
+
+// APIResponse carries one result per input
+type APIResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// API checks messages with an OpenAI-compatible /v1/moderations endpoint, applying
+// action to the messages it flags. It is safe for concurrent use.
+type API struct {
+	url    string
+	model  string
+	action Action
+	http   *upstream.Client
+}
+
+// NewAPI creates a check against the moderation endpoint at url
+func NewAPI(url, model string, action Action, cfg config.UpstreamConfig) (*API, error) {
+	client, err := upstream.New("moderation", cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &API{url: url, model: model, action: action, http: client}, nil
+}
+
+func (a *API) Check(ctx context.Context, text string) (Verdict, error) {
+	body, err := json.Marshal(APIRequest{Model: a.model, Input: text})
+	if err != nil {
+		return Verdict{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.url+"/v1/moderations", bytes.NewReader(body))
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.http.Do(req, true) // Classifying text is idempotent, so safe to retry
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Verdict{}, fmt.Errorf("moderation API returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	var result APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Verdict{}, fmt.Errorf("failed to parse moderation response: %w", err)
+	}
+	if len(result.Results) == 0 {
+		return Verdict{}, fmt.Errorf("moderation response has no result")
+	}
+	if !result.Results[0].Flagged {
+		return Verdict{}, nil
+	}
+	var categories []string
+	for category, flagged := range result.Results[0].Categories {
+		if flagged {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	return Verdict{Action: a.action, Check: "api", Reason: "flagged: " + strings.Join(categories, ", ")}, nil
+}
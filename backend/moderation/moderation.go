@@ -0,0 +1,133 @@
+// Package moderation screens visitor messages before they reach a model, with a rules
+// engine (prompt injection patterns, links, writing scripts) and optionally an
+// OpenAI-compatible moderation endpoint. Each check maps what it finds to an action.
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+
+	"christianmoore.me/avatar-backend/config"
+)
+
+// Action is what happens to a message a check objects to
+type Action string
+
+const (
+	ActionNone  Action = ""      // The message is answered normally
+	ActionFlag  Action = "flag"  // Answered normally, and noted in the log and session recording
+	ActionWarn  Action = "warn"  // Answered, with the model told to keep to its instructions
+	ActionBlock Action = "block" // Refused without reaching a model
+)
+
+// ParseAction converts a configured action; "off" (or unset) disables a check
+func ParseAction(value string) (Action, error) {
+	switch value {
+	case "", "off":
+		return ActionNone, nil
+	case "flag", "warn", "block":
+		return Action(value), nil
+	}
+	return ActionNone, fmt.Errorf("unknown moderation action %q (must be block, warn, flag or off)", value)
+}
+
+func (a Action) severity() int {
+	switch a {
+	case ActionFlag:
+		return 1
+	case ActionWarn:
+		return 2
+	case ActionBlock:
+		return 3
+	}
+	return 0
+}
+
+// Verdict is the outcome of screening one message
+type Verdict struct {
+	Action Action `json:"action"`
+	Check  string `json:"check,omitempty"`  // Check that decided the action: pattern, links, script or api
+	Reason string `json:"reason,omitempty"` // What it found, for logs; never shown to the visitor
+}
+
+// Checker screens a message. An error means the check couldn't run; callers answer
+// the message anyway rather than fail closed.
+type Checker interface {
+	Check(ctx context.Context, text string) (Verdict, error)
+}
+
+// Chain runs checkers in order and returns the most severe verdict, stopping at the
+// first block. Checkers that fail are skipped and their errors returned along with
+// the verdict of the others. A nil Chain allows everything.
+type Chain []Checker
+
+func (c Chain) Check(ctx context.Context, text string) (Verdict, error) {
+	var verdict Verdict
+	var errs []error
+	for _, checker := range c {
+		v, err := checker.Check(ctx, text)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if v.Action.severity() > verdict.Action.severity() {
+			verdict = v
+		}
+		if verdict.Action == ActionBlock {
+			break
+		}
+	}
+	return verdict, errors.Join(errs...)
+}
+
+// New builds the checks enabled in cfg, which must have passed Validate
+func New(cfg *config.Config) (Chain, error) {
+	var chain Chain
+
+	rules := &Rules{MaxLinks: cfg.ModerationMaxLinks}
+	var err error
+	if rules.PatternAction, err = ParseAction(cfg.ModerationPatternAction); err != nil {
+		return nil, err
+	}
+	if rules.LinkAction, err = ParseAction(cfg.ModerationLinkAction); err != nil {
+		return nil, err
+	}
+	if rules.ScriptAction, err = ParseAction(cfg.ModerationScriptAction); err != nil {
+		return nil, err
+	}
+	for _, pattern := range cfg.ModerationPatterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid moderation pattern %q: %w", pattern, err)
+		}
+		rules.Patterns = append(rules.Patterns, re)
+	}
+	for _, name := range cfg.ModerationScripts {
+		table, ok := unicode.Scripts[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown script %q", name)
+		}
+		rules.Scripts = append(rules.Scripts, table)
+	}
+	if rules.enabled() {
+		chain = append(chain, rules)
+	}
+
+	if cfg.ModerationAPIURL != "" {
+		action, err := ParseAction(cfg.ModerationAPIAction)
+		if err != nil {
+			return nil, err
+		}
+		if action != ActionNone {
+			api, err := NewAPI(cfg.ModerationAPIURL, cfg.ModerationAPIModel, action, cfg.ModerationAPIUpstream)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, api)
+		}
+	}
+	return chain, nil
+}
@@ -0,0 +1,73 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"unicode"
+)
+
+// linkPattern matches the start of a link: a URL scheme or a www. host
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S`)
+
+// Rules checks messages locally, without calling any service
+type Rules struct {
+	// Messages matching any pattern, e.g. "ignore previous instructions"
+	Patterns      []*regexp.Regexp
+	PatternAction Action
+
+	// Messages with more than MaxLinks links (0 = any number)
+	MaxLinks   int
+	LinkAction Action
+
+	// Messages mostly written outside the allowed scripts (none = any script)
+	Scripts      []*unicode.RangeTable
+	ScriptAction Action
+}
+
+func (r *Rules) enabled() bool {
+	return (len(r.Patterns) > 0 && r.PatternAction != ActionNone) ||
+		(r.MaxLinks > 0 && r.LinkAction != ActionNone) ||
+		(len(r.Scripts) > 0 && r.ScriptAction != ActionNone)
+}
+
+// Check returns the most severe verdict of the rules text breaks
+func (r *Rules) Check(ctx context.Context, text string) (Verdict, error) {
+	var verdict Verdict
+	consider := func(v Verdict) {
+		if v.Action.severity() > verdict.Action.severity() {
+			verdict = v
+		}
+	}
+
+	if r.PatternAction != ActionNone {
+		for _, re := range r.Patterns {
+			if match := re.FindString(text); match != "" {
+				consider(Verdict{Action: r.PatternAction, Check: "pattern", Reason: fmt.Sprintf("matched %q", match)})
+				break
+			}
+		}
+	}
+	if r.MaxLinks > 0 && r.LinkAction != ActionNone {
+		if n := len(linkPattern.FindAllStringIndex(text, -1)); n > r.MaxLinks {
+			consider(Verdict{Action: r.LinkAction, Check: "links", Reason: fmt.Sprintf("%d links (at most %d allowed)", n, r.MaxLinks)})
+		}
+	}
+	if len(r.Scripts) > 0 && r.ScriptAction != ActionNone {
+		letters, foreign := 0, 0
+		for _, c := range text {
+			if !unicode.IsLetter(c) {
+				continue
+			}
+			letters++
+			if !unicode.IsOneOf(r.Scripts, c) {
+				foreign++
+			}
+		}
+		// Allow the odd foreign name or word; object to messages mostly in another script
+		if foreign*2 > letters {
+			consider(Verdict{Action: r.ScriptAction, Check: "script", Reason: fmt.Sprintf("%d of %d letters outside the allowed scripts", foreign, letters)})
+		}
+	}
+	return verdict, nil
+}
@@ -0,0 +1,112 @@
+package origins
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"christianmoore.me/avatar-backend/metrics"
+)
+
+// Policy decides which browser origins may call the API and open WebSockets.
+// One policy is shared by the CORS middleware and the WebSocket origin check.
+//
+// Patterns take three forms:
+//   - exact origins: https://christianmoore.me
+//   - wildcard subdomains: https://*.christianmoore.me (any depth, not the apex)
+//   - regular expressions between slashes: /^https://pr-[0-9]+\.preview\.example\.com$/
+type Policy struct {
+	exact     map[string]bool
+	wildcards []wildcard
+	regexps   []*regexp.Regexp
+}
+
+// wildcard matches subdomains of suffix for a single scheme
+type wildcard struct {
+	scheme string
+	suffix string // Includes the leading dot, e.g. ".christianmoore.me"
+}
+
+// NewPolicy parses origin patterns, returning an error listing every invalid one
+func NewPolicy(patterns []string) (*Policy, error) {
+	p := &Policy{exact: make(map[string]bool)}
+	var invalid []string
+	for _, pattern := range patterns {
+		if err := p.add(pattern); err != nil {
+			invalid = append(invalid, err.Error())
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(invalid, "; "))
+	}
+	return p, nil
+}
+
+func (p *Policy) add(pattern string) error {
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return fmt.Errorf("%q is not a valid regular expression: %v", pattern, err)
+		}
+		p.regexps = append(p.regexps, re)
+		return nil
+	}
+
+	u, err := url.Parse(pattern)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return fmt.Errorf("%q must be an http(s) origin, *. wildcard or /regex/", pattern)
+	}
+
+	if host, ok := strings.CutPrefix(u.Host, "*."); ok {
+		if host == "" || strings.Contains(host, "*") {
+			return fmt.Errorf("%q has an invalid wildcard", pattern)
+		}
+		p.wildcards = append(p.wildcards, wildcard{scheme: u.Scheme, suffix: "." + strings.ToLower(host)})
+		return nil
+	}
+	if strings.Contains(u.Host, "*") {
+		return fmt.Errorf("%q has an invalid wildcard (only a leading *. is supported)", pattern)
+	}
+
+	p.exact[u.Scheme+"://"+strings.ToLower(u.Host)] = true
+	return nil
+}
+
+// Matches reports whether origin is allowed by the policy
+func (p *Policy) Matches(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	normalized := strings.ToLower(strings.TrimSuffix(origin, "/"))
+	if p.exact[normalized] {
+		return true
+	}
+
+	if scheme, host, ok := strings.Cut(normalized, "://"); ok {
+		for _, w := range p.wildcards {
+			if scheme == w.scheme && strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+				return true
+			}
+		}
+	}
+
+	for _, re := range p.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow checks origin for the named caller (e.g. "cors", "websocket"), counting and
+// logging rejections so misconfigured preview hosts are easy to spot
+func (p *Policy) Allow(origin, source string) bool {
+	if p.Matches(origin) {
+		return true
+	}
+	metrics.OriginsRejected.Add(source, 1)
+	log.Printf("Rejected %s request from origin %q", source, origin)
+	return false
+}
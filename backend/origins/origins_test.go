@@ -0,0 +1,68 @@
+package origins
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyMatches(t *testing.T) {
+	policy, err := NewPolicy([]string{
+		"https://christianmoore.me",
+		"http://localhost:5173",
+		"https://*.preview.christianmoore.me",
+		`/^https://pr-[0-9]+\.staging\.example\.com$/`,
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://christianmoore.me", true},
+		{"https://ChristianMoore.me", true},
+		{"https://christianmoore.me/", true},
+		{"http://christianmoore.me", false},
+		{"https://christianmoore.me.evil.com", false},
+		{"http://localhost:5173", true},
+		{"http://localhost:3000", false},
+		{"https://feature-x.preview.christianmoore.me", true},
+		{"https://a.b.preview.christianmoore.me", true},
+		{"https://preview.christianmoore.me", false},
+		{"http://feature-x.preview.christianmoore.me", false},
+		{"https://evilpreview.christianmoore.me", false},
+		{"https://pr-42.staging.example.com", true},
+		{"https://pr-x.staging.example.com", false},
+		{"", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if got := policy.Matches(tt.origin); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPolicyInvalid(t *testing.T) {
+	_, err := NewPolicy([]string{
+		"https://ok.example.com",
+		"not-a-url",
+		"https://example.com/path",
+		"https://foo.*.example.com",
+		"/[unclosed/",
+	})
+	if err == nil {
+		t.Fatal("Expected invalid patterns to be rejected")
+	}
+
+	// Every invalid pattern is reported
+	for _, want := range []string{"not-a-url", "/path", "foo.*", "[unclosed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package persona
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxPromptBytes caps the system prompt size (every session sends it to the model)
+const MaxPromptBytes = 64 * 1024
+
+// profileBlock matches a <profile>...</profile> block of the prompt: facts about the
+// person that answers may quote, as opposed to instructions to the model
+var profileBlock = regexp.MustCompile(`(?s)<profile>.*?</profile>`)
+
+// Persona is an immutable snapshot of the settings that can be reloaded at runtime.
+// Sessions take a snapshot when they start, so a reload only affects new sessions.
+type Persona struct {
+	Prompt        string
+	Instructions  string // Prompt without its profile blocks
+	Version       string // Short SHA-256 of the prompt
+	Source        string // Where the prompt was loaded from
+	LoadedAt      time.Time
+	RealtimeVoice string
+	TTSVoice      string
+	TTSSpeed      float64
+}
+
+// New creates a persona snapshot, computing the prompt version
+func New(prompt, source, realtimeVoice, ttsVoice string, ttsSpeed float64) *Persona {
+	return &Persona{
+		Prompt:        prompt,
+		Instructions:  profileBlock.ReplaceAllString(prompt, "\n"),
+		Version:       PromptVersion(prompt),
+		Source:        source,
+		LoadedAt:      time.Now(),
+		RealtimeVoice: realtimeVoice,
+		TTSVoice:      ttsVoice,
+		TTSSpeed:      ttsSpeed,
+	}
+}
+
+// PromptVersion returns a short content hash identifying a prompt
+func PromptVersion(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Validate checks that a persona is safe to serve
+func (p *Persona) Validate() error {
+	var problems []error
+	if strings.TrimSpace(p.Prompt) == "" {
+		problems = append(problems, errors.New("system prompt is empty"))
+	}
+	if len(p.Prompt) > MaxPromptBytes {
+		problems = append(problems, fmt.Errorf("system prompt is %d bytes (max %d)", len(p.Prompt), MaxPromptBytes))
+	}
+	if !utf8.ValidString(p.Prompt) {
+		problems = append(problems, errors.New("system prompt is not valid UTF-8"))
+	}
+	if p.RealtimeVoice == "" || p.TTSVoice == "" {
+		problems = append(problems, errors.New("voice must not be empty"))
+	}
+	if p.TTSSpeed < 0.25 || p.TTSSpeed > 4.0 {
+		problems = append(problems, fmt.Errorf("TTS speed %g out of range", p.TTSSpeed))
+	}
+	return errors.Join(problems...)
+}
+
+// Store holds the active persona and swaps it atomically
+type Store struct {
+	current atomic.Pointer[Persona]
+}
+
+// NewStore creates a store serving the given persona
+func NewStore(initial *Persona) *Store {
+	s := &Store{}
+	s.current.Store(initial)
+	return s
+}
+
+// Current returns the active persona
+func (s *Store) Current() *Persona {
+	return s.current.Load()
+}
+
+// Swap validates p and makes it the active persona for new sessions
+func (s *Store) Swap(p *Persona) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	s.current.Store(p)
+	return nil
+}
@@ -0,0 +1,166 @@
+package persona
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromptVersion(t *testing.T) {
+	a := PromptVersion("You are Christian.")
+	if len(a) != 12 {
+		t.Errorf("Expected 12 character version, got %q", a)
+	}
+	if a != PromptVersion("You are Christian.") {
+		t.Error("Expected the same prompt to have the same version")
+	}
+	if a == PromptVersion("You are someone else.") {
+		t.Error("Expected different prompts to have different versions")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		persona *Persona
+		wantErr string
+	}{
+		{"valid", New("prompt", "test", "cedar", "onyx", 1), ""},
+		{"empty prompt", New("  \n", "test", "cedar", "onyx", 1), "empty"},
+		{"oversized prompt", New(strings.Repeat("a", MaxPromptBytes+1), "test", "cedar", "onyx", 1), "max"},
+		{"invalid UTF-8", New("prompt \xff", "test", "cedar", "onyx", 1), "UTF-8"},
+		{"missing voice", New("prompt", "test", "", "onyx", 1), "voice"},
+		{"speed out of range", New("prompt", "test", "cedar", "onyx", 5), "speed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.persona.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestStoreSwap(t *testing.T) {
+	store := NewStore(New("first", "test", "cedar", "onyx", 1))
+
+	if err := store.Swap(New("", "test", "cedar", "onyx", 1)); err == nil {
+		t.Error("Expected invalid persona to be rejected")
+	}
+	if store.Current().Prompt != "first" {
+		t.Errorf("Expected rejected swap to keep the current persona, got %q", store.Current().Prompt)
+	}
+
+	if err := store.Swap(New("second", "test", "marin", "onyx", 1)); err != nil {
+		t.Fatalf("Expected valid persona to be accepted, got %v", err)
+	}
+	if store.Current().Prompt != "second" || store.Current().RealtimeVoice != "marin" {
+		t.Errorf("Expected swapped persona, got %+v", store.Current())
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system_prompt.txt")
+	if err := os.WriteFile(path, []byte("first"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	load := func() (*Persona, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return New(string(data), path, "cedar", "onyx", 1), nil
+	}
+
+	initial, _ := load()
+	store := NewStore(initial)
+	w := NewWatcher(store, load, []string{path}, 0)
+
+	if w.changed() {
+		t.Error("Expected no change before the file is modified")
+	}
+
+	// A valid edit is detected and swapped in
+	if err := os.WriteFile(path, []byte("second"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.changed() {
+		t.Fatal("Expected file change to be detected")
+	}
+	if _, err := w.Reload(); err != nil {
+		t.Fatalf("Expected reload to succeed, got %v", err)
+	}
+	if store.Current().Version != PromptVersion("second") {
+		t.Errorf("Expected version of new prompt, got %s", store.Current().Version)
+	}
+
+	// An invalid edit is rejected and the previous persona kept
+	if err := os.WriteFile(path, []byte(""), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Reload(); err == nil {
+		t.Error("Expected reload of empty prompt to fail")
+	}
+	if store.Current().Prompt != "second" {
+		t.Errorf("Expected previous persona to be kept, got %q", store.Current().Prompt)
+	}
+
+	// A missing file is rejected too
+	os.Remove(path)
+	if _, err := w.Reload(); err == nil {
+		t.Error("Expected reload of missing prompt to fail")
+	}
+	if store.Current().Prompt != "second" {
+		t.Errorf("Expected previous persona to be kept, got %q", store.Current().Prompt)
+	}
+}
+
+func TestInstructionsExcludeProfile(t *testing.T) {
+	p := New("Answer briefly.\n<profile>\nHe runs Kubernetes.\n</profile>\nStay on topic.<profile>He has cats.</profile>", "test", "cedar", "onyx", 1)
+	if p.Instructions != "Answer briefly.\n\n\nStay on topic.\n" {
+		t.Errorf("Unexpected instructions %q", p.Instructions)
+	}
+	if plain := New("Answer briefly.", "test", "cedar", "onyx", 1); plain.Instructions != plain.Prompt {
+		t.Errorf("Expected a prompt without profile blocks to be all instructions, got %q", plain.Instructions)
+	}
+}
+
+func TestWatcherDetectsCreatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system_prompt.txt")
+	load := func() (*Persona, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return New(string(data), path, "cedar", "onyx", 1), nil
+	}
+
+	// Start on the fallback prompt, with the configured file not there yet
+	store := NewStore(New("fallback", "system_prompt.txt", "cedar", "onyx", 1))
+	w := NewWatcher(store, load, []string{path, ""}, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	if err := os.WriteFile(path, []byte("created"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for store.Current().Prompt != "created" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the created file to be loaded, still serving %q", store.Current().Prompt)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,113 @@
+package persona
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"christianmoore.me/avatar-backend/metrics"
+)
+
+// Watcher reloads the persona when watched files change or the process receives SIGHUP
+type Watcher struct {
+	store    *Store
+	load     func() (*Persona, error)
+	paths    []string
+	interval time.Duration
+
+	mu     sync.Mutex
+	hashes map[string][32]byte
+}
+
+// NewWatcher creates a watcher that calls load to build a new persona whenever one of
+// paths changes (polled every interval; 0 disables polling) or on SIGHUP. Paths may
+// not exist yet; creating one counts as a change, and empty paths are ignored.
+func NewWatcher(store *Store, load func() (*Persona, error), paths []string, interval time.Duration) *Watcher {
+	w := &Watcher{
+		store:    store,
+		load:     load,
+		paths:    paths,
+		interval: interval,
+		hashes:   make(map[string][32]byte),
+	}
+	w.changed() // Record initial file contents
+	return w
+}
+
+// Run watches for changes until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// A nil channel blocks forever, disabling polling
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("SIGHUP received, reloading persona")
+			w.Reload()
+		case <-tick:
+			if w.changed() {
+				log.Printf("Persona files changed, reloading")
+				w.Reload()
+			}
+		}
+	}
+}
+
+// Reload builds and validates a new persona, keeping the current one on failure
+func (w *Watcher) Reload() (*Persona, error) {
+	p, err := w.load()
+	if err == nil {
+		err = w.store.Swap(p)
+	}
+	if err != nil {
+		metrics.PersonaReloads.Add("failed", 1)
+		log.Printf("Persona reload failed, keeping version %s: %v", w.store.Current().Version, err)
+		return nil, err
+	}
+
+	// Track the contents we just loaded so polling doesn't reload them again
+	w.changed()
+	metrics.PersonaReloads.Add("succeeded", 1)
+	log.Printf("Persona reloaded: prompt version %s (%d bytes) from %s", p.Version, len(p.Prompt), p.Source)
+	return p, nil
+}
+
+// changed reports whether any watched file's contents differ from the last check,
+// including a file appearing or disappearing. Content hashes (not mtimes) are compared
+// so Kubernetes ConfigMap symlink swaps are detected.
+func (w *Watcher) changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changed := false
+	for _, path := range w.paths {
+		if path == "" {
+			continue
+		}
+		var sum [32]byte // Zero for a missing file; load reports why it's missing
+		if data, err := os.ReadFile(path); err == nil {
+			sum = sha256.Sum256(data)
+		}
+		if prev, ok := w.hashes[path]; ok && prev != sum {
+			changed = true
+		}
+		w.hashes[path] = sum
+	}
+	return changed
+}
@@ -0,0 +1,191 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+)
+
+// Dialer wraps a Realtime API dialer so the traffic of every connection it opens is
+// recorded. With a nil Recorder, inner is returned unchanged.
+func (r *Recorder) Dialer(inner openairt.WebSocketDialer) openairt.WebSocketDialer {
+	if r == nil {
+		return inner
+	}
+	return &recordingDialer{inner: inner, rec: r}
+}
+
+type recordingDialer struct {
+	inner openairt.WebSocketDialer
+	rec   *Recorder
+}
+
+func (d *recordingDialer) Dial(ctx context.Context, url string, header http.Header) (openairt.WebSocketConn, error) {
+	conn, err := d.inner.Dial(ctx, url, header)
+	if err != nil {
+		d.rec.RecordError(SourceUpstreamDialError, err)
+		return nil, err
+	}
+	d.rec.Record(SourceUpstreamDial, nil)
+	return &recordingConn{WebSocketConn: conn, rec: d.rec}, nil
+}
+
+// recordingConn records raw messages in both directions
+type recordingConn struct {
+	openairt.WebSocketConn
+	rec    *Recorder
+	closed atomic.Bool
+}
+
+func (c *recordingConn) ReadMessage(ctx context.Context) (openairt.MessageType, []byte, error) {
+	messageType, data, err := c.WebSocketConn.ReadMessage(ctx)
+	switch {
+	case err == nil:
+		c.rec.RecordRaw(SourceUpstreamRecv, data)
+	case ctx.Err() == nil && !c.closed.Load():
+		// Reads cut short by the handler closing the connection aren't upstream behaviour
+		c.rec.RecordError(SourceUpstreamError, err)
+	}
+	return messageType, data, err
+}
+
+func (c *recordingConn) WriteMessage(ctx context.Context, messageType openairt.MessageType, data []byte) error {
+	c.rec.RecordRaw(SourceUpstreamSend, data)
+	return c.WebSocketConn.WriteMessage(ctx, messageType, data)
+}
+
+func (c *recordingConn) Close() error {
+	c.closed.Store(true)
+	return c.WebSocketConn.Close()
+}
+
+// Dialer returns a Realtime API dialer that replays the recorded upstream side of
+// the session: each Dial replays the next recorded connection (or dial failure), and
+// each message the handler sends releases the events the Realtime API sent in
+// response to it. Sending a different event type than was recorded fails the write,
+// so a replay that diverges from the recording shows up as an error.
+func (rec *Recording) Dialer() openairt.WebSocketDialer {
+	d := &replayDialer{}
+	for _, event := range rec.Events {
+		switch event.Source {
+		case SourceUpstreamDial, SourceUpstreamDialError:
+			d.connections = append(d.connections, []Event{event})
+		case SourceUpstreamSend, SourceUpstreamRecv, SourceUpstreamError:
+			if n := len(d.connections); n > 0 {
+				d.connections[n-1] = append(d.connections[n-1], event)
+			}
+		}
+	}
+	return d
+}
+
+type replayDialer struct {
+	mu          sync.Mutex
+	connections [][]Event // Each starts with the dial (or dial error) event
+}
+
+func (d *replayDialer) Dial(ctx context.Context, url string, header http.Header) (openairt.WebSocketConn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.connections) == 0 {
+		return nil, errors.New("replay: no more recorded Realtime connections")
+	}
+	script := d.connections[0]
+	d.connections = d.connections[1:]
+	if script[0].Source == SourceUpstreamDialError {
+		return nil, fmt.Errorf("replay: %s", errorMessage(script[0].Data))
+	}
+	return newReplayConn(script[1:]), nil
+}
+
+// replayConn serves one recorded connection
+type replayConn struct {
+	mu     sync.Mutex
+	script []Event
+	pos    int
+
+	ready     chan Event // Released upstream events (buffered for the whole script)
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newReplayConn(script []Event) *replayConn {
+	c := &replayConn{
+		script: script,
+		ready:  make(chan Event, len(script)),
+		closed: make(chan struct{}),
+	}
+	c.release() // Events sent on connect, e.g. session.created
+	return c
+}
+
+// release queues the recorded events up to the next message the handler sends
+func (c *replayConn) release() {
+	for c.pos < len(c.script) && c.script[c.pos].Source != SourceUpstreamSend {
+		c.ready <- c.script[c.pos]
+		c.pos++
+	}
+}
+
+func (c *replayConn) ReadMessage(ctx context.Context) (openairt.MessageType, []byte, error) {
+	select {
+	case event := <-c.ready:
+		if event.Source == SourceUpstreamError {
+			return 0, nil, fmt.Errorf("replay: %s", errorMessage(event.Data))
+		}
+		return openairt.MessageText, event.Data, nil
+	case <-c.closed:
+		return 0, nil, errors.New("replay: connection closed")
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+func (c *replayConn) WriteMessage(ctx context.Context, messageType openairt.MessageType, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sent := eventType(data)
+	if c.pos >= len(c.script) {
+		return fmt.Errorf("replay diverged: sent %s after the end of the recorded connection", sent)
+	}
+	if recorded := eventType(c.script[c.pos].Data); recorded != sent {
+		return fmt.Errorf("replay diverged: sent %s, recording has %s", sent, recorded)
+	}
+	c.pos++
+	c.release()
+	return nil
+}
+
+func (c *replayConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *replayConn) Response() *http.Response {
+	return nil
+}
+
+func (c *replayConn) Ping(ctx context.Context) error {
+	return nil
+}
+
+// eventType returns the "type" field of a Realtime event
+func eventType(data []byte) string {
+	var event struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(data, &event)
+	return event.Type
+}
+
+func errorMessage(data []byte) string {
+	var e errorData
+	json.Unmarshal(data, &e)
+	return e.Error
+}
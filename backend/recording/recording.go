@@ -0,0 +1,267 @@
+// Package recording captures WebSocket chat sessions (client messages, the messages
+// sent back, and the raw Realtime API traffic) to JSON lines files, and replays the
+// Realtime side of a recording so a session seen in production can be re-run as a
+// deterministic test (see Recording.Dialer).
+package recording
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Sources of recorded events
+const (
+	SourceSession           = "session"             // Session metadata, the first line of every recording
+	SourceClient            = "client"              // Message from the client
+	SourceServer            = "server"              // Message sent to the client
+	SourceModeration        = "moderation"          // Moderation verdict on the preceding client message
+	SourceUpstreamDial      = "upstream_dial"       // New Realtime connection
+	SourceUpstreamDialError = "upstream_dial_error" // Realtime connection failed
+	SourceUpstreamSend      = "upstream_send"       // Client event sent to the Realtime API
+	SourceUpstreamRecv      = "upstream_recv"       // Server event received from the Realtime API
+	SourceUpstreamError     = "upstream_error"      // Reading from the Realtime API failed (connection dropped)
+)
+
+// Event is one line of a recording
+type Event struct {
+	AtMS   int64           `json:"at_ms"` // Milliseconds since the session started
+	Source string          `json:"source"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// SessionInfo describes a recorded session
+type SessionInfo struct {
+	StartedAt     time.Time `json:"started_at"`
+	PromptVersion string    `json:"prompt_version"`
+	Backends      []string  `json:"backends"`
+	Query         string    `json:"query,omitempty"` // Session options from the WebSocket URL, without the token
+}
+
+// errorData is the payload of error events
+type errorData struct {
+	Error string `json:"error"`
+}
+
+// Recorder appends a session's events to a file. It is safe for concurrent use, and
+// a nil *Recorder is valid and records nothing.
+type Recorder struct {
+	path  string
+	start time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	failed bool
+}
+
+// Create starts a recording in dir, named after the session start time
+func Create(dir string, info SessionInfo) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := info.StartedAt.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix) + ".jsonl"
+	path := filepath.Join(dir, name)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	r := &Recorder{path: path, start: info.StartedAt, file: file}
+	r.Record(SourceSession, info)
+	return r, nil
+}
+
+// Path returns the file being written
+func (r *Recorder) Path() string {
+	if r == nil {
+		return ""
+	}
+	return r.path
+}
+
+// Record appends v, marshalled as JSON, as an event from source
+func (r *Recorder) Record(source string, v any) {
+	if r == nil {
+		return
+	}
+	var data json.RawMessage
+	if v != nil {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			log.Printf("Warning: failed to record %s event: %v", source, err)
+			return
+		}
+	}
+	r.write(Event{Source: source, Data: data})
+}
+
+// RecordRaw appends a message received or sent as-is. Messages that aren't JSON are
+// stored as JSON strings.
+func (r *Recorder) RecordRaw(source string, data []byte) {
+	if r == nil {
+		return
+	}
+	if !json.Valid(data) {
+		data, _ = json.Marshal(string(data))
+	}
+	r.write(Event{Source: source, Data: data})
+}
+
+// RecordError appends an error event
+func (r *Recorder) RecordError(source string, err error) {
+	r.Record(source, errorData{Error: err.Error()})
+}
+
+func (r *Recorder) write(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return
+	}
+	event.AtMS = time.Since(r.start).Milliseconds()
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Warning: failed to record %s event: %v", event.Source, err)
+		return
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		// Stop after the first failure (e.g. a full disk) rather than logging every event
+		log.Printf("Warning: recording %s stopped: %v", r.path, err)
+		r.failed = true
+	}
+}
+
+// Close finishes the recording
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = true
+	return r.file.Close()
+}
+
+// Recording is a recorded session loaded for replay
+type Recording struct {
+	Session SessionInfo
+	Events  []Event // Every event after the session line, in order
+}
+
+// maxLineBytes bounds one recorded event (audio deltas are the largest)
+const maxLineBytes = 16 * 1024 * 1024
+
+// Load reads a recording written by a Recorder
+func Load(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rec := &Recording{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	for line := 1; scanner.Scan(); line++ {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if line == 1 {
+			if event.Source != SourceSession {
+				return nil, fmt.Errorf("%s: first event is %q, expected %q", path, event.Source, SourceSession)
+			}
+			if err := json.Unmarshal(event.Data, &rec.Session); err != nil {
+				return nil, fmt.Errorf("%s:1: %w", path, err)
+			}
+			continue
+		}
+		rec.Events = append(rec.Events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(rec.Events) == 0 && rec.Session.StartedAt.IsZero() {
+		return nil, fmt.Errorf("%s: empty recording", path)
+	}
+	return rec, nil
+}
+
+// Save writes the recording to path in the format Load reads
+func (rec *Recording) Save(path string) error {
+	session, err := json.Marshal(rec.Session)
+	if err != nil {
+		return err
+	}
+	var lines []byte
+	for _, event := range append([]Event{{Source: SourceSession, Data: session}}, rec.Events...) {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	return os.WriteFile(path, lines, 0o644)
+}
+
+// Turn is a client message and the server messages sent before the next one.
+// The first turn has no client message and holds the messages sent on connect.
+type Turn struct {
+	Client json.RawMessage
+	Server []json.RawMessage
+}
+
+// Turns splits the client side of the recording into turns
+func (rec *Recording) Turns() []Turn {
+	turns := []Turn{{}}
+	for _, event := range rec.Events {
+		switch event.Source {
+		case SourceClient:
+			turns = append(turns, Turn{Client: event.Data})
+		case SourceServer:
+			last := &turns[len(turns)-1]
+			last.Server = append(last.Server, event.Data)
+		}
+	}
+	return turns
+}
+
+// WithServer returns a copy of the recording whose server messages are replaced by
+// server (one slice per turn, as returned by Turns), e.g. to update a golden recording
+func (rec *Recording) WithServer(server [][]json.RawMessage) (*Recording, error) {
+	turns := rec.Turns()
+	if len(server) != len(turns) {
+		return nil, fmt.Errorf("got server messages for %d turns, recording has %d", len(server), len(turns))
+	}
+
+	out := &Recording{Session: rec.Session}
+	addServer := func(turn int, atMS int64) {
+		for _, data := range server[turn] {
+			out.Events = append(out.Events, Event{AtMS: atMS, Source: SourceServer, Data: data})
+		}
+	}
+	addServer(0, 0)
+	turn := 0
+	for _, event := range rec.Events {
+		switch event.Source {
+		case SourceServer:
+			continue
+		case SourceClient:
+			out.Events = append(out.Events, event)
+			turn++
+			addServer(turn, event.AtMS)
+		default:
+			out.Events = append(out.Events, event)
+		}
+	}
+	return out, nil
+}
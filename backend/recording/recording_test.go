@@ -0,0 +1,175 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+)
+
+func TestRecordAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := Create(dir, SessionInfo{StartedAt: time.Now(), PromptVersion: "abc123", Backends: []string{"realtime"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Record(SourceServer, map[string]string{"type": "session_created"})
+	rec.Record(SourceClient, map[string]string{"type": "message", "message": "Hi"})
+	rec.RecordRaw(SourceUpstreamRecv, []byte(`{"type":"response.created"}`))
+	rec.RecordRaw(SourceUpstreamRecv, []byte("not json"))
+	rec.Record(SourceServer, map[string]string{"type": "response_done"})
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rec.Record(SourceServer, map[string]string{"type": "dropped"}) // After Close, ignored
+
+	// A nil recorder is a no-op
+	var none *Recorder
+	none.Record(SourceClient, "ignored")
+	if err := none.Close(); err != nil || none.Dialer(openairt.DefaultDialer()) == nil {
+		t.Error("Expected a nil recorder to do nothing")
+	}
+
+	loaded, err := Load(rec.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Session.PromptVersion != "abc123" || len(loaded.Events) != 5 {
+		t.Fatalf("Unexpected recording %+v", loaded)
+	}
+	if got := string(loaded.Events[3].Data); got != `"not json"` {
+		t.Errorf("Expected non-JSON messages to be stored as strings, got %s", got)
+	}
+
+	turns := loaded.Turns()
+	if len(turns) != 2 || turns[0].Client != nil || len(turns[0].Server) != 1 || len(turns[1].Server) != 1 {
+		t.Fatalf("Unexpected turns %+v", turns)
+	}
+	if !strings.Contains(string(turns[1].Client), "Hi") {
+		t.Errorf("Expected the client message in turn 1, got %s", turns[1].Client)
+	}
+}
+
+// event builds a recorded event with JSON data
+func event(source, data string) Event {
+	e := Event{Source: source}
+	if data != "" {
+		e.Data = json.RawMessage(data)
+	}
+	return e
+}
+
+func TestReplayDialer(t *testing.T) {
+	ctx := context.Background()
+	rec := &Recording{Events: []Event{
+		event(SourceUpstreamDial, ""),
+		event(SourceUpstreamRecv, `{"type":"session.created"}`),
+		event(SourceUpstreamSend, `{"type":"session.update"}`),
+		event(SourceUpstreamRecv, `{"type":"session.updated"}`),
+		event(SourceUpstreamSend, `{"type":"response.create"}`),
+		event(SourceUpstreamError, `{"error":"connection reset"}`),
+		event(SourceUpstreamDialError, `{"error":"dial refused"}`),
+	}}
+	dialer := rec.Dialer()
+
+	conn, err := dialer.Dial(ctx, "ws://replay", http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func() string {
+		t.Helper()
+		readCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		_, data, err := conn.ReadMessage(readCtx)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return eventType(data)
+	}
+
+	// Events sent on connect are available immediately; later ones wait for the matching send
+	if got := read(); got != "session.created" {
+		t.Errorf("Expected session.created, got %s", got)
+	}
+	if err := conn.WriteMessage(ctx, openairt.MessageText, []byte(`{"type":"session.update"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "session.updated" {
+		t.Errorf("Expected session.updated, got %s", got)
+	}
+
+	// A different event than was recorded is reported as divergence
+	err = conn.WriteMessage(ctx, openairt.MessageText, []byte(`{"type":"conversation.item.create"}`))
+	if err == nil || !strings.Contains(err.Error(), "recording has response.create") {
+		t.Errorf("Expected a divergence error, got %v", err)
+	}
+	if err := conn.WriteMessage(ctx, openairt.MessageText, []byte(`{"type":"response.create"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "error: replay: connection reset" {
+		t.Errorf("Expected the recorded read error, got %s", got)
+	}
+
+	// Reads block until the connection is closed
+	conn.Close()
+	if got := read(); got != "error: replay: connection closed" {
+		t.Errorf("Expected a closed error, got %s", got)
+	}
+
+	// The next connection attempt fails as recorded, then there are none left
+	if _, err := dialer.Dial(ctx, "ws://replay", nil); err == nil || !strings.Contains(err.Error(), "dial refused") {
+		t.Errorf("Expected the recorded dial error, got %v", err)
+	}
+	if _, err := dialer.Dial(ctx, "ws://replay", nil); err == nil {
+		t.Error("Expected an error once the recorded connections are used up")
+	}
+}
+
+// fakeDialer returns a connection that answers every read with the same message
+type fakeDialer struct{ err error }
+
+func (d fakeDialer) Dial(ctx context.Context, url string, header http.Header) (openairt.WebSocketConn, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return newReplayConn([]Event{event(SourceUpstreamRecv, `{"type":"session.created"}`)}), nil
+}
+
+func TestRecordingDialer(t *testing.T) {
+	ctx := context.Background()
+	rec, err := Create(t.TempDir(), SessionInfo{StartedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rec.Dialer(fakeDialer{err: errors.New("refused")}).Dial(ctx, "ws://upstream", nil); err == nil {
+		t.Fatal("Expected the dial error to be returned")
+	}
+	conn, err := rec.Dialer(fakeDialer{}).Dial(ctx, "ws://upstream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.ReadMessage(ctx)
+	conn.WriteMessage(ctx, openairt.MessageText, []byte(`{"type":"session.update"}`))
+	conn.Close()
+	conn.ReadMessage(ctx) // Fails because we closed it, which isn't recorded
+	rec.Close()
+
+	loaded, err := Load(rec.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sources []string
+	for _, e := range loaded.Events {
+		sources = append(sources, e.Source)
+	}
+	want := []string{SourceUpstreamDialError, SourceUpstreamDial, SourceUpstreamRecv, SourceUpstreamSend}
+	if strings.Join(sources, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected events %v, got %v", want, sources)
+	}
+}
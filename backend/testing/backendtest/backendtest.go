@@ -0,0 +1,124 @@
+// Package backendtest serves the full backend router against fake upstreams, for
+// tests of the backend and of the tools that talk to it.
+package backendtest
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/handlers"
+	"christianmoore.me/avatar-backend/origins"
+	"christianmoore.me/avatar-backend/persona"
+	"christianmoore.me/avatar-backend/testing/fakes"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	JWTSecret       = "backendtest-secret"
+	LLMModel        = "qwen2.5-7b-instruct"
+	TTSModel        = "neutss-air-4b"
+	EmbeddingsModel = "nomic-embed-text"
+	Prompt          = "You are Christian."
+)
+
+// Backend is a running backend and the fakes behind it
+type Backend struct {
+	Config *config.Config // Answers through the local pipeline by default
+	Server *httptest.Server
+	Chat   *handlers.ChatHandler
+
+	LLM        *fakes.LLM
+	TTS        *fakes.TTS
+	Realtime   *fakes.Realtime
+	Embeddings *fakes.Embeddings
+}
+
+// New starts a backend. configure can adjust Config and the fakes before the
+// handlers are built and any request is served.
+func New(t testing.TB, configure ...func(*Backend)) *Backend {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	b := &Backend{
+		LLM:        fakes.NewLLM(LLMModel),
+		TTS:        fakes.NewTTS(),
+		Realtime:   fakes.NewRealtime(),
+		Embeddings: fakes.NewEmbeddings(),
+	}
+	llmServer := fakes.Serve(t, b.LLM)
+	ttsServer := fakes.Serve(t, b.TTS)
+	realtimeServer := fakes.Serve(t, b.Realtime)
+	embeddingsServer := fakes.Serve(t, b.Embeddings)
+
+	upstream := config.UpstreamConfig{
+		ConnectTimeout:        time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		BreakerThreshold:      3,
+		BreakerCooldown:       30 * time.Second,
+	}
+	ttsUpstream := upstream
+	ttsUpstream.RequestTimeout = 10 * time.Second
+	b.Config = &config.Config{
+		OpenAIAPIKey:      "sk-test",
+		OpenAIModel:       "gpt-realtime-mini",
+		OpenAIRealtimeURL: fakes.RealtimeURL(realtimeServer.URL),
+		JWTSecret:         JWTSecret,
+		CORSOrigins:       []string{"http://localhost:5173"},
+
+		MaxMessageLength:    4000,
+		MessageRateInterval: 5 * time.Second,
+		MessageBurst:        3,
+		MaxConnectionsPerIP: 10,
+		ConnectionTimeout:   time.Minute,
+		HeartbeatInterval:   30 * time.Second,
+		JWTExpiration:       30 * time.Minute,
+
+		Backends:                []string{handlers.BackendLocal},
+		BreakerFailureThreshold: 3,
+		BreakerCooldown:         30 * time.Second,
+		RealtimeResponseTimeout: 2 * time.Second,
+		RealtimeOutputModality:  "audio",
+		SessionModalities:       []string{"audio", "text"},
+
+		LocalLLMURL:       llmServer.URL,
+		LocalLLMModel:     LLMModel,
+		TTSURL:            ttsServer.URL,
+		TTSModel:          TTSModel,
+		TTSResponseFormat: "wav",
+		ValidateModels:    true,
+		LLMUpstream:       upstream,
+		TTSUpstream:       ttsUpstream,
+
+		// The semantic cache is off unless a test enables it
+		SemanticCacheMode:       "off",
+		SemanticCacheThreshold:  0.9,
+		SemanticCacheMaxEntries: 100,
+		EmbeddingsURL:           embeddingsServer.URL,
+		EmbeddingsModel:         EmbeddingsModel,
+		EmbeddingsUpstream:      upstream,
+	}
+	for _, fn := range configure {
+		fn(b)
+	}
+
+	// Wire the handlers the way main does
+	authHandler := handlers.NewAuthHandler(b.Config.JWTSecret, "", "")
+	authHandler.SetJWTExpiration(b.Config.JWTExpiration)
+	budget := handlers.NewBudgetTracker(handlers.BudgetConfig{})
+	personas := persona.NewStore(persona.New(Prompt, "backendtest", "cedar", "onyx", 1))
+	originPolicy, err := origins.NewPolicy(b.Config.CORSOrigins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Chat, err = handlers.NewChatHandler(b.Config, authHandler, budget, personas, originPolicy)
+	if err != nil {
+		t.Fatalf("NewChatHandler failed: %v", err)
+	}
+	adminHandler := handlers.NewAdminHandler("", personas, nil)
+
+	b.Server = httptest.NewServer(handlers.NewRouter(authHandler, b.Chat, adminHandler, originPolicy))
+	t.Cleanup(b.Server.Close)
+	return b
+}
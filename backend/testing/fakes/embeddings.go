@@ -0,0 +1,85 @@
+package fakes
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// EmbeddingDimensions is the length of the fake embeddings
+const EmbeddingDimensions = 64
+
+// Embeddings is a fake OpenAI-compatible embeddings server. Each word of the input
+// adds to one hashed dimension, so texts sharing most of their words are similar.
+// It is safe for concurrent use.
+type Embeddings struct {
+	Models []string // Served on /v1/models; none means the endpoint 404s
+	Status int      // Respond to every request with this HTTP status instead
+
+	mu     sync.Mutex
+	inputs []string
+}
+
+// NewEmbeddings creates a fake embeddings server
+func NewEmbeddings() *Embeddings {
+	return &Embeddings{}
+}
+
+// Inputs returns the texts embedded so far
+func (e *Embeddings) Inputs() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.inputs...)
+}
+
+func (e *Embeddings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/models":
+		serveModels(w, r, e.Models)
+	case "/v1/embeddings":
+		e.serveEmbeddings(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *Embeddings) serveEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Input string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.mu.Lock()
+	e.inputs = append(e.inputs, req.Input)
+	status := e.Status
+	e.mu.Unlock()
+	if status != 0 {
+		http.Error(w, `{"error":{"message":"scripted failure"}}`, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"object": "list",
+		"data":   []map[string]any{{"object": "embedding", "index": 0, "embedding": Embed(req.Input)}},
+	})
+}
+
+// Embed returns the fake embedding of text
+func Embed(text string) []float32 {
+	vector := make([]float32, EmbeddingDimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vector[h.Sum32()%EmbeddingDimensions]++
+	}
+	return vector
+}
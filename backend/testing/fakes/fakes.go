@@ -0,0 +1,34 @@
+// Package fakes provides scriptable stand-ins for the OpenAI-compatible LLM, TTS and embeddings
+// servers and the OpenAI Realtime API, for tests and for local development without
+// GPUs or an API key (see cmd/fakeupstreams).
+package fakes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Serve starts h on a local test server that's closed when the test ends
+func Serve(t testing.TB, h http.Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Words splits text into one chunk per word (keeping the spaces), so streamed deltas
+// join back into the original text
+func Words(text string) []string {
+	var chunks []string
+	for _, chunk := range strings.SplitAfter(text, " ") {
+		if chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+// DefaultReply is what the fakes answer when nothing has been scripted
+const DefaultReply = "Hi, I'm a fake model. Christian builds reliable cloud infrastructure."
@@ -0,0 +1,226 @@
+package fakes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+)
+
+func TestWords(t *testing.T) {
+	chunks := Words("Hello there,  world.")
+	if strings.Join(chunks, "") != "Hello there,  world." || len(chunks) != 4 {
+		t.Errorf("Unexpected chunks: %q", chunks)
+	}
+}
+
+// streamCompletion posts a streaming chat request and returns the SSE data lines
+func streamCompletion(t *testing.T, url, model string) ([]string, error) {
+	t.Helper()
+	body := `{"model":"` + model + `","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"Hi there"}]}`
+	resp, err := http.Post(url+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{resp.StatusCode}
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			lines = append(lines, data)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+type statusError struct{ status int }
+
+func (e *statusError) Error() string { return http.StatusText(e.status) }
+
+func TestLLMStreamsScriptedReplies(t *testing.T) {
+	llm := NewLLM("qwen")
+	llm.Enqueue(
+		LLMReply{Chunks: []string{"One ", "two."}},
+		LLMReply{Status: http.StatusServiceUnavailable},
+		LLMReply{Chunks: []string{"a", "b", "c"}, DropAfter: 1},
+	)
+	server := Serve(t, llm)
+
+	lines, err := streamCompletion(t, server.URL, "qwen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines[len(lines)-1] != "[DONE]" || !strings.Contains(lines[len(lines)-2], `"usage"`) {
+		t.Errorf("Expected usage chunk then [DONE], got %v", lines)
+	}
+	var chunk struct {
+		Choices []struct {
+			Delta struct{ Content string } `json:"delta"`
+		} `json:"choices"`
+	}
+	json.Unmarshal([]byte(lines[0]), &chunk)
+	if chunk.Choices[0].Delta.Content != "One " {
+		t.Errorf("Unexpected first chunk: %s", lines[0])
+	}
+
+	if _, err := streamCompletion(t, server.URL, "qwen"); err == nil {
+		t.Error("Expected scripted 503")
+	}
+
+	// A dropped stream ends without [DONE]
+	lines, _ = streamCompletion(t, server.URL, "qwen")
+	if len(lines) != 1 {
+		t.Errorf("Expected the stream to stop after one chunk, got %v", lines)
+	}
+
+	// Unknown models are rejected, and requests are recorded
+	if _, err := streamCompletion(t, server.URL, "other"); err == nil {
+		t.Error("Expected unknown model to be rejected")
+	}
+	if got := len(llm.Requests()); got != 3 {
+		t.Errorf("Expected 3 recorded requests, got %d", got)
+	}
+}
+
+func TestTTSGeneratesWAV(t *testing.T) {
+	tts := NewTTS()
+	tts.SampleRate = 16000
+	tts.PerChar = 10 * time.Millisecond
+	tts.Enqueue(TTSReply{Status: http.StatusBadGateway})
+	server := Serve(t, tts)
+
+	speak := func(format string) *http.Response {
+		body := `{"model":"tts","input":"Hello world","voice":"onyx","response_format":"` + format + `"}`
+		resp, err := http.Post(server.URL+"/v1/audio/speech", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if resp := speak("wav"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected scripted 502, got %d", resp.StatusCode)
+	}
+
+	data, _ := io.ReadAll(speak("wav").Body)
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		t.Fatalf("Expected a WAV file, got % x", data[:12])
+	}
+	if rate := binary.LittleEndian.Uint32(data[24:28]); rate != 16000 {
+		t.Errorf("Expected 16kHz, got %d", rate)
+	}
+	// 11 characters at 10ms each of 16-bit 16kHz audio
+	if size := binary.LittleEndian.Uint32(data[40:44]); size != 110*16*2 {
+		t.Errorf("Unexpected data size %d", size)
+	}
+
+	pcm, _ := io.ReadAll(speak("pcm").Body)
+	if bytes.HasPrefix(pcm, []byte("RIFF")) || len(pcm) != 110*24*2 {
+		t.Errorf("Expected raw 24kHz PCM16, got %d bytes", len(pcm))
+	}
+	if got := tts.Requests(); len(got) != 3 || got[0].Voice != "onyx" {
+		t.Errorf("Unexpected recorded requests: %+v", got)
+	}
+}
+
+// readUntil reads Realtime events until one of the given type arrives
+func readUntil(t *testing.T, conn *openairt.Conn, eventType openairt.ServerEventType) []openairt.ServerEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var events []openairt.ServerEvent
+	for {
+		event, err := conn.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+		if event.ServerEventType() == eventType {
+			return events
+		}
+	}
+}
+
+func TestRealtimeSpeaksProtocol(t *testing.T) {
+	rt := NewRealtime()
+	rt.APIKey = "sk-test"
+	rt.Enqueue(RealtimeReply{Chunks: []string{"Hi ", "there."}})
+	server := Serve(t, rt)
+
+	cfg := openairt.DefaultConfig("sk-test")
+	cfg.BaseURL = RealtimeURL(server.URL)
+	conn, err := openairt.NewClientWithConfig(cfg).Connect(context.Background(), openairt.WithModel("gpt-realtime-mini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	readUntil(t, conn, openairt.ServerEventTypeSessionCreated)
+
+	ctx := context.Background()
+	conn.SendMessage(ctx, openairt.SessionUpdateEvent{Session: openairt.SessionUnion{Realtime: &openairt.RealtimeSession{
+		Instructions:     "You are Christian.",
+		OutputModalities: []openairt.Modality{openairt.ModalityText},
+	}}})
+	readUntil(t, conn, openairt.ServerEventTypeSessionUpdated)
+
+	conn.SendMessage(ctx, openairt.ConversationItemCreateEvent{Item: openairt.MessageItemUnion{User: &openairt.MessageItemUser{
+		Content: []openairt.MessageContentInput{{Type: openairt.MessageContentTypeInputText, Text: "Hello"}},
+	}}})
+	conn.SendMessage(ctx, openairt.ResponseCreateEvent{})
+
+	var text string
+	for _, event := range readUntil(t, conn, openairt.ServerEventTypeResponseDone) {
+		if delta, ok := event.(openairt.ResponseOutputTextDeltaEvent); ok {
+			text += delta.Delta
+		}
+		if done, ok := event.(openairt.ResponseDoneEvent); ok && done.Response.Usage == nil {
+			t.Error("Expected usage in response.done")
+		}
+	}
+	if text != "Hi there." {
+		t.Errorf("Expected scripted text reply, got %q", text)
+	}
+
+	// Audio is the default and can be requested per response
+	conn.SendMessage(ctx, openairt.ResponseCreateEvent{Response: openairt.ResponseCreateParams{
+		OutputModalities: []openairt.Modality{openairt.ModalityAudio},
+	}})
+	audio := false
+	for _, event := range readUntil(t, conn, openairt.ServerEventTypeResponseDone) {
+		if _, ok := event.(openairt.ResponseOutputAudioDeltaEvent); ok {
+			audio = true
+		}
+	}
+	if !audio {
+		t.Error("Expected audio deltas")
+	}
+
+	if rt.Instructions() != "You are Christian." || len(rt.Messages()) != 1 || rt.Messages()[0] != "Hello" {
+		t.Errorf("Unexpected recorded session: %q %v", rt.Instructions(), rt.Messages())
+	}
+}
+
+func TestRealtimeRejectsWrongKey(t *testing.T) {
+	rt := NewRealtime()
+	rt.APIKey = "sk-test"
+	server := Serve(t, rt)
+
+	cfg := openairt.DefaultConfig("sk-wrong")
+	cfg.BaseURL = RealtimeURL(server.URL)
+	if _, err := openairt.NewClientWithConfig(cfg).Connect(context.Background()); err == nil {
+		t.Error("Expected connection with the wrong key to fail")
+	}
+}
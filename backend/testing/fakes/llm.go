@@ -0,0 +1,189 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChatRequest is a chat completion request received by the fake LLM
+type ChatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	Stream        bool     `json:"stream"`
+	Temperature   *float64 `json:"temperature"`
+	TopP          *float64 `json:"top_p"`
+	MaxTokens     int      `json:"max_tokens"`
+	Stop          []string `json:"stop"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+// LLMReply scripts one chat completion response
+type LLMReply struct {
+	Chunks    []string      // Content deltas, each sent as one SSE chunk
+	Delay     time.Duration // Pause before each chunk
+	Status    int           // Respond with this HTTP status and an error body instead
+	DropAfter int           // Cut the stream after this many chunks, without [DONE] (0 = never)
+}
+
+// LLM is a fake OpenAI-compatible chat completion server. Scripted replies are used
+// in order, then Default. It is safe for concurrent use.
+type LLM struct {
+	Models  []string
+	Default LLMReply
+
+	mu       sync.Mutex
+	queue    []LLMReply
+	requests []ChatRequest
+}
+
+// NewLLM creates a fake LLM serving the given models (all models when none are given)
+func NewLLM(models ...string) *LLM {
+	return &LLM{
+		Models:  models,
+		Default: LLMReply{Chunks: Words(DefaultReply)},
+	}
+}
+
+// Enqueue scripts the next responses
+func (l *LLM) Enqueue(replies ...LLMReply) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queue = append(l.queue, replies...)
+}
+
+// Requests returns the chat completion requests received so far
+func (l *LLM) Requests() []ChatRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ChatRequest(nil), l.requests...)
+}
+
+func (l *LLM) next(req ChatRequest) LLMReply {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = append(l.requests, req)
+	if len(l.queue) == 0 {
+		return l.Default
+	}
+	reply := l.queue[0]
+	l.queue = l.queue[1:]
+	return reply
+}
+
+func (l *LLM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/models":
+		serveModels(w, r, l.Models)
+	case "/v1/chat/completions":
+		l.serveCompletion(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (l *LLM) serveCompletion(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(l.Models) > 0 && !slices.Contains(l.Models, req.Model) {
+		http.Error(w, fmt.Sprintf(`{"error":{"message":"model %q not found"}}`, req.Model), http.StatusNotFound)
+		return
+	}
+	reply := l.next(req)
+	if reply.Status != 0 {
+		http.Error(w, `{"error":{"message":"scripted failure"}}`, reply.Status)
+		return
+	}
+
+	// Non-streaming requests get the whole reply at once
+	if !req.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"model": req.Model,
+			"choices": []map[string]any{{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": strings.Join(reply.Chunks, "")},
+				"finish_reason": "stop",
+			}},
+			"usage": usage(req, reply),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	send := func(v any) {
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	for i, chunk := range reply.Chunks {
+		if reply.DropAfter > 0 && i == reply.DropAfter {
+			// Abort the response mid-stream like a crashed server
+			panic(http.ErrAbortHandler)
+		}
+		if reply.Delay > 0 {
+			select {
+			case <-time.After(reply.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		send(map[string]any{
+			"object":  "chat.completion.chunk",
+			"model":   req.Model,
+			"choices": []map[string]any{{"index": 0, "delta": map[string]string{"content": chunk}}},
+		})
+	}
+	send(map[string]any{
+		"object":  "chat.completion.chunk",
+		"model":   req.Model,
+		"choices": []map[string]any{{"index": 0, "delta": map[string]string{}, "finish_reason": "stop"}},
+	})
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		send(map[string]any{"object": "chat.completion.chunk", "choices": []any{}, "usage": usage(req, reply)})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// usage approximates token counts as words in and chunks out
+func usage(req ChatRequest, reply LLMReply) map[string]int {
+	prompt := 0
+	for _, m := range req.Messages {
+		prompt += len(strings.Fields(m.Content))
+	}
+	return map[string]int{
+		"prompt_tokens":     prompt,
+		"completion_tokens": len(reply.Chunks),
+		"total_tokens":      prompt + len(reply.Chunks),
+	}
+}
+
+// serveModels answers /v1/models; no models means the endpoint isn't supported
+func serveModels(w http.ResponseWriter, r *http.Request, models []string) {
+	if len(models) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	data := make([]map[string]string, 0, len(models))
+	for _, id := range models {
+		data = append(data, map[string]string{"id": id, "object": "model"})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+}
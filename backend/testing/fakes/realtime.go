@@ -0,0 +1,275 @@
+package fakes
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	openairt "github.com/WqyJh/go-openai-realtime/v2"
+	"github.com/gorilla/websocket"
+)
+
+// RealtimeReply scripts one Realtime response
+type RealtimeReply struct {
+	Chunks []string      // Text (or transcript) deltas; audio mode adds a tone per chunk
+	Delay  time.Duration // Pause before each chunk
+	Error  string        // Send an error event before answering
+	Silent bool          // Accept response.create but never answer, to exercise timeouts
+	Close  bool          // Drop the connection instead of answering
+}
+
+// Realtime is a fake OpenAI Realtime API WebSocket server speaking enough of the
+// protocol for the chat handler: session.update, conversation.item.create and
+// response.create. It is safe for concurrent use.
+type Realtime struct {
+	APIKey  string // When set, connections must present it as a bearer token
+	Default RealtimeReply
+
+	upgrader websocket.Upgrader
+
+	mu           sync.Mutex
+	queue        []RealtimeReply
+	connections  int
+	messages     []string
+	assistant    []string
+	instructions string
+}
+
+// NewRealtime creates a fake Realtime server
+func NewRealtime() *Realtime {
+	return &Realtime{Default: RealtimeReply{Chunks: Words(DefaultReply)}}
+}
+
+// RealtimeURL returns the WebSocket base URL to configure as the Realtime endpoint
+func RealtimeURL(serverURL string) string {
+	return "ws" + strings.TrimPrefix(serverURL, "http") + "/v1/realtime"
+}
+
+// Enqueue scripts the next responses
+func (rt *Realtime) Enqueue(replies ...RealtimeReply) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.queue = append(rt.queue, replies...)
+}
+
+// Connections returns how many WebSocket connections have been accepted
+func (rt *Realtime) Connections() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.connections
+}
+
+// Messages returns the user messages received so far
+func (rt *Realtime) Messages() []string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]string(nil), rt.messages...)
+}
+
+// AssistantMessages returns the assistant messages the client added to conversations
+func (rt *Realtime) AssistantMessages() []string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]string(nil), rt.assistant...)
+}
+
+// Instructions returns the most recent session instructions (the system prompt)
+func (rt *Realtime) Instructions() string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.instructions
+}
+
+func (rt *Realtime) next() RealtimeReply {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if len(rt.queue) == 0 {
+		return rt.Default
+	}
+	reply := rt.queue[0]
+	rt.queue = rt.queue[1:]
+	return reply
+}
+
+// realtimeClientEvent holds the client event fields the fake understands
+type realtimeClientEvent struct {
+	Type    string `json:"type"`
+	Session struct {
+		Instructions     string              `json:"instructions"`
+		OutputModalities []openairt.Modality `json:"output_modalities"`
+	} `json:"session"`
+	Item struct {
+		Role    string `json:"role"`
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"item"`
+	Response struct {
+		OutputModalities []openairt.Modality `json:"output_modalities"`
+	} `json:"response"`
+}
+
+func (rt *Realtime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rt.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+rt.APIKey {
+		http.Error(w, `{"error":{"message":"invalid API key"}}`, http.StatusUnauthorized)
+		return
+	}
+	conn, err := rt.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	rt.mu.Lock()
+	rt.connections++
+	id := rt.connections
+	rt.mu.Unlock()
+
+	session := &openairt.RealtimeSession{
+		ID:               fmt.Sprintf("sess_fake%d", id),
+		Model:            r.URL.Query().Get("model"),
+		OutputModalities: []openairt.Modality{openairt.ModalityAudio},
+	}
+	send := func(event any) error { return conn.WriteJSON(event) }
+	send(openairt.SessionCreatedEvent{
+		ServerEventBase: openairt.ServerEventBase{Type: openairt.ServerEventTypeSessionCreated},
+		Session:         openairt.SessionUnion{Realtime: session},
+	})
+
+	responses := 0
+	for {
+		var event realtimeClientEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			return
+		}
+		switch event.Type {
+		case "session.update":
+			session.Instructions = event.Session.Instructions
+			if len(event.Session.OutputModalities) > 0 {
+				session.OutputModalities = event.Session.OutputModalities
+			}
+			rt.mu.Lock()
+			rt.instructions = event.Session.Instructions
+			rt.mu.Unlock()
+			send(openairt.SessionUpdatedEvent{
+				ServerEventBase: openairt.ServerEventBase{Type: openairt.ServerEventTypeSessionUpdated},
+				Session:         openairt.SessionUnion{Realtime: session},
+			})
+
+		case "conversation.item.create":
+			rt.mu.Lock()
+			for _, content := range event.Item.Content {
+				if event.Item.Role == "assistant" {
+					rt.assistant = append(rt.assistant, content.Text)
+				} else {
+					rt.messages = append(rt.messages, content.Text)
+				}
+			}
+			rt.mu.Unlock()
+
+		case "response.create":
+			responses++
+			modalities := session.OutputModalities
+			if len(event.Response.OutputModalities) > 0 {
+				modalities = event.Response.OutputModalities
+			}
+			reply := rt.next()
+			if reply.Close {
+				return
+			}
+			if reply.Silent {
+				continue
+			}
+			responseID := fmt.Sprintf("resp_fake%d_%d", id, responses)
+			if err := rt.respond(send, responseID, modalities[0], reply); err != nil {
+				return
+			}
+
+		default:
+			log.Printf("Fake Realtime: ignoring %s event", event.Type)
+		}
+	}
+}
+
+// respond streams a scripted response in the given modality
+func (rt *Realtime) respond(send func(any) error, responseID string, modality openairt.Modality, reply RealtimeReply) error {
+	base := func(t openairt.ServerEventType) openairt.ServerEventBase {
+		return openairt.ServerEventBase{Type: t}
+	}
+	if reply.Error != "" {
+		send(map[string]any{"type": "error", "error": map[string]string{"type": "server_error", "message": reply.Error}})
+	}
+	if err := send(openairt.ResponseCreatedEvent{
+		ServerEventBase: base(openairt.ServerEventTypeResponseCreated),
+		Response:        openairt.Response{ID: responseID, Status: "in_progress"},
+	}); err != nil {
+		return err
+	}
+
+	for _, chunk := range reply.Chunks {
+		if reply.Delay > 0 {
+			time.Sleep(reply.Delay)
+		}
+		var err error
+		if modality == openairt.ModalityText {
+			err = send(openairt.ResponseOutputTextDeltaEvent{
+				ServerEventBase: base(openairt.ServerEventTypeResponseOutputTextDelta),
+				ResponseID:      responseID,
+				Delta:           chunk,
+			})
+		} else {
+			err = send(openairt.ResponseOutputAudioTranscriptDeltaEvent{
+				ServerEventBase: base(openairt.ServerEventTypeResponseOutputAudioTranscriptDelta),
+				ResponseID:      responseID,
+				Delta:           chunk,
+			})
+			if err == nil {
+				audio := Tone(24000, 16, time.Duration(len(chunk))*50*time.Millisecond)
+				err = send(openairt.ResponseOutputAudioDeltaEvent{
+					ServerEventBase: base(openairt.ServerEventTypeResponseOutputAudioDelta),
+					ResponseID:      responseID,
+					Delta:           base64.StdEncoding.EncodeToString(audio),
+				})
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	text := strings.Join(reply.Chunks, "")
+	if modality == openairt.ModalityText {
+		send(openairt.ResponseOutputTextDoneEvent{
+			ServerEventBase: base(openairt.ServerEventTypeResponseOutputTextDone),
+			ResponseID:      responseID,
+			Text:            text,
+		})
+	} else {
+		send(openairt.ResponseOutputAudioTranscriptDoneEvent{
+			ServerEventBase: base(openairt.ServerEventTypeResponseOutputAudioTranscriptDone),
+			ResponseID:      responseID,
+			Transcript:      text,
+		})
+		send(openairt.ResponseOutputAudioDoneEvent{
+			ServerEventBase: base(openairt.ServerEventTypeResponseOutputAudioDone),
+			ResponseID:      responseID,
+		})
+	}
+	return send(openairt.ResponseDoneEvent{
+		ServerEventBase: base(openairt.ServerEventTypeResponseDone),
+		Response: openairt.Response{
+			ID:               responseID,
+			Status:           "completed",
+			OutputModalities: []openairt.Modality{modality},
+			Usage: &openairt.TokenUsage{
+				InputTokens:  10,
+				OutputTokens: len(reply.Chunks),
+				TotalTokens:  10 + len(reply.Chunks),
+			},
+		},
+	})
+}
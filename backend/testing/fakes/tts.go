@@ -0,0 +1,161 @@
+package fakes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SpeechRequest is a speech synthesis request received by the fake TTS server
+type SpeechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format"`
+	Speed          float64 `json:"speed"`
+}
+
+// TTSReply scripts one speech response
+type TTSReply struct {
+	Delay  time.Duration // Pause before responding
+	Status int           // Respond with this HTTP status and an error body instead
+}
+
+// TTS is a fake OpenAI-compatible speech server that returns a tone whose length
+// follows the input text. It is safe for concurrent use.
+type TTS struct {
+	Models        []string      // Served on /v1/models; none means the endpoint 404s
+	SampleRate    int           // WAV sample rate (raw "pcm" is always 24kHz PCM16)
+	BitsPerSample int           // WAV bit depth: 8, 16, 24 or 32
+	PerChar       time.Duration // Audio length per input character
+	Default       TTSReply
+
+	mu       sync.Mutex
+	queue    []TTSReply
+	requests []SpeechRequest
+}
+
+// NewTTS creates a fake TTS server producing 24kHz 16-bit WAV
+func NewTTS() *TTS {
+	return &TTS{
+		SampleRate:    24000,
+		BitsPerSample: 16,
+		PerChar:       50 * time.Millisecond,
+	}
+}
+
+// Enqueue scripts the next responses
+func (t *TTS) Enqueue(replies ...TTSReply) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queue = append(t.queue, replies...)
+}
+
+// Requests returns the speech requests received so far
+func (t *TTS) Requests() []SpeechRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SpeechRequest(nil), t.requests...)
+}
+
+func (t *TTS) next(req SpeechRequest) TTSReply {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = append(t.requests, req)
+	if len(t.queue) == 0 {
+		return t.Default
+	}
+	reply := t.queue[0]
+	t.queue = t.queue[1:]
+	return reply
+}
+
+func (t *TTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/models":
+		serveModels(w, r, t.Models)
+	case "/v1/audio/speech":
+		t.serveSpeech(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (t *TTS) serveSpeech(w http.ResponseWriter, r *http.Request) {
+	var req SpeechRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply := t.next(req)
+	if reply.Delay > 0 {
+		select {
+		case <-time.After(reply.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if reply.Status != 0 {
+		http.Error(w, `{"error":{"message":"scripted failure"}}`, reply.Status)
+		return
+	}
+
+	duration := time.Duration(len([]rune(req.Input))) * t.PerChar
+	if req.ResponseFormat == "pcm" {
+		w.Header().Set("Content-Type", "audio/pcm")
+		w.Write(Tone(24000, 16, duration))
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Write(WAV(t.SampleRate, t.BitsPerSample, Tone(t.SampleRate, t.BitsPerSample, duration)))
+}
+
+// Tone generates a quiet 220Hz mono sine wave as little-endian PCM samples
+func Tone(sampleRate, bitsPerSample int, duration time.Duration) []byte {
+	samples := int(duration.Seconds() * float64(sampleRate))
+	width := bitsPerSample / 8
+	pcm := make([]byte, samples*width)
+	for i := 0; i < samples; i++ {
+		v := 0.25 * math.Sin(2*math.Pi*220*float64(i)/float64(sampleRate))
+		b := pcm[i*width : (i+1)*width]
+		switch bitsPerSample {
+		case 8:
+			b[0] = byte(128 + int(v*127)) // 8-bit WAV is unsigned
+		case 16:
+			binary.LittleEndian.PutUint16(b, uint16(int16(v*math.MaxInt16)))
+		case 24:
+			s := int32(v * (1<<23 - 1))
+			b[0], b[1], b[2] = byte(s), byte(s>>8), byte(s>>16)
+		case 32:
+			binary.LittleEndian.PutUint32(b, uint32(int32(v*math.MaxInt32)))
+		}
+	}
+	return pcm
+}
+
+// WAV wraps mono PCM samples in a RIFF/WAVE container
+func WAV(sampleRate, bitsPerSample int, pcm []byte) []byte {
+	var buf bytes.Buffer
+	blockAlign := bitsPerSample / 8
+	write := func(v any) { binary.Write(&buf, binary.LittleEndian, v) }
+
+	buf.WriteString("RIFF")
+	write(uint32(36 + len(pcm)))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	write(uint32(16))                      // fmt chunk size
+	write(uint16(1))                       // PCM
+	write(uint16(1))                       // Mono
+	write(uint32(sampleRate))              // Sample rate
+	write(uint32(sampleRate * blockAlign)) // Byte rate
+	write(uint16(blockAlign))              // Block align
+	write(uint16(bitsPerSample))           // Bits per sample
+	buf.WriteString("data")
+	write(uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
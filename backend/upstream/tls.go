@@ -0,0 +1,86 @@
+package upstream
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"christianmoore.me/avatar-backend/config"
+)
+
+// tlsConfig builds the client TLS configuration for an upstream, or nil to use Go's defaults
+func tlsConfig(cfg config.UpstreamConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && cfg.ServerName == "" && cfg.TLSMinVersion == "" {
+		return nil, nil
+	}
+
+	tc := &tls.Config{
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.TLSMinVersion == "1.3" {
+		tc.MinVersion = tls.VersionTLS13
+	}
+
+	// Trust the private CA in addition to the system roots
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no PEM certificates", cfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	// Present a client certificate for mTLS
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// tlsFilesHash fingerprints the upstream's TLS files so changes (e.g. cert-manager
+// renewals) can be detected; unreadable files hash as empty
+func tlsFilesHash(cfg config.UpstreamConfig) [32]byte {
+	h := sha256.New()
+	for _, path := range []string{cfg.CAFile, cfg.CertFile, cfg.KeyFile} {
+		if path == "" {
+			continue
+		}
+		data, _ := os.ReadFile(path)
+		h.Write([]byte(path))
+		h.Write(data)
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// authTransport adds a bearer token to requests that don't already carry credentials
+type authTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,183 @@
+package upstream
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeServerCA saves a test server's certificate as a PEM CA file
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeClientCert generates a self-signed client certificate and key
+func writeClientCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "avatar-backend"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func get(t *testing.T, c *Client, url string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Do(req, true)
+}
+
+func TestTrustsConfiguredCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Without the CA the server's certificate is untrusted
+	cfg := testConfig()
+	cfg.MaxRetries = 0
+	if _, err := get(t, newTestClient(t, cfg), server.URL); err == nil {
+		t.Fatal("Expected an untrusted certificate error")
+	}
+
+	cfg.CAFile = writeServerCA(t, server)
+	cfg.ServerName = "example.com" // httptest certificates are valid for example.com
+	resp, err := get(t, newTestClient(t, cfg), server.URL)
+	if err != nil {
+		t.Fatalf("Expected CA to be trusted, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestPresentsClientCertificate(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			t.Error("Expected a client certificate")
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	cfg := testConfig()
+	cfg.CAFile = writeServerCA(t, server)
+	cfg.CertFile, cfg.KeyFile = writeClientCert(t)
+	resp, err := get(t, newTestClient(t, cfg), server.URL)
+	if err != nil {
+		t.Fatalf("Expected mTLS request to succeed, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestAddsBearerToken(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	cfg := testConfig()
+	cfg.AuthToken = "secret"
+	c := newTestClient(t, cfg)
+	resp, err := get(t, c, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "Bearer secret" {
+		t.Errorf("Expected bearer token, got %q", got)
+	}
+
+	// Health checks use the underlying client and must be authenticated too
+	resp, err = c.HTTPClient().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "Bearer secret" {
+		t.Errorf("Expected bearer token on HTTPClient requests, got %q", got)
+	}
+}
+
+func TestReloadsChangedTLSFiles(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Start out trusting an unrelated certificate
+	cfg := testConfig()
+	cfg.MaxRetries = 0
+	cfg.CAFile, _ = writeClientCert(t)
+	c := newTestClient(t, cfg)
+	if c.reloadTLS() {
+		t.Error("Expected no reload when files are unchanged")
+	}
+	if _, err := get(t, c, server.URL); err == nil {
+		t.Fatal("Expected an untrusted certificate error")
+	}
+
+	// An invalid file keeps the current client
+	if err := os.WriteFile(cfg.CAFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	before := c.HTTPClient()
+	if c.reloadTLS() || c.HTTPClient() != before {
+		t.Error("Expected invalid CA file to be ignored")
+	}
+
+	// A renewed CA is picked up
+	data, _ := os.ReadFile(writeServerCA(t, server))
+	if err := os.WriteFile(cfg.CAFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !c.reloadTLS() {
+		t.Fatal("Expected changed CA file to be reloaded")
+	}
+	resp, err := get(t, c, server.URL)
+	if err != nil {
+		t.Fatalf("Expected reloaded CA to be trusted, got %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,272 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"christianmoore.me/avatar-backend/breaker"
+	"christianmoore.me/avatar-backend/config"
+	"christianmoore.me/avatar-backend/metrics"
+)
+
+// TLSReloadInterval is how often TLS files are checked for changes
+const TLSReloadInterval = 30 * time.Second
+
+// Client is a pooled HTTP client for one upstream service with timeouts, retries for
+// idempotent requests and a circuit breaker. It is safe for concurrent use.
+type Client struct {
+	name    string
+	cfg     config.UpstreamConfig
+	http    atomic.Pointer[http.Client] // Replaced when TLS files change
+	breaker *breaker.Breaker
+	sleep   func(ctx context.Context, d time.Duration) error
+
+	tlsHash [32]byte
+	stop    chan struct{}
+}
+
+// New creates a client for the named upstream (used in logs, metrics and errors).
+// When TLS files are configured they're watched and reloaded until Close.
+func New(name string, cfg config.UpstreamConfig) (*Client, error) {
+	c := &Client{
+		name:    name,
+		cfg:     cfg,
+		breaker: breaker.New(name, cfg.BreakerThreshold, cfg.BreakerCooldown),
+		sleep:   sleepContext,
+		stop:    make(chan struct{}),
+	}
+	client, err := c.build()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	c.http.Store(client)
+	c.tlsHash = tlsFilesHash(cfg)
+
+	if cfg.CAFile != "" || cfg.CertFile != "" {
+		go c.watchTLS(TLSReloadInterval)
+	}
+	return c, nil
+}
+
+// build creates an HTTP client from the current configuration and TLS files
+func (c *Client) build() (*http.Client, error) {
+	tc, err := tlsConfig(c.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   c.cfg.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tc,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   c.cfg.ConnectTimeout,
+		ResponseHeaderTimeout: c.cfg.ResponseHeaderTimeout,
+		MaxIdleConns:          c.cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.cfg.MaxIdleConns,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	if c.cfg.AuthToken != "" {
+		transport = &authTransport{base: transport, token: c.cfg.AuthToken}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// watchTLS rebuilds the client when TLS files change, keeping the old one if they're invalid
+func (c *Client) watchTLS(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.reloadTLS()
+		}
+	}
+}
+
+// reloadTLS swaps in a client built from changed TLS files; returns whether it did
+func (c *Client) reloadTLS() bool {
+	hash := tlsFilesHash(c.cfg)
+	if hash == c.tlsHash {
+		return false
+	}
+
+	client, err := c.build()
+	if err != nil {
+		// Files may be mid-update (e.g. cert written before key); retry next tick
+		metrics.TLSReloads.Add(c.name+"_failed", 1)
+		log.Printf("Warning: %s TLS reload failed, keeping current certificates: %v", c.name, err)
+		return false
+	}
+	c.tlsHash = hash
+	old := c.http.Swap(client)
+	old.CloseIdleConnections()
+	metrics.TLSReloads.Add(c.name+"_succeeded", 1)
+	log.Printf("Reloaded %s TLS configuration", c.name)
+	return true
+}
+
+// Close stops watching TLS files and closes idle connections
+func (c *Client) Close() {
+	close(c.stop)
+	c.http.Load().CloseIdleConnections()
+}
+
+// Name returns the upstream name
+func (c *Client) Name() string {
+	return c.name
+}
+
+// HTTPClient returns the underlying pooled client, bypassing retries and the breaker
+// (for health checks, which shouldn't trip or be blocked by it)
+func (c *Client) HTTPClient() *http.Client {
+	return c.http.Load()
+}
+
+// Breaker returns the upstream's circuit breaker
+func (c *Client) Breaker() *breaker.Breaker {
+	return c.breaker
+}
+
+// Do sends req through the circuit breaker. Idempotent requests are retried on
+// network errors, 429 and 5xx responses with exponential backoff and full jitter;
+// their body must be replayable (http.NewRequest sets GetBody for in-memory bodies).
+// The breaker counts network errors and 5xx responses after the final attempt.
+func (c *Client) Do(req *http.Request, idempotent bool) (*http.Response, error) {
+	if !c.breaker.Allow() {
+		return nil, fmt.Errorf("%s: %w", c.name, breaker.ErrOpen)
+	}
+
+	attempts := 1
+	if idempotent {
+		attempts += c.cfg.MaxRetries
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			metrics.UpstreamRetries.Add(c.name, 1)
+			delay := c.backoff(attempt)
+			log.Printf("Retrying %s request (attempt %d/%d) in %v: %v", c.name, attempt+1, attempts, delay, describe(resp, err))
+			if resp != nil {
+				drain(resp)
+			}
+			if sleepErr := c.sleep(req.Context(), delay); sleepErr != nil {
+				err, resp = sleepErr, nil
+				break
+			}
+			if req.GetBody != nil {
+				body, bodyErr := req.GetBody()
+				if bodyErr != nil {
+					err, resp = bodyErr, nil
+					break
+				}
+				req.Body = body
+			}
+		}
+
+		resp, err = c.attempt(req)
+		if !retryable(req.Context(), resp, err) {
+			break
+		}
+	}
+
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// The caller gave up (client disconnected, turn cancelled); that says nothing
+		// about the upstream either way
+		c.breaker.Release()
+	case err != nil || resp.StatusCode >= 500:
+		c.breaker.Failure()
+	default:
+		c.breaker.Success()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.name, err)
+	}
+	return resp, nil
+}
+
+// attempt sends one request, bounded by RequestTimeout when set
+func (c *Client) attempt(req *http.Request) (*http.Response, error) {
+	client := c.http.Load()
+	if c.cfg.RequestTimeout <= 0 {
+		return client.Do(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), c.cfg.RequestTimeout)
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Release the timeout once the caller has finished reading the body
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// backoff returns a random delay up to RetryBackoff * 2^(attempt-1)
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.cfg.RetryBackoff << (attempt - 1)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// retryable reports whether an attempt failed in a way worth retrying
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("status %d", resp.StatusCode)
+}
+
+// drain discards a response body so its connection can be reused
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancelBody cancels a request's timeout context when its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
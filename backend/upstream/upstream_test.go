@@ -0,0 +1,184 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"christianmoore.me/avatar-backend/breaker"
+	"christianmoore.me/avatar-backend/config"
+)
+
+func testConfig() config.UpstreamConfig {
+	return config.UpstreamConfig{
+		ConnectTimeout:        time.Second,
+		ResponseHeaderTimeout: time.Second,
+		MaxRetries:            2,
+		RetryBackoff:          time.Millisecond,
+		MaxIdleConns:          4,
+		BreakerThreshold:      2,
+		BreakerCooldown:       time.Minute,
+	}
+}
+
+// newTestClient returns a client that doesn't actually sleep between retries
+func newTestClient(t *testing.T, cfg config.UpstreamConfig) *Client {
+	t.Helper()
+	c, err := New("tts", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	c.sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	return c
+}
+
+func TestRetriesIdempotentRequests(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"input":"Hello."}` {
+			t.Errorf("Expected request body to be replayed, got %q", body)
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("audio"))
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, testConfig())
+	req, _ := http.NewRequest("POST", server.URL, bytes.NewBufferString(`{"input":"Hello."}`))
+	resp, err := c.Do(req, true)
+	if err != nil {
+		t.Fatalf("Expected retries to succeed, got %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("Expected success on the 3rd attempt, got status %d after %d calls", resp.StatusCode, calls.Load())
+	}
+	if c.Breaker().State() != breaker.StateClosed {
+		t.Errorf("Expected breaker to stay closed after an eventual success, got %s", c.Breaker().State())
+	}
+}
+
+func TestDoesNotRetryNonIdempotentRequests(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, testConfig())
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	resp, err := c.Do(req, false)
+	if err != nil {
+		t.Fatalf("Expected the 502 response to be returned, got %v", err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 1 {
+		t.Errorf("Expected a single attempt, got %d", calls.Load())
+	}
+}
+
+func TestBreakerOpensOnServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := testConfig()
+	cfg.MaxRetries = 0
+	c := newTestClient(t, cfg)
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := c.Do(req, true)
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	if _, err := c.Do(req, true); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("Expected ErrOpen after repeated 500s, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected the open breaker to stop requests, got %d calls", calls.Load())
+	}
+}
+
+func TestCancelledRequestLeavesBreakerAlone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := testConfig()
+	cfg.MaxRetries = 0
+	c := newTestClient(t, cfg)
+	fail := func() {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := c.Do(req, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// A cancelled request between two failures must not reset the failure count
+	fail()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := c.Do(req, true); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a cancelled request, got %v", err)
+	}
+	fail()
+	if state := c.Breaker().State(); state != breaker.StateOpen {
+		t.Errorf("Expected the breaker to open on the second failure, got %s", state)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release // Hang after the headers, like a stuck TTS server
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cfg := testConfig()
+	cfg.MaxRetries = 0
+	cfg.RequestTimeout = 50 * time.Millisecond
+	c := newTestClient(t, cfg)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := c.Do(req, true)
+	if err != nil {
+		t.Fatalf("Expected headers before the timeout, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("Expected reading a hung body to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the request timeout to stop the read, took %v", elapsed)
+	}
+}
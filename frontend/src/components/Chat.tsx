@@ -282,6 +282,65 @@ export default function Chat({ onSpeakingChange }: ChatProps) {
           }
           break;
 
+        case 'budget_exceeded':
+          posthog?.capture('budget_exceeded', { fallback: !message.error });
+          // With a local fallback the response continues; otherwise show the limit like an error
+          if (message.error) {
+            setMessages((prev) => [
+              ...prev,
+              {
+                role: 'assistant',
+                content: message.error,
+              },
+            ]);
+            setIsLoading(false);
+            setCurrentAssistantMessage('');
+          }
+          break;
+
+        case 'backend_switched':
+          // The next answer comes from another backend (e.g. local model during an OpenAI outage)
+          posthog?.capture('backend_switched', { backend: message.backend });
+          break;
+
+        case 'greeting':
+          // Optional welcome message (spoken audio may follow); shown once per conversation
+          setMessages((prev) =>
+            prev.length === 0 ? [{ role: 'assistant', content: message.text }] : prev
+          );
+          break;
+
+        case 'moderation_blocked':
+          // The message was refused before reaching the model (spoken audio may follow)
+          posthog?.capture('moderation_blocked');
+          setMessages((prev) => [
+            ...prev,
+            {
+              role: 'assistant',
+              content: message.text,
+            },
+          ]);
+          setIsLoading(false);
+          setCurrentAssistantMessage('');
+          break;
+
+        case 'server_draining':
+          // Server is restarting; the socket closes with 1012 and onclose reconnects.
+          // A message sent during the drain is refused, so clear the loading state.
+          posthog?.capture('server_draining');
+          if (message.error) {
+            setMessages((prev) => [
+              ...prev,
+              {
+                role: 'assistant',
+                content: message.error,
+              },
+            ]);
+            setIsLoading(false);
+            setCurrentAssistantMessage('');
+          }
+          break;
+
         case 'error':
           console.error('Server error:', message.error);
           posthog?.capture('chat_error', { error: message.error });
@@ -314,7 +373,8 @@ export default function Chat({ onSpeakingChange }: ChatProps) {
 
       // If connection failed to establish (likely auth error), refresh JWT
       // Code 1006 means abnormal closure (connection failed before opening)
-      if (event.code === 1006 && !event.wasClean) {
+      // Code 1012 means the server is restarting; a new token reconnects to another replica
+      if ((event.code === 1006 && !event.wasClean) || event.code === 1012) {
         console.log('WebSocket failed to connect, likely JWT expired. Refreshing token...');
         localStorage.removeItem('jwt_token');
         setJwtToken(null);
@@ -415,14 +475,16 @@ export default function Chat({ onSpeakingChange }: ChatProps) {
     firstTextDeltaReceivedRef.current = false;
     firstAudioDeltaReceivedRef.current = false;
 
-    // Send message via WebSocket
+    // Send message via WebSocket; when muted, ask for text only so no audio is generated
+    const modality = volumeRef.current === 0 ? 'text' : 'audio';
     wsRef.current.send(
       JSON.stringify({
         type: 'message',
         message: userMessage,
+        modality,
       })
     );
-    posthog?.capture('chat_message_sent', { message_length: userMessage.length });
+    posthog?.capture('chat_message_sent', { message_length: userMessage.length, modality });
   };
 
   return (